@@ -6,7 +6,9 @@ import (
 	"hypha/api/internal/config"
 	"hypha/api/internal/db"
 	"hypha/api/internal/http"
+	"hypha/api/internal/utils/blobs"
 	"hypha/api/internal/utils/logging"
+	"hypha/api/internal/utils/results"
 	"hypha/api/internal/utils/router"
 )
 
@@ -36,7 +38,18 @@ func main() {
 	}
 
 	dbConnWrapper := &db.DBConnWrapper{DB: dbConn}
-	http.InitRoutes(router, dbConnWrapper)
+
+	store, err := blobs.NewStore(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize attachment store")
+	}
+
+	queue := results.NewQueue(dbConnWrapper, store, cfg.Ingestion.Workers, cfg.Ingestion.QueueSize, cfg.Ingestion.MaxUploadSize, cfg.Ingestion.JobLease, results.OutputOptionsFromConfig(cfg))
+	if err := queue.Start(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to start ingestion queue")
+	}
+
+	http.InitRoutes(router, dbConnWrapper, cfg, queue, store)
 
 	port := cfg.Http.Port
 	if err := router.Run(fmt.Sprintf(":%d", port)); err != nil {
@@ -3,12 +3,42 @@ package config
 import (
 	"hypha/api/internal/utils/logging"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 var log = logging.Logger
 
+// defaultMaxUploadSize is the maximum size of a results upload when the configuration does not set one.
+const defaultMaxUploadSize = 512 << 20
+
+// defaultIngestionWorkers and defaultIngestionQueueSize size the asynchronous ingestion queue
+// when the configuration does not.
+const (
+	defaultIngestionWorkers   = 4
+	defaultIngestionQueueSize = 100
+)
+
+// defaultIngestionJobLease is how long an asynchronous ingestion job may run before another
+// instance of the API considers it abandoned and runs it again, when the configuration does not set it.
+const defaultIngestionJobLease = 30 * time.Minute
+
+// defaultMaxOutputSize and defaultOutputCompression configure the storage of system-out and
+// system-err logs when the configuration does not.
+const (
+	defaultMaxOutputSize     = 1 << 20
+	defaultOutputCompression = "gzip"
+)
+
+// defaultMaxAttachmentSize, defaultAttachmentStore and defaultAttachmentPath configure the storage
+// of test attachments when the configuration does not.
+const (
+	defaultMaxAttachmentSize = 100 << 20
+	defaultAttachmentStore   = "local"
+	defaultAttachmentPath    = "data/attachments"
+)
+
 type Config struct {
 	Database struct {
 		Host     string `yaml:"host"`
@@ -30,6 +60,32 @@ type Config struct {
 			MaxAge           int      `yaml:"max-age"`
 		} `yaml:"cors-policy"`
 	} `yaml:"http"`
+	Ingestion struct {
+		MaxUploadSize int64         `yaml:"max-upload-size"` // Maximum size of a results upload in bytes
+		Workers       int           `yaml:"workers"`         // Number of asynchronous ingestion jobs processed at once
+		QueueSize     int           `yaml:"queue-size"`      // Maximum number of asynchronous ingestion jobs waiting to be processed
+		JobLease      time.Duration `yaml:"job-lease"`       // Time after which a running job is considered abandoned and run again
+		Output        struct {
+			MaxSize     int64  `yaml:"max-size"`    // Maximum size of a stored system-out or system-err log in bytes; longer logs are truncated
+			Compression string `yaml:"compression"` // Compression of stored logs: gzip or none
+		} `yaml:"output"`
+	} `yaml:"ingestion"`
+	Attachments struct {
+		MaxSize int64  `yaml:"max-size"` // Maximum size of a single attachment in bytes
+		Store   string `yaml:"store"`    // Blob store holding the attachments: local or s3
+		Local   struct {
+			Path string `yaml:"path"` // Directory the attachments are stored in
+		} `yaml:"local"`
+		S3 struct {
+			Endpoint        string `yaml:"endpoint"` // e.g. https://s3.eu-west-1.amazonaws.com or the URL of a MinIO server
+			Region          string `yaml:"region"`
+			Bucket          string `yaml:"bucket"`
+			Prefix          string `yaml:"prefix"` // Prefix of the object keys, e.g. hypha/
+			AccessKeyID     string `yaml:"access-key-id"`
+			SecretAccessKey string `yaml:"secret-access-key"`
+			PathStyle       bool   `yaml:"path-style"` // Address the bucket in the path instead of the host name, as MinIO requires
+		} `yaml:"s3"`
+	} `yaml:"attachments"`
 }
 
 func ReadConfig(filename string) (*Config, error) {
@@ -54,5 +110,34 @@ func ReadConfig(filename string) (*Config, error) {
 		cfg.Database.Debug = false
 	}
 
+	if cfg.Ingestion.MaxUploadSize <= 0 {
+		cfg.Ingestion.MaxUploadSize = defaultMaxUploadSize
+	}
+	if cfg.Ingestion.Workers <= 0 {
+		cfg.Ingestion.Workers = defaultIngestionWorkers
+	}
+	if cfg.Ingestion.QueueSize <= 0 {
+		cfg.Ingestion.QueueSize = defaultIngestionQueueSize
+	}
+	if cfg.Ingestion.JobLease <= 0 {
+		cfg.Ingestion.JobLease = defaultIngestionJobLease
+	}
+	if cfg.Ingestion.Output.MaxSize <= 0 {
+		cfg.Ingestion.Output.MaxSize = defaultMaxOutputSize
+	}
+	if cfg.Ingestion.Output.Compression == "" {
+		cfg.Ingestion.Output.Compression = defaultOutputCompression
+	}
+
+	if cfg.Attachments.MaxSize <= 0 {
+		cfg.Attachments.MaxSize = defaultMaxAttachmentSize
+	}
+	if cfg.Attachments.Store == "" {
+		cfg.Attachments.Store = defaultAttachmentStore
+	}
+	if cfg.Attachments.Local.Path == "" {
+		cfg.Attachments.Local.Path = defaultAttachmentPath
+	}
+
 	return &cfg, nil
 }
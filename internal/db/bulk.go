@@ -0,0 +1,110 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/go-orm/gorm"
+)
+
+// maxBindVars is the maximum number of bind parameters PostgreSQL accepts in a single statement.
+const maxBindVars = 65535
+
+// DefaultBatchSize is the number of rows written per INSERT statement when callers have no better estimate.
+const DefaultBatchSize = 1000
+
+// bulkInsert inserts every element of the given slice using multi-row INSERT statements.
+// Only plain columns are written; associations are expected to be inserted separately.
+//
+// Parameters:
+//   - conn: The gorm.DB connection (or transaction) used to execute the statements.
+//   - records: A slice (or pointer to a slice) of structs or struct pointers.
+//   - batchSize: The maximum number of rows per statement. Values <= 0 use DefaultBatchSize.
+//   - suffix: A clause appended to every statement, such as ON CONFLICT DO NOTHING, or empty.
+//
+// Returns:
+//   - error: An error object if any statement fails, otherwise nil.
+func bulkInsert(conn *gorm.DB, records interface{}, batchSize int, suffix string) error {
+	slice := reflect.Indirect(reflect.ValueOf(records))
+	if slice.Kind() != reflect.Slice {
+		return fmt.Errorf("bulk insert expects a slice, got %s", slice.Kind())
+	}
+	if slice.Len() == 0 {
+		return nil
+	}
+
+	scope := conn.NewScope(recordAt(slice, 0))
+	var columns []string
+	var fields []*gorm.StructField
+	for _, field := range scope.GetModelStruct().StructFields {
+		if field.IsIgnored || !field.IsNormal {
+			continue
+		}
+		columns = append(columns, scope.Quote(field.DBName))
+		fields = append(fields, field)
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("bulk insert into %s: no insertable columns", scope.TableName())
+	}
+
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	if limit := maxBindVars / len(columns); batchSize > limit {
+		batchSize = limit
+	}
+
+	prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", scope.QuotedTableName(), strings.Join(columns, ","))
+	for start := 0; start < slice.Len(); start += batchSize {
+		end := start + batchSize
+		if end > slice.Len() {
+			end = slice.Len()
+		}
+
+		var statement strings.Builder
+		statement.WriteString(prefix)
+		vars := make([]interface{}, 0, (end-start)*len(fields))
+		for i := start; i < end; i++ {
+			if i > start {
+				statement.WriteByte(',')
+			}
+			statement.WriteByte('(')
+			record := reflect.Indirect(reflect.ValueOf(recordAt(slice, i)))
+			for j, field := range fields {
+				if j > 0 {
+					statement.WriteByte(',')
+				}
+				vars = append(vars, fieldValue(record, field).Interface())
+				fmt.Fprintf(&statement, "$%d", len(vars))
+			}
+			statement.WriteByte(')')
+		}
+		if suffix != "" {
+			statement.WriteString(" " + suffix)
+		}
+
+		if _, err := conn.CommonDB().Exec(statement.String(), vars...); err != nil {
+			return fmt.Errorf("bulk insert into %s: %w", scope.TableName(), err)
+		}
+	}
+	return nil
+}
+
+// recordAt returns a pointer to the i-th element of a slice of structs or struct pointers.
+func recordAt(slice reflect.Value, i int) interface{} {
+	element := slice.Index(i)
+	if element.Kind() == reflect.Ptr {
+		return element.Interface()
+	}
+	return element.Addr().Interface()
+}
+
+// fieldValue resolves a (possibly embedded) model field on the given struct value.
+func fieldValue(record reflect.Value, field *gorm.StructField) reflect.Value {
+	value := record
+	for _, name := range field.Names {
+		value = reflect.Indirect(value).FieldByName(name)
+	}
+	return value
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"embed"
 	"fmt"
 	"hypha/api/internal/config"
@@ -23,7 +24,8 @@ var (
 
 // DBConnWrapper wraps a gorm.DB connection.
 type DBConnWrapper struct {
-	DB *gorm.DB
+	DB         *gorm.DB
+	savepoints int // Number of savepoints enclosing the wrapper when it is bound to a transaction
 }
 
 // Connect establishes a connection to the database using the provided configuration.
@@ -81,11 +83,22 @@ var tables_slice = []interface{}{
 	&tables.Result{},
 	&tables.TestSuite{},
 	&tables.TestCase{},
+	&tables.TestCaseFailure{},
+	&tables.TestCaseRerun{},
+	&tables.TestCaseStep{},
+	&tables.Attachment{},
+	&tables.Output{},
 	&tables.Property{},
 	&tables.ResultsRule{},
+	&tables.ResultsRuleRevision{},
+	&tables.SchemaMigration{},
+	&tables.IngestionJob{},
+	&tables.IngestionJobFile{},
+	&tables.IngestionJobReport{},
 }
 
-// AutoMigrate performs database migration for all the tables defined in tables_slice.
+// AutoMigrate performs database migration for all the tables defined in tables_slice
+// and then applies any pending data migrations.
 // It returns any error encountered during the migration process.
 //
 // Parameters:
@@ -102,6 +115,10 @@ func AutoMigrate(db *gorm.DB) error {
 		}
 	}
 	createViews(db)
+	if err := runMigrations(db); err != nil {
+		log.Error().Err(err).Msg("Data migration failed")
+		return err
+	}
 	log.Info().Msg("Database migration completed successfully")
 	return nil
 }
@@ -167,3 +184,108 @@ func (wrapper *DBConnWrapper) Create(record interface{}) error {
 func (wrapper *DBConnWrapper) First(result interface{}, conditions ...interface{}) error {
 	return wrapper.DB.First(result, conditions...).Error
 }
+
+// CreateInBatches inserts a slice of records using multi-row INSERT statements.
+// Associations of the records are not saved and must be inserted separately.
+//
+// Parameters:
+//   - records: A slice of records to be inserted into the database.
+//   - batchSize: The maximum number of rows per INSERT statement.
+//
+// Returns:
+//   - error: An error object if the insertion fails, otherwise nil.
+func (wrapper *DBConnWrapper) CreateInBatches(records interface{}, batchSize int) error {
+	return bulkInsert(wrapper.DB, records, batchSize, "")
+}
+
+// CreateMissingInBatches inserts a slice of records using multi-row INSERT statements, skipping
+// records whose primary key already exists. It is meant for content addressed records, where a
+// record with the same key holds the same data.
+//
+// Parameters:
+//   - records: A slice of records to be inserted into the database.
+//   - batchSize: The maximum number of rows per INSERT statement.
+//
+// Returns:
+//   - error: An error object if the insertion fails, otherwise nil.
+func (wrapper *DBConnWrapper) CreateMissingInBatches(records interface{}, batchSize int) error {
+	return bulkInsert(wrapper.DB, records, batchSize, "ON CONFLICT DO NOTHING")
+}
+
+// Transaction runs fn inside a database transaction.
+// The transaction is committed if fn returns nil and rolled back otherwise, including when fn panics.
+// When the wrapper is already bound to a transaction, fn runs inside a savepoint instead, so only
+// the changes made by fn are rolled back if it fails.
+//
+// Parameters:
+//   - fn: The function to run. It receives a DatabaseOperations bound to the transaction.
+//
+// Returns:
+//   - error: The error returned by fn, or an error object if the transaction could not be started or committed.
+func (wrapper *DBConnWrapper) Transaction(fn func(tx DatabaseOperations) error) error {
+	if _, inTransaction := wrapper.DB.CommonDB().(*sql.Tx); inTransaction {
+		return wrapper.savepoint(fn)
+	}
+
+	tx := wrapper.DB.Begin()
+	if tx.Error != nil {
+		log.Error().Err(tx.Error).Msg("Failed to begin transaction")
+		return tx.Error
+	}
+
+	committed := false
+	defer func() {
+		if !committed {
+			if err := tx.Rollback().Error; err != nil {
+				log.Error().Err(err).Msg("Failed to roll back transaction")
+			}
+		}
+	}()
+
+	if err := fn(&DBConnWrapper{DB: tx}); err != nil {
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		log.Error().Err(err).Msg("Failed to commit transaction")
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// savepoint runs fn inside a savepoint of the transaction the wrapper is bound to.
+// The savepoint is released if fn returns nil and rolled back to otherwise, including when fn panics.
+//
+// Parameters:
+//   - fn: The function to run. It receives a DatabaseOperations bound to the same transaction.
+//
+// Returns:
+//   - error: The error returned by fn, or an error object if the savepoint could not be created or released.
+func (wrapper *DBConnWrapper) savepoint(fn func(tx DatabaseOperations) error) error {
+	name := fmt.Sprintf("savepoint_%d", wrapper.savepoints+1)
+	if err := wrapper.DB.Exec("SAVEPOINT " + name).Error; err != nil {
+		log.Error().Err(err).Msgf("Failed to create %s", name)
+		return err
+	}
+
+	released := false
+	defer func() {
+		if !released {
+			if err := wrapper.DB.Exec("ROLLBACK TO SAVEPOINT " + name).Error; err != nil {
+				log.Error().Err(err).Msgf("Failed to roll back to %s", name)
+			}
+		}
+	}()
+
+	if err := fn(&DBConnWrapper{DB: wrapper.DB, savepoints: wrapper.savepoints + 1}); err != nil {
+		return err
+	}
+
+	if err := wrapper.DB.Exec("RELEASE SAVEPOINT " + name).Error; err != nil {
+		log.Error().Err(err).Msgf("Failed to release %s", name)
+		return err
+	}
+	released = true
+	return nil
+}
@@ -0,0 +1,505 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hypha/api/internal/config"
+	"hypha/api/internal/db"
+	"hypha/api/internal/db/tables"
+	"hypha/api/internal/utils/blobs"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// storedAttachment describes the content of an uploaded attachment once it has been written to the blob store.
+type storedAttachment struct {
+	Name        string
+	ContentType string
+	Size        int64
+	ContentHash string
+	StorageKey  string
+}
+
+// apply copies the stored content to an Attachment model.
+func (stored storedAttachment) apply(attachment *tables.Attachment, uploadedAt time.Time) {
+	attachment.ContentType = stored.ContentType
+	attachment.Size = stored.Size
+	attachment.ContentHash = stored.ContentHash
+	attachment.StorageKey = stored.StorageKey
+	attachment.UploadedAt = &uploadedAt
+}
+
+// UploadTestCaseAttachments handles the upload of attachments to a test case.
+// A file matching an attachment referenced by the test case's output through [[ATTACHMENT|path]]
+// fills that attachment; other files are added as new attachments. See matchAttachmentReferences
+// for how files are matched.
+//
+// Form Fields:
+// - file (file, repeatable): The files to attach.
+// - path (string, repeatable): Optional. The referenced path of each file, in the order of the files.
+//
+// Parameters:
+// - dbOps: The database operations interface for interacting with the database.
+// - store: The blob store the content of the attachments is written to.
+// - cfg: The configuration object containing the attachments settings.
+// - context: The Gin context for the current request.
+//
+// Responses:
+// - 400 Bad Request: If no file was uploaded or the number of paths differs from the number of files.
+// - 404 Not Found: If no test case with the given ID exists.
+// - 409 Conflict: If a file sent without its path has the file name of several referenced attachments.
+// - 413 Request Entity Too Large: If a file exceeds the maximum attachment size.
+// - 201 Created: Returns the stored attachments.
+func UploadTestCaseAttachments(dbOps db.DatabaseOperations, store blobs.Store, cfg *config.Config, context *gin.Context) {
+	var testCase tables.TestCase
+	if err := dbOps.First(&testCase, "id = ?", context.Param("caseId")); err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Test case not found"})
+		return
+	}
+
+	files, paths, ok := readAttachmentForm(cfg, context)
+	if !ok {
+		return
+	}
+
+	var pending []tables.Attachment
+	if err := dbOps.Connection().Where("test_case_id = ? AND content_hash = ''", testCase.ID).Find(&pending).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// Match every file before storing any, so an ambiguous file rejects the whole upload
+	matches := make([][]tables.Attachment, len(files))
+	for i, file := range files {
+		matched, ok := takeAttachmentReferences(&pending, paths[i], file.Filename, context)
+		if !ok {
+			return
+		}
+		matches[i] = matched
+	}
+
+	attachments := make([]tables.Attachment, 0, len(files))
+	for i, file := range files {
+		stored, err := storeUploadedAttachment(store, file, context)
+		if err != nil {
+			log.Error().Err(err).Str("testCase", testCase.ID).Msg("Failed to store attachment")
+			context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		now := time.Now().UTC()
+		matched := matches[i]
+		if len(matched) == 0 {
+			matched = []tables.Attachment{{
+				ID:         db.GenerateUniqueID(),
+				TestCaseID: testCase.ID,
+				Name:       stored.Name,
+				CreatedAt:  now,
+			}}
+		}
+		for _, attachment := range matched {
+			stored.apply(&attachment, now)
+			if err := dbOps.Connection().Save(&attachment).Error; err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				return
+			}
+			attachments = append(attachments, attachment)
+		}
+	}
+
+	context.JSON(http.StatusCreated, gin.H{"attachments": attachments})
+}
+
+// UploadResultAttachments handles the upload of the files referenced by the test cases of a result
+// through [[ATTACHMENT|path]], so screenshots collected by a CI job can be uploaded next to the
+// report without knowing which test case they belong to. See matchAttachmentReferences for how
+// files are matched.
+//
+// Form Fields:
+// - file (file, repeatable): The referenced files.
+// - path (string, repeatable): Optional. The referenced path of each file, in the order of the files.
+// Multipart file names are reduced to their last element, so the path is needed whenever several
+// referenced files have the same file name.
+//
+// Parameters:
+// - dbOps: The database operations interface for interacting with the database.
+// - store: The blob store the content of the attachments is written to.
+// - cfg: The configuration object containing the attachments settings.
+// - context: The Gin context for the current request.
+//
+// Responses:
+// - 400 Bad Request: If no file was uploaded or the number of paths differs from the number of files.
+// - 404 Not Found: If no result with the given ID exists.
+// - 409 Conflict: If a file sent without its path has the file name of several referenced attachments.
+// - 413 Request Entity Too Large: If a file exceeds the maximum attachment size.
+// - 200 OK: Returns the filled attachments and the names of the files no test case referenced.
+func UploadResultAttachments(dbOps db.DatabaseOperations, store blobs.Store, cfg *config.Config, context *gin.Context) {
+	var result tables.Result
+	if err := dbOps.First(&result, "id = ?", context.Param("id")); err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Result not found"})
+		return
+	}
+
+	files, paths, ok := readAttachmentForm(cfg, context)
+	if !ok {
+		return
+	}
+
+	var pending []tables.Attachment
+	err := dbOps.Connection().
+		Select("attachments.*").
+		Joins("JOIN test_cases ON test_cases.id = attachments.test_case_id").
+		Joins("JOIN test_suites ON test_suites.id = test_cases.test_suite_id").
+		Where("test_suites.result_id = ? AND attachments.content_hash = ''", result.ID).
+		Find(&pending).Error
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	// Match every file before storing any, so an ambiguous file rejects the whole upload
+	matches := make([][]tables.Attachment, len(files))
+	for i, file := range files {
+		matched, ok := takeAttachmentReferences(&pending, paths[i], file.Filename, context)
+		if !ok {
+			return
+		}
+		matches[i] = matched
+	}
+
+	attachments := []tables.Attachment{}
+	unmatched := []string{}
+	for i, file := range files {
+		matched := matches[i]
+		if len(matched) == 0 {
+			if paths[i] != "" {
+				unmatched = append(unmatched, paths[i])
+			} else {
+				unmatched = append(unmatched, file.Filename)
+			}
+			continue
+		}
+
+		stored, err := storeUploadedAttachment(store, file, context)
+		if err != nil {
+			log.Error().Err(err).Str("result", result.ID).Msg("Failed to store attachment")
+			context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+		now := time.Now().UTC()
+		for _, attachment := range matched {
+			stored.apply(&attachment, now)
+			if err := dbOps.Connection().Save(&attachment).Error; err != nil {
+				context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				return
+			}
+			attachments = append(attachments, attachment)
+		}
+	}
+
+	context.JSON(http.StatusOK, gin.H{"attachments": attachments, "unmatched": unmatched})
+}
+
+// GetTestCaseAttachments retrieves the attachments of a test case, including attachments referenced
+// by the report whose content has not been uploaded yet.
+//
+// Parameters:
+// - dbOps: The database operations interface for interacting with the database.
+// - context: The Gin context for the current request.
+//
+// Responses:
+// - 404 Not Found: If no test case with the given ID exists.
+// - 200 OK: Returns the attachments of the test case.
+func GetTestCaseAttachments(dbOps db.DatabaseOperations, context *gin.Context) {
+	var testCase tables.TestCase
+	if err := dbOps.First(&testCase, "id = ?", context.Param("caseId")); err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Test case not found"})
+		return
+	}
+
+	attachments := []tables.Attachment{}
+	if err := dbOps.Connection().Where("test_case_id = ?", testCase.ID).Order("created_at").Find(&attachments).Error; err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	context.JSON(http.StatusOK, attachments)
+}
+
+// DownloadAttachment streams the content of an attachment from the blob store.
+// Attachments are always downloaded rather than displayed, and browsers are told not to guess
+// their type, so uploaded content cannot run in the origin of the API.
+//
+// Parameters:
+// - dbOps: The database operations interface for interacting with the database.
+// - store: The blob store holding the content of the attachments.
+// - context: The Gin context for the current request.
+//
+// Responses:
+// - 404 Not Found: If no attachment with the given ID exists or its content was never uploaded.
+// - 200 OK: Returns the content of the attachment.
+func DownloadAttachment(dbOps db.DatabaseOperations, store blobs.Store, context *gin.Context) {
+	var attachment tables.Attachment
+	if err := dbOps.First(&attachment, "id = ?", context.Param("id")); err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if attachment.ContentHash == "" {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Attachment was referenced by the report but has not been uploaded"})
+		return
+	}
+
+	content, err := store.Get(context.Request.Context(), attachment.StorageKey)
+	if err != nil {
+		if errors.Is(err, blobs.ErrNotFound) {
+			context.JSON(http.StatusNotFound, gin.H{"error": "Attachment content not found"})
+			return
+		}
+		log.Error().Err(err).Str("attachment", attachment.ID).Msg("Failed to read attachment")
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	defer content.Close()
+
+	headers := map[string]string{
+		"Content-Disposition":    mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}),
+		"ETag":                   strconv.Quote(attachment.ContentHash),
+		"X-Content-Type-Options": "nosniff",
+	}
+	context.DataFromReader(http.StatusOK, attachment.Size, servedContentType(attachment.ContentType), content, headers)
+}
+
+// readAttachmentForm reads the files of an attachment upload and the referenced path sent for each
+// of them, empty when no paths are sent, and checks their size. The whole request is limited to the
+// maximum upload size and every file to the maximum attachment size.
+// An error response is written when it returns false.
+func readAttachmentForm(cfg *config.Config, context *gin.Context) ([]*multipart.FileHeader, []string, bool) {
+	context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, cfg.Ingestion.MaxUploadSize)
+	form, err := context.MultipartForm()
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Upload exceeds the maximum size of %d bytes", maxBytesError.Limit)})
+			return nil, nil, false
+		}
+		context.JSON(http.StatusBadRequest, gin.H{"error": "File upload failed"})
+		return nil, nil, false
+	}
+
+	files := form.File["file"]
+	if len(files) == 0 {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "File upload failed"})
+		return nil, nil, false
+	}
+	for _, file := range files {
+		if file.Size > cfg.Attachments.MaxSize {
+			context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Attachment %s exceeds the maximum size of %d bytes", file.Filename, cfg.Attachments.MaxSize)})
+			return nil, nil, false
+		}
+	}
+
+	paths := form.Value["path"]
+	if len(paths) == 0 {
+		paths = make([]string, len(files))
+	} else if len(paths) != len(files) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Expected one path for each of the %d files, got %d", len(files), len(paths))})
+		return nil, nil, false
+	}
+	return files, paths, true
+}
+
+// storeUploadedAttachment writes an uploaded file to the blob store.
+// The file is stored under a key derived from its SHA-256, so a file uploaded several times is stored once.
+//
+// Parameters:
+// - store: The blob store the file is written to.
+// - file: The header of the uploaded file.
+// - context: The Gin context for the current request.
+//
+// Returns:
+// - storedAttachment: The name, content type, size, hash and storage key of the stored file.
+// - error: An error if the file cannot be read or written to the store.
+func storeUploadedAttachment(store blobs.Store, file *multipart.FileHeader, context *gin.Context) (storedAttachment, error) {
+	content, err := file.Open()
+	if err != nil {
+		return storedAttachment{}, err
+	}
+	defer content.Close()
+
+	head := make([]byte, 512)
+	headSize, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return storedAttachment{}, err
+	}
+
+	hash := sha256.New()
+	hash.Write(head[:headSize])
+	if _, err := io.Copy(hash, content); err != nil {
+		return storedAttachment{}, err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return storedAttachment{}, err
+	}
+	contentHash := hex.EncodeToString(hash.Sum(nil))
+
+	stored := storedAttachment{
+		Name:        path.Base(filepath.ToSlash(file.Filename)),
+		ContentType: attachmentContentType(head[:headSize], file),
+		Size:        file.Size,
+		ContentHash: contentHash,
+		StorageKey:  "attachments/" + contentHash[:2] + "/" + contentHash,
+	}
+	if err := store.Put(context.Request.Context(), stored.StorageKey, content, stored.Size, stored.ContentType); err != nil {
+		return storedAttachment{}, err
+	}
+	return stored, nil
+}
+
+// safeAttachmentTypes lists the content types attachments are stored and served with. None of them
+// can run scripts when a browser opens it; any other content, such as HTML or SVG, is stored and
+// served as application/octet-stream.
+var safeAttachmentTypes = map[string]bool{
+	"image/png":        true,
+	"image/jpeg":       true,
+	"image/gif":        true,
+	"image/webp":       true,
+	"image/bmp":        true,
+	"video/mp4":        true,
+	"video/webm":       true,
+	"text/plain":       true,
+	"application/json": true,
+}
+
+// attachmentContentType returns the content type an uploaded file is stored with. The type is
+// sniffed from the content rather than taken from the client, and only types of safeAttachmentTypes
+// are kept. JSON sniffs as text, so text declared or named as JSON is kept as JSON.
+//
+// Parameters:
+// - head: The first bytes of the file, up to 512.
+// - file: The header of the uploaded file.
+//
+// Returns:
+// - string: The content type of the file.
+func attachmentContentType(head []byte, file *multipart.FileHeader) string {
+	sniffed := http.DetectContentType(head)
+	mediaType, _, err := mime.ParseMediaType(sniffed)
+	if err != nil || !safeAttachmentTypes[mediaType] {
+		return "application/octet-stream"
+	}
+	if mediaType == "text/plain" {
+		declared, _, _ := mime.ParseMediaType(file.Header.Get("Content-Type"))
+		if declared == "application/json" || path.Ext(file.Filename) == ".json" {
+			return "application/json"
+		}
+	}
+	return sniffed
+}
+
+// servedContentType returns the content type an attachment is served with. Attachments stored
+// before content types were sniffed may hold any type the client sent.
+func servedContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !safeAttachmentTypes[mediaType] {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// errAmbiguousAttachment is returned by matchAttachmentReferences when a file name matches several
+// referenced attachments.
+var errAmbiguousAttachment = errors.New("file name matches several referenced attachments")
+
+// matchAttachmentReferences returns the indexes of the pending attachments an uploaded file fills.
+// When the referenced path of the file is known, it fills every attachment referencing exactly that
+// path. Otherwise only the file name is known, since multipart readers reduce file names to their
+// last element, and it fills the single attachment whose referenced path has that file name.
+// Windows paths are compared with slashes.
+//
+// Parameters:
+// - attachments: The attachments referenced by the report and not uploaded yet.
+// - referencedPath: The referenced path sent with the file, or an empty string.
+// - fileName: The name of the uploaded file.
+//
+// Returns:
+// - []int: The indexes of the matching attachments, empty if none matches.
+// - error: errAmbiguousAttachment if only the file name is known and several attachments have it.
+func matchAttachmentReferences(attachments []tables.Attachment, referencedPath string, fileName string) ([]int, error) {
+	var matches []int
+	if referencedPath != "" {
+		referencedPath = normalizeAttachmentPath(referencedPath)
+		for i, attachment := range attachments {
+			if attachment.Path != "" && normalizeAttachmentPath(attachment.Path) == referencedPath {
+				matches = append(matches, i)
+			}
+		}
+		return matches, nil
+	}
+
+	fileName = path.Base(normalizeAttachmentPath(fileName))
+	matchedPath := ""
+	for i, attachment := range attachments {
+		if attachment.Path == "" {
+			continue
+		}
+		attachmentPath := normalizeAttachmentPath(attachment.Path)
+		if path.Base(attachmentPath) != fileName {
+			continue
+		}
+		// References to the same path are the same file
+		if len(matches) > 0 && attachmentPath != matchedPath {
+			return nil, errAmbiguousAttachment
+		}
+		matchedPath = attachmentPath
+		matches = append(matches, i)
+	}
+	return matches, nil
+}
+
+// takeAttachmentReferences removes the pending attachments an uploaded file fills and returns them.
+// A 409 response listing the candidate paths is written when it returns false.
+func takeAttachmentReferences(pending *[]tables.Attachment, referencedPath string, fileName string, context *gin.Context) ([]tables.Attachment, bool) {
+	indexes, err := matchAttachmentReferences(*pending, referencedPath, fileName)
+	if err != nil {
+		candidates := []string{}
+		for _, attachment := range *pending {
+			if attachment.Path != "" && path.Base(normalizeAttachmentPath(attachment.Path)) == path.Base(normalizeAttachmentPath(fileName)) {
+				candidates = append(candidates, attachment.Path)
+			}
+		}
+		context.JSON(http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("File %s matches several referenced attachments, send its referenced path in the path field", fileName),
+			"paths": candidates,
+		})
+		return nil, false
+	}
+
+	matched := make([]tables.Attachment, 0, len(indexes))
+	remaining := make([]tables.Attachment, 0, len(*pending))
+	next := 0
+	for i, attachment := range *pending {
+		if next < len(indexes) && indexes[next] == i {
+			matched = append(matched, attachment)
+			next++
+			continue
+		}
+		remaining = append(remaining, attachment)
+	}
+	*pending = remaining
+	return matched, true
+}
+
+// normalizeAttachmentPath writes a referenced path with slashes and without a leading "./".
+func normalizeAttachmentPath(referencedPath string) string {
+	return strings.TrimPrefix(strings.ReplaceAll(referencedPath, "\\", "/"), "./")
+}
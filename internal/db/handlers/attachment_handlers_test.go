@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"hypha/api/internal/db/tables"
+	"mime/multipart"
+	"net/textproto"
+	"reflect"
+	"testing"
+)
+
+func TestMatchAttachmentReferences(t *testing.T) {
+	attachments := []tables.Attachment{
+		{Path: "screenshots/testA/failure.png"},
+		{Path: "screenshots/testB/failure.png"},
+		{Path: `logs\run.log`},
+		{Path: "./shared/trace.zip"},
+		{Path: "shared/trace.zip"},
+		{Path: ""},
+	}
+
+	tests := []struct {
+		name           string
+		referencedPath string
+		fileName       string
+		want           []int
+		ambiguous      bool
+	}{
+		{name: "full path", referencedPath: "screenshots/testB/failure.png", fileName: "failure.png", want: []int{1}},
+		{name: "windows path", referencedPath: "logs/run.log", fileName: "run.log", want: []int{2}},
+		{name: "unknown path", referencedPath: "screenshots/testC/failure.png", fileName: "failure.png"},
+		{name: "ambiguous file name", fileName: "failure.png", ambiguous: true},
+		{name: "unique file name", fileName: "run.log", want: []int{2}},
+		{name: "file name of references to the same path", fileName: "trace.zip", want: []int{3, 4}},
+		{name: "unknown file name", fileName: "other.png"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := matchAttachmentReferences(attachments, test.referencedPath, test.fileName)
+			if test.ambiguous {
+				if err != errAmbiguousAttachment {
+					t.Fatalf("err = %v, want errAmbiguousAttachment", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(got) != 0 || len(test.want) != 0 {
+				if !reflect.DeepEqual(got, test.want) {
+					t.Errorf("matches = %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAttachmentContentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name     string
+		head     []byte
+		fileName string
+		declared string
+		want     string
+	}{
+		{name: "png", head: png, fileName: "failure.png", declared: "image/png", want: "image/png"},
+		{name: "png declared as html", head: png, fileName: "failure.html", declared: "text/html", want: "image/png"},
+		{name: "html declared as png", head: []byte("<html><script>alert(1)</script>"), fileName: "failure.png", declared: "image/png", want: "application/octet-stream"},
+		{name: "svg", head: []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"></svg>`), fileName: "chart.svg", declared: "image/svg+xml", want: "application/octet-stream"},
+		{name: "text", head: []byte("line 1\nline 2\n"), fileName: "run.log", want: "text/plain; charset=utf-8"},
+		{name: "json", head: []byte(`{"log": {"entries": []}}`), fileName: "network.har", declared: "application/json", want: "application/json"},
+		{name: "binary", head: []byte{0, 1, 2, 3}, fileName: "trace.zip", want: "application/octet-stream"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			header := textproto.MIMEHeader{}
+			if test.declared != "" {
+				header.Set("Content-Type", test.declared)
+			}
+			file := &multipart.FileHeader{Filename: test.fileName, Header: header}
+			if got := attachmentContentType(test.head, file); got != test.want {
+				t.Errorf("attachmentContentType = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
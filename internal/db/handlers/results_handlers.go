@@ -1,24 +1,46 @@
 package handlers
 
 import (
-	"encoding/xml"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hypha/api/internal/config"
 	"hypha/api/internal/db"
 	"hypha/api/internal/db/tables"
 	"hypha/api/internal/utils/db/queries"
 	"hypha/api/internal/utils/results"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-orm/gorm"
 )
 
 // GetResultsByRelationID retrieves test results based on the relation ID.
 // It fetches the test suite and test case IDs, retrieves the test suites, filters the test cases,
 // and fetches the results and associated products from the database.
+// Results are ordered by the time their tests ran, most recent first.
+// The system-out and system-err logs are left out unless includeOutput is set; they can be fetched
+// one at a time through GetOutput.
 //
 // Parameters:
 // - dbOps: The database operations interface for interacting with the database.
 // - context: The Gin context for the current request.
+//
+// Query Parameters:
+// - includeOutput (bool): Optional. Include the system-out and system-err logs in the response.
+// - asOf (string): Optional. Evaluate the rules of the relationship as they stood at this RFC 3339 time.
+// - revision (string): Optional. Evaluate the rules of the relationship as they stood when the rule
+// revision with this ID was recorded. Cannot be combined with asOf.
+//
+// Responses:
+// - 400 Bad Request: If asOf is not a valid time or both asOf and revision are given.
+// - 404 Not Found: If the rule revision does not exist.
+// - 200 OK: Returns the results selected by the rules.
 func GetResultsByRelationID(dbOps db.DatabaseOperations, context *gin.Context) {
 	relationID := context.Param("id")
 	db := dbOps.Connection()
@@ -28,7 +50,18 @@ func GetResultsByRelationID(dbOps db.DatabaseOperations, context *gin.Context) {
 		return
 	}
 
-	rules, err := queries.FetchRulesByRelationID(dbOps, relationID)
+	asOf, asOfGiven, ok := rulesAsOf(dbOps, context)
+	if !ok {
+		return
+	}
+
+	var rules []*tables.ResultsRule
+	var err error
+	if asOfGiven {
+		rules, err = queries.FetchRulesAsOf(dbOps, relationID, asOf)
+	} else {
+		rules, err = queries.FetchRulesByRelationID(dbOps, relationID)
+	}
 	if err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
@@ -39,16 +72,66 @@ func GetResultsByRelationID(dbOps db.DatabaseOperations, context *gin.Context) {
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
+	if includeOutput(context) {
+		if err := queries.FillOutputs(db, results); err != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+	}
 
 	context.JSON(http.StatusOK, results)
 }
 
+// rulesAsOf reads the point in time the rules of a relationship are evaluated at from the asOf or
+// revision query parameter, and writes the error response if it cannot be read.
+//
+// Parameters:
+// - dbOps: The database operations interface for interacting with the database.
+// - context: The Gin context for the current request.
+//
+// Returns:
+// - time.Time: The point in time.
+// - bool: Whether a point in time was given; the current rules are evaluated otherwise.
+// - bool: Whether the parameters are valid. The response is written when they are not.
+func rulesAsOf(dbOps db.DatabaseOperations, context *gin.Context) (time.Time, bool, bool) {
+	asOfParam := context.Query("asOf")
+	revisionID := context.Query("revision")
+
+	switch {
+	case asOfParam != "" && revisionID != "":
+		context.JSON(http.StatusBadRequest, gin.H{"error": "asOf and revision cannot be combined"})
+		return time.Time{}, false, false
+	case asOfParam != "":
+		asOf, err := time.Parse(time.RFC3339Nano, asOfParam)
+		if err != nil {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid asOf: expected an RFC 3339 time"})
+			return time.Time{}, false, false
+		}
+		return asOf, true, true
+	case revisionID != "":
+		var revision tables.ResultsRuleRevision
+		if err := dbOps.First(&revision, "id = ?", revisionID); err != nil {
+			context.JSON(http.StatusNotFound, gin.H{"error": "ResultsRuleRevision not found"})
+			return time.Time{}, false, false
+		}
+		return revision.CreatedAt, true, true
+	}
+	return time.Time{}, false, true
+}
+
 // GetResultsByProductID retrieves test results based on the product ID.
-// It fetches the results and associated test suites, test cases, and properties from the database.
+// It fetches the results and associated test suites, test cases, failures, reruns, steps, and properties from the database.
+// Results are ordered by the time their tests ran, most recent first.
 //
 // Parameters:
 // - dbOps: The database operations interface for interacting with the database.
 // - context: The Gin context for the current request.
+//
+// Query Parameters:
+// - commit, branch, pipeline, buildID, buildURL, environment (string): Optional. Only return results
+// whose run metadata field equals the given value.
+// - includeOutput (bool): Optional. Include the system-out and system-err logs in the response.
+// They are left out by default and can be fetched one at a time through GetOutput.
 func GetResultsByProductID(dbOps db.DatabaseOperations, context *gin.Context) {
 	productId := context.Param("productId")
 	if productId == "" {
@@ -58,31 +141,139 @@ func GetResultsByProductID(dbOps db.DatabaseOperations, context *gin.Context) {
 
 	var results []tables.Result
 
-	db := dbOps.Connection()
+	db := dbOps.Connection().Where("product_id = ?", productId)
+	for name, column := range tables.RunMetadataColumns {
+		if value := context.Query(name); value != "" {
+			db = db.Where(column+" = ?", value)
+		}
+	}
 
-	if err := db.Where("product_id = ?", productId).
+	if err := db.
 		Preload("TestSuites").
 		Preload("TestSuites.TestCases").
 		Preload("TestSuites.Properties").
 		Preload("TestSuites.TestCases.Properties").
+		Preload("TestSuites.TestCases.Failures").
+		Preload("TestSuites.TestCases.Reruns").
+		Preload("TestSuites.TestCases.Steps").
+		Preload("TestSuites.TestCases.Attachments").
+		Order(tables.ResultsHistoryOrder).
 		Find(&results).Error; err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
+	if includeOutput(context) {
+		if err := queries.FillOutputs(dbOps.Connection(), results); err != nil {
+			context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+			return
+		}
+	}
 
 	context.JSON(http.StatusOK, results)
 }
 
+// GetResultCTRF renders a stored result as a report in the Common Test Report Format (CTRF),
+// so tools that consume CTRF can read the results stored in hypha.
+//
+// Parameters:
+// - dbOps: The database operations interface for interacting with the database.
+// - context: The Gin context for the current request.
+//
+// Responses:
+// - 404 Not Found: If no result with the given ID exists.
+// - 200 OK: Returns the CTRF report of the result.
+func GetResultCTRF(dbOps db.DatabaseOperations, context *gin.Context) {
+	var result tables.Result
+
+	err := dbOps.Connection().
+		Preload("TestSuites").
+		Preload("TestSuites.TestCases").
+		Preload("TestSuites.TestCases.Properties").
+		Preload("TestSuites.TestCases.Failures", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position")
+		}).
+		Preload("TestSuites.TestCases.Reruns").
+		Preload("TestSuites.TestCases.Steps", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position")
+		}).
+		Where("id = ?", context.Param("id")).
+		First(&result).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			context.JSON(http.StatusNotFound, gin.H{"error": "Result not found"})
+			return
+		}
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	exported := []tables.Result{result}
+	if err := queries.FillOutputs(dbOps.Connection(), exported); err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	context.JSON(http.StatusOK, results.ExportCTRF(exported[0]))
+}
+
 // ReportResults handles the reporting of test results.
-// It processes the uploaded JUnit XML file, parses the results, and stores them in the database.
+// It streams every uploaded report into the database as it is parsed and stores all of
+// them as a single result together with the run metadata of the upload. Gzip-compressed files,
+// zip archives and tar archives are expanded server-side. Files that cannot be parsed are reported
+// individually instead of failing the whole upload, and so are reports without any test suite,
+// such as an HTML error page. Reports without test cases, or whose declared totals do not match
+// the test cases they contain, are stored with warnings. Uploads larger than the configured
+// maximum upload size are rejected.
+//
+// Form Fields:
+// - productId (string): The ID of the product the results belong to.
+// - file (file, repeatable): A report, or a .gz, .zip or .tar.gz archive of reports. JUnit XML,
+// go test -json output, TRX, TAP, Cucumber JSON, CTRF, TestNG and NUnit 3 reports are accepted.
+// - format (string): Optional. The format of the reports ("junit", "gotest", "trx", "tap",
+// "cucumber", "ctrf", "testng" or "nunit"). Detected from the content of every report when omitted.
+// - metadata (string or file): Optional. A JSON object with the run metadata of the upload.
+// - commit, branch, pipeline, buildID, buildURL, environment (string): Optional. Run metadata fields,
+// taking precedence over the values in metadata.
+// - executedAt (string): Optional. When the tests ran, as an RFC 3339 timestamp. Takes precedence over
+// the timestamps declared by the reports, e.g. when backfilling historical reports.
+// - async (bool): Optional. Queue the upload for asynchronous ingestion instead of storing it before
+// responding. Can also be sent as a query parameter.
+//
+// Headers:
+// - Idempotency-Key (string): Optional. Identifies the upload so retries return the result stored
+// by the first attempt. Without it, retries are recognized by the content hash of the files.
+//
+// Responses:
+// - 400 Bad Request: If the form is invalid, executedAt is not a timestamp, the format is not supported or none of the uploaded
+// files contains a valid report.
+// - 409 Conflict: If the Idempotency-Key was already used for an upload with different content.
+// - 413 Request Entity Too Large: If the upload exceeds the maximum upload size.
+// - 503 Service Unavailable: If the upload is asynchronous and the ingestion queue is full.
+// - 202 Accepted: If the upload is asynchronous, returns the ID of the ingestion job.
+// - 200 OK: If the results are stored or the upload repeats an earlier one, returns the result ID,
+// the content hash, whether the upload was a duplicate, the totals of the result and, for every
+// report, its format, the number of suites and test cases stored, and its error or warnings.
 //
 // Parameters:
 // - dbOps: The database operations interface for interacting with the database.
+// - cfg: The configuration object containing the ingestion settings.
+// - queue: The queue processing asynchronous uploads.
 // - context: The Gin context for the current request.
-func ReportResults(dpOps db.DatabaseOperations, context *gin.Context) {
-	var junitTestSuites results.JUnitTestSuites
+func ReportResults(dpOps db.DatabaseOperations, cfg *config.Config, queue *results.Queue, context *gin.Context) {
 	var product tables.Product
 
+	context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, cfg.Ingestion.MaxUploadSize)
+	form, err := context.MultipartForm()
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			context.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Upload exceeds the maximum size of %d bytes", maxBytesError.Limit)})
+			return
+		}
+		context.JSON(http.StatusBadRequest, gin.H{"error": "File upload failed"})
+		return
+	}
+
 	productId := context.PostForm("productId")
 	if productId == "" {
 		context.JSON(http.StatusBadRequest, gin.H{"error": "productId is required"})
@@ -99,38 +290,299 @@ func ReportResults(dpOps db.DatabaseOperations, context *gin.Context) {
 		return
 	}
 
-	file, err := context.FormFile("file")
+	metadata, err := parseRunMetadata(context)
 	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid run metadata"})
+		return
+	}
+
+	var executedAt *time.Time
+	if value := context.PostForm("executedAt"); value != "" {
+		if executedAt, err = results.ParseExecutedAt(value); err != nil {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid executedAt: " + err.Error()})
+			return
+		}
+	}
+
+	format := context.PostForm("format")
+	if format != "" && !results.ValidFormat(format) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported report format", "formats": results.Formats})
+		return
+	}
+
+	files := form.File["file"]
+	if len(files) == 0 {
 		context.JSON(http.StatusBadRequest, gin.H{"error": "File upload failed"})
 		return
 	}
 
-	fileContent, err := file.Open()
+	upload := results.Upload{
+		ProductID:      productId,
+		Metadata:       metadata,
+		IdempotencyKey: context.GetHeader("Idempotency-Key"),
+		Format:         format,
+		MaxReportSize:  cfg.Ingestion.MaxUploadSize,
+		ExecutedAt:     executedAt,
+		Output:         results.OutputOptionsFromConfig(cfg),
+	}
+
+	if isAsyncUpload(context) {
+		submitUpload(queue, upload, files, context)
+		return
+	}
+
+	if upload.ContentHash, err = hashUpload(files, upload); err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	summary, err := results.Ingest(dpOps, upload, func(ingestion *results.Ingestion) error {
+		for _, file := range files {
+			if err := addUploadedFile(ingestion, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
+		var parseError *results.ParseError
+		if errors.As(err, &parseError) {
+			context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid report format", "files": summary.Files})
+			return
+		}
+		if errors.Is(err, results.ErrIdempotencyKeyReused) {
+			context.JSON(http.StatusConflict, gin.H{"error": "Idempotency-Key was already used for a different upload"})
+			return
+		}
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
-	defer fileContent.Close()
 
-	byteValue, err := io.ReadAll(fileContent)
+	context.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"resultId":    summary.Result.ID,
+		"contentHash": summary.Result.ContentHash,
+		"duplicate":   summary.Duplicate,
+		"tests":       summary.Result.Tests,
+		"failures":    summary.Result.Failures,
+		"errors":      summary.Result.Errors,
+		"skipped":     summary.Result.Skipped,
+		"files":       summary.Files,
+	})
+}
+
+// isAsyncUpload reports whether the client asked for asynchronous ingestion
+// through the "async" query parameter or form field.
+//
+// Parameters:
+// - context: The Gin context for the current request.
+//
+// Returns:
+// - bool: Whether the upload should be queued.
+func isAsyncUpload(context *gin.Context) bool {
+	value := context.Query("async")
+	if value == "" {
+		value = context.PostForm("async")
+	}
+	async, err := strconv.ParseBool(value)
+	return err == nil && async
+}
+
+// includeOutput reports whether the includeOutput query parameter asks for system-out and
+// system-err logs to be included in a results response.
+//
+// Parameters:
+// - context: The Gin context for the current request.
+//
+// Returns:
+// - bool: Whether the logs should be included.
+func includeOutput(context *gin.Context) bool {
+	include, err := strconv.ParseBool(context.Query("includeOutput"))
+	return err == nil && include
+}
+
+// submitUpload queues the uploaded files as an ingestion job. The files are streamed to the blob
+// store rather than read into memory, and hashed on the way.
+//
+// Parameters:
+// - queue: The queue processing asynchronous uploads.
+// - upload: The product and run metadata of the upload.
+// - files: The headers of the uploaded files.
+// - context: The Gin context for the current request.
+//
+// Responses:
+// - 503 Service Unavailable: If the ingestion queue is full.
+// - 202 Accepted: If the job is queued, returns the ID of the job.
+func submitUpload(queue *results.Queue, upload results.Upload, files []*multipart.FileHeader, context *gin.Context) {
+	jobFiles := make([]results.JobFile, 0, len(files))
+	for _, file := range files {
+		jobFiles = append(jobFiles, results.JobFile{Name: file.Filename, Size: file.Size, Open: openUploadedFile(file)})
+	}
+
+	job, err := queue.Submit(upload, jobFiles)
 	if err != nil {
+		if errors.Is(err, results.ErrQueueFull) {
+			context.JSON(http.StatusServiceUnavailable, gin.H{"error": "Ingestion queue is full, retry later"})
+			return
+		}
+		log.Error().Err(err).Msg("Failed to queue ingestion job")
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	if !results.ContainsTestsuitesTag(byteValue) {
-		byteValue = results.WrapInTestsuitesTag(byteValue)
+	context.Header("Location", "/results/jobs/"+job.ID)
+	context.JSON(http.StatusAccepted, gin.H{"status": "accepted", "jobId": job.ID})
+}
+
+// GetIngestionJob retrieves the state of an asynchronous ingestion job,
+// including its uploaded files and the outcome of every report read so far.
+//
+// Parameters:
+// - dbOps: The database operations interface for interacting with the database.
+// - context: The Gin context for the current request.
+//
+// Responses:
+// - 404 Not Found: If no job with the given ID exists.
+// - 200 OK: Returns the job.
+func GetIngestionJob(dbOps db.DatabaseOperations, context *gin.Context) {
+	var job tables.IngestionJob
+
+	err := dbOps.Connection().
+		Preload("Files", func(db *gorm.DB) *gorm.DB {
+			return db.Select("id, job_id, position, name, size").Order("position")
+		}).
+		Preload("Reports", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position")
+		}).
+		Where("id = ?", context.Param("id")).
+		First(&job).Error
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			context.JSON(http.StatusNotFound, gin.H{"error": "Ingestion job not found"})
+			return
+		}
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
 	}
 
-	if err := xml.Unmarshal(byteValue, &junitTestSuites); err != nil {
-		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid XML format. Not JUnit"})
+	context.JSON(http.StatusOK, job)
+}
+
+// hashUpload computes the content hash of a synchronous upload, which is needed before its reports
+// are read to detect a repeated upload.
+//
+// Parameters:
+// - files: The headers of the uploaded files.
+// - upload: The upload, whose metadata, execution time and format are hashed.
+//
+// Returns:
+// - string: The hex encoded content hash.
+// - error: An error if a file cannot be read.
+func hashUpload(files []*multipart.FileHeader, upload results.Upload) (string, error) {
+	digests := make([][]byte, 0, len(files))
+	for _, file := range files {
+		content, err := file.Open()
+		if err != nil {
+			return "", err
+		}
+		hash := sha256.New()
+		_, err = io.Copy(hash, content)
+		content.Close()
+		if err != nil {
+			return "", err
+		}
+		digests = append(digests, hash.Sum(nil))
+	}
+	return results.UploadContentHash(digests, upload)
+}
+
+// openUploadedFile returns a function opening an uploaded multipart file, for a job file.
+func openUploadedFile(file *multipart.FileHeader) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return file.Open()
+	}
+}
+
+// addUploadedFile opens an uploaded multipart file and adds it to the ingestion.
+//
+// Parameters:
+// - ingestion: The ingestion the file is added to.
+// - file: The header of the uploaded file.
+//
+// Returns:
+// - error: An error if the file cannot be opened or saving its reports fails.
+func addUploadedFile(ingestion *results.Ingestion, file *multipart.FileHeader) error {
+	content, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	return ingestion.AddFile(file.Filename, content, file.Size)
+}
+
+// parseRunMetadata reads the run metadata of an upload from the request form.
+// The metadata can be sent as a JSON object in a "metadata" form field or file part,
+// and as individual form fields, which take precedence over the JSON object.
+//
+// Parameters:
+// - context: The Gin context for the current request.
+//
+// Returns:
+// - tables.RunMetadata: The run metadata of the upload.
+// - error: An error if the metadata JSON object cannot be read or decoded.
+func parseRunMetadata(context *gin.Context) (tables.RunMetadata, error) {
+	var metadata tables.RunMetadata
+
+	if raw := context.PostForm("metadata"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &metadata); err != nil {
+			return metadata, err
+		}
+	} else if part, err := context.FormFile("metadata"); err == nil {
+		content, err := part.Open()
+		if err != nil {
+			return metadata, err
+		}
+		defer content.Close()
+		if err := json.NewDecoder(content).Decode(&metadata); err != nil {
+			return metadata, err
+		}
+	}
+
+	for name := range tables.RunMetadataColumns {
+		if value := context.PostForm(name); value != "" {
+			metadata.Set(name, value)
+		}
+	}
+	return metadata, nil
+}
+
+// GetOutput returns a stored system-out or system-err log as plain text. Results only reference
+// their logs by ID, so clients fetch the logs they want to show through this endpoint.
+//
+// Parameters:
+// - dbOps: The database operations interface for interacting with the database.
+// - context: The Gin context for the current request.
+//
+// Responses:
+// - 404 Not Found: If no log with the given ID exists.
+// - 200 OK: Returns the log. The X-Output-Size header holds the size of the complete log and
+// X-Output-Truncated whether its middle was cut before it was stored.
+func GetOutput(dbOps db.DatabaseOperations, context *gin.Context) {
+	var output tables.Output
+	if err := dbOps.First(&output, "id = ?", context.Param("id")); err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Output not found"})
 		return
 	}
 
-	if err := results.ParseJUnitResults(junitTestSuites, dpOps, productId); err != nil {
+	text, err := output.Text()
+	if err != nil {
+		log.Error().Err(err).Str("output", output.ID).Msg("Failed to decompress output")
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
 	}
 
-	context.JSON(http.StatusOK, gin.H{"status": "success"})
+	context.Header("ETag", strconv.Quote(output.ID))
+	context.Header("X-Output-Size", strconv.FormatInt(output.Size, 10))
+	context.Header("X-Output-Truncated", strconv.FormatBool(output.Truncated))
+	context.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(text))
 }
@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"hypha/api/internal/db"
 	"hypha/api/internal/db/tables"
+	"hypha/api/internal/utils"
 	"hypha/api/internal/utils/db/queries"
 	"hypha/api/internal/utils/logging"
+	"hypha/api/internal/utils/rules"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lib/pq"
@@ -14,8 +17,29 @@ import (
 
 var log = logging.Logger
 
+// changedByHeader is the request header naming who changes a results rule. It is recorded with
+// the revision of the rule.
+const changedByHeader = "X-Changed-By"
+
+// Number of results a draft rule is evaluated against by PreviewResultsRule, unless the request
+// asks for another number, and the largest number a request can ask for.
+const (
+	defaultPreviewResults = 20
+	maxPreviewResults     = 200
+)
+
+// resultsRuleBody is the request body creating or replacing a results rule.
+type resultsRuleBody struct {
+	Expression string   `json:"expression"`
+	AppliesTo  []string `json:"appliesTo"`
+	RunFilters []string `json:"runFilters"`
+	RelationId string   `json:"relationId"`
+	ObjectId   string   `json:"objectId"`
+}
+
 // CreateResultsRule handles the creation of a new results rule.
-// It generates a unique ID for the new rule and creates the rule in the database.
+// It generates a unique ID for the new rule, creates the rule in the database and records it as
+// the first revision of the rule.
 //
 // Parameters:
 // - dbOps: The database operations interface used for database interactions.
@@ -23,15 +47,22 @@ var log = logging.Logger
 //
 // Request Body:
 // The request body should be a JSON object containing the fields required for a ResultsRule.
+// The expression selects suites or cases, e.g. `suite = "Payments*" and status != skipped`; see the
+// rules package for its syntax. The optional runFilters field restricts the rule to results whose
+// run metadata matches, e.g. ["branch=main", "environment=staging*"]. The optional objectId field
+// restricts the rule to the results of one object of the relationship; by default the rule applies
+// to the results of every object of the relationship.
+//
+// Headers:
+// - X-Changed-By: Optional. Who creates the rule, recorded with the revision.
 //
 // Responses:
+// - 400 Bad Request: If the request body is invalid, a run filter is malformed, the expression is
+// invalid or objectId is not an object of the relationship. Syntax errors include the byte offset
+// of the error in the expression.
 // - 201 Created: If the results rule is successfully created.
 func CreateResultsRule(dbOps db.DatabaseOperations, context *gin.Context) {
-	var requestBody struct {
-		Expression string   `json:"expression"`
-		AppliesTo  []string `json:"appliesTo"`
-		RelationId string   `json:"relationId"`
-	}
+	var requestBody resultsRuleBody
 
 	if err := context.ShouldBindJSON(&requestBody); err != nil {
 		log.Error().Err(err).Msg("Failed to bind JSON request body")
@@ -39,16 +70,21 @@ func CreateResultsRule(dbOps db.DatabaseOperations, context *gin.Context) {
 		return
 	}
 
+	if !validateResultsRule(context, requestBody.Expression, false, requestBody.RunFilters) ||
+		!validateRuleObject(dbOps, context, requestBody.RelationId, requestBody.ObjectId) {
+		return
+	}
+
 	newRule := tables.ResultsRule{
 		ID:             db.GenerateUniqueID(),
 		Expression:     requestBody.Expression,
 		AppliesTo:      pq.StringArray(requestBody.AppliesTo),
+		RunFilters:     pq.StringArray(requestBody.RunFilters),
 		RelationshipID: requestBody.RelationId,
-		CreatedAt:      time.Now().UTC(),
-		UpdatedAt:      time.Now().UTC(),
+		ObjectID:       requestBody.ObjectId,
 	}
 
-	if err := dbOps.Create(&newRule); err != nil {
+	if err := queries.SaveResultsRule(dbOps, &newRule, tables.RuleCreated, context.GetHeader(changedByHeader)); err != nil {
 		context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create results rule"})
 		return
 	}
@@ -56,6 +92,184 @@ func CreateResultsRule(dbOps db.DatabaseOperations, context *gin.Context) {
 	context.JSON(http.StatusCreated, gin.H{"message": "Results rule created successfully"})
 }
 
+// UpdateResultsRule replaces the expression, targets, run filters, relationship and object of a
+// results rule and records the change as a new revision of the rule.
+//
+// Parameters:
+// - dbOps: The database operations interface used for database interactions.
+// - context: The Gin context that provides request and response handling.
+//
+// Path Parameters:
+// - id (string): The ID of the results rule to replace.
+//
+// Request Body:
+// The same JSON object as for CreateResultsRule. Fields left out are cleared. The expression is
+// read in the expression language, so a rule flagged as a legacy glob no longer is.
+//
+// Headers:
+// - X-Changed-By: Optional. Who changes the rule, recorded with the revision.
+//
+// Responses:
+// - 400 Bad Request: If the request body is invalid, a run filter is malformed, the expression is
+// invalid or objectId is not an object of the relationship.
+// - 404 Not Found: If the results rule does not exist or was deleted.
+// - 409 Conflict: If the rule was changed by another request at the same time.
+// - 200 OK: If the results rule is successfully replaced, returns the results rule object.
+func UpdateResultsRule(dbOps db.DatabaseOperations, context *gin.Context) {
+	var requestBody resultsRuleBody
+
+	if err := context.ShouldBindJSON(&requestBody); err != nil {
+		log.Error().Err(err).Msg("Failed to bind JSON request body")
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if !validateResultsRule(context, requestBody.Expression, false, requestBody.RunFilters) ||
+		!validateRuleObject(dbOps, context, requestBody.RelationId, requestBody.ObjectId) {
+		return
+	}
+
+	var rule tables.ResultsRule
+	if err := dbOps.First(&rule, "id = ?", context.Param("id")); err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "ResultsRule not found"})
+		return
+	}
+
+	rule.Expression = requestBody.Expression
+	rule.LegacyGlob = false
+	rule.AppliesTo = pq.StringArray(requestBody.AppliesTo)
+	rule.RunFilters = pq.StringArray(requestBody.RunFilters)
+	rule.RelationshipID = requestBody.RelationId
+	rule.ObjectID = requestBody.ObjectId
+	saveResultsRule(dbOps, context, &rule, tables.RuleUpdated)
+}
+
+// PatchResultsRule changes some of the fields of a results rule and records the change as a new
+// revision of the rule.
+//
+// Parameters:
+// - dbOps: The database operations interface used for database interactions.
+// - context: The Gin context that provides request and response handling.
+//
+// Path Parameters:
+// - id (string): The ID of the results rule to change.
+//
+// Request Body:
+// A JSON object with any of the fields of CreateResultsRule. Fields left out keep their value. A
+// rule flagged as a legacy glob keeps being read as a glob unless its expression is changed.
+//
+// Headers:
+// - X-Changed-By: Optional. Who changes the rule, recorded with the revision.
+//
+// Responses:
+// - 400 Bad Request: If the request body is invalid, a run filter is malformed, the expression is
+// invalid or objectId is not an object of the relationship.
+// - 404 Not Found: If the results rule does not exist or was deleted.
+// - 409 Conflict: If the rule was changed by another request at the same time.
+// - 200 OK: If the results rule is successfully changed, returns the results rule object.
+func PatchResultsRule(dbOps db.DatabaseOperations, context *gin.Context) {
+	var requestBody struct {
+		Expression *string   `json:"expression"`
+		AppliesTo  *[]string `json:"appliesTo"`
+		RunFilters *[]string `json:"runFilters"`
+		RelationId *string   `json:"relationId"`
+		ObjectId   *string   `json:"objectId"`
+	}
+
+	if err := context.ShouldBindJSON(&requestBody); err != nil {
+		log.Error().Err(err).Msg("Failed to bind JSON request body")
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	var rule tables.ResultsRule
+	if err := dbOps.First(&rule, "id = ?", context.Param("id")); err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "ResultsRule not found"})
+		return
+	}
+
+	if requestBody.Expression != nil {
+		rule.Expression = *requestBody.Expression
+		rule.LegacyGlob = false
+	}
+	if requestBody.AppliesTo != nil {
+		rule.AppliesTo = pq.StringArray(*requestBody.AppliesTo)
+	}
+	if requestBody.RunFilters != nil {
+		rule.RunFilters = pq.StringArray(*requestBody.RunFilters)
+	}
+	if requestBody.RelationId != nil {
+		rule.RelationshipID = *requestBody.RelationId
+	}
+	if requestBody.ObjectId != nil {
+		rule.ObjectID = *requestBody.ObjectId
+	}
+
+	if !validateResultsRule(context, rule.Expression, rule.LegacyGlob, rule.RunFilters) ||
+		!validateRuleObject(dbOps, context, rule.RelationshipID, rule.ObjectID) {
+		return
+	}
+	saveResultsRule(dbOps, context, &rule, tables.RuleUpdated)
+}
+
+// DeleteResultsRule deletes a results rule. The rule stops applying to results, but it is kept
+// together with its revisions, and its deletion is recorded as the last revision of the rule.
+//
+// Parameters:
+// - dbOps: The database operations interface used for database interactions.
+// - context: The Gin context that provides request and response handling.
+//
+// Path Parameters:
+// - id (string): The ID of the results rule to delete.
+//
+// Headers:
+// - X-Changed-By: Optional. Who deletes the rule, recorded with the revision.
+//
+// Responses:
+// - 404 Not Found: If the results rule does not exist or was already deleted.
+// - 409 Conflict: If the rule was changed by another request at the same time.
+// - 200 OK: If the results rule is successfully deleted.
+func DeleteResultsRule(dbOps db.DatabaseOperations, context *gin.Context) {
+	var rule tables.ResultsRule
+	if err := dbOps.First(&rule, "id = ?", context.Param("id")); err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "ResultsRule not found"})
+		return
+	}
+
+	if err := queries.SaveResultsRule(dbOps, &rule, tables.RuleDeleted, context.GetHeader(changedByHeader)); err != nil {
+		respondSaveRuleError(context, err)
+		return
+	}
+
+	context.JSON(http.StatusOK, gin.H{"message": "Results rule deleted successfully"})
+}
+
+// GetResultsRuleRevisions retrieves the revisions of a results rule, oldest first, including the
+// revisions of deleted rules.
+//
+// Parameters:
+// - dbOps: The database operations interface used for database interactions.
+// - context: The Gin context that provides request and response handling.
+//
+// Path Parameters:
+// - id (string): The ID of the results rule.
+//
+// Responses:
+// - 404 Not Found: If the results rule has no revisions.
+// - 200 OK: Returns the revisions of the results rule.
+func GetResultsRuleRevisions(dbOps db.DatabaseOperations, context *gin.Context) {
+	revisions, err := queries.FetchRuleRevisions(dbOps, context.Param("id"))
+	if err != nil {
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+	if len(revisions) == 0 {
+		context.JSON(http.StatusNotFound, gin.H{"error": "ResultsRule not found"})
+		return
+	}
+	context.JSON(http.StatusOK, revisions)
+}
+
 // GetResultsRule retrieves an existing results rule by its ID.
 // It fetches the results rule from the database and returns it in the response.
 //
@@ -93,3 +307,172 @@ func GetRulesByRelationID(dbOps db.DatabaseOperations, context *gin.Context) {
 	}
 	context.JSON(http.StatusOK, rules)
 }
+
+// respondExpressionError writes the 400 response for an invalid rule expression.
+// Syntax errors report the offset of the error so clients can point at it.
+//
+// Parameters:
+// - context: The Gin context that provides request and response handling.
+// - err: The error returned when compiling the expression.
+func respondExpressionError(context *gin.Context, err error) {
+	var syntaxError *rules.SyntaxError
+	if errors.As(err, &syntaxError) {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"error":  "Invalid expression: " + syntaxError.Message,
+			"offset": syntaxError.Offset,
+		})
+		return
+	}
+	context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expression: " + err.Error()})
+}
+
+// PreviewResultsRule evaluates a draft results rule against the latest results of a relationship
+// and returns the number of suites and cases it would select, with sample names, so a rule can be
+// checked before it is created. Nothing is saved.
+//
+// Parameters:
+// - dbOps: The database operations interface used for database interactions.
+// - context: The Gin context that provides request and response handling.
+//
+// Request Body:
+// The same JSON object as for CreateResultsRule, with an optional limit field giving the number of
+// latest results to evaluate the rule against (default 20, at most 200).
+//
+// Responses:
+// - 400 Bad Request: If the request body or the limit is invalid, a run filter is malformed, the
+// expression is invalid or objectId is not an object of the relationship.
+// - 404 Not Found: If the relationship does not exist.
+// - 200 OK: Returns the counts and samples of the selected suites and cases, in total and for each result.
+func PreviewResultsRule(dbOps db.DatabaseOperations, context *gin.Context) {
+	var requestBody struct {
+		resultsRuleBody
+		Limit int `json:"limit"`
+	}
+
+	if err := context.ShouldBindJSON(&requestBody); err != nil {
+		log.Error().Err(err).Msg("Failed to bind JSON request body")
+		context.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	if requestBody.Limit == 0 {
+		requestBody.Limit = defaultPreviewResults
+	}
+	if requestBody.Limit < 0 || requestBody.Limit > maxPreviewResults {
+		context.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxPreviewResults)})
+		return
+	}
+
+	if !validateResultsRule(context, requestBody.Expression, false, requestBody.RunFilters) {
+		return
+	}
+
+	draftRule := tables.ResultsRule{
+		Expression:     requestBody.Expression,
+		AppliesTo:      pq.StringArray(requestBody.AppliesTo),
+		RunFilters:     pq.StringArray(requestBody.RunFilters),
+		RelationshipID: requestBody.RelationId,
+		ObjectID:       requestBody.ObjectId,
+	}
+	if err := dbOps.First(&draftRule.Relationship, "id = ?", requestBody.RelationId); err != nil {
+		context.JSON(http.StatusNotFound, gin.H{"error": "Relationship not found"})
+		return
+	}
+	if draftRule.ObjectID != "" && !utils.Contains(draftRule.Relationship.ObjectIDs, draftRule.ObjectID) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "objectId is not an object of the relationship"})
+		return
+	}
+
+	preview, err := queries.PreviewResultsRule(dbOps.Connection(), &draftRule, requestBody.Limit)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to preview results rule")
+		context.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	context.JSON(http.StatusOK, preview)
+}
+
+// validateResultsRule checks the run filters and the expression of a results rule and writes the
+// 400 response if one of them is invalid.
+//
+// Parameters:
+// - context: The Gin context that provides request and response handling.
+// - expression: The expression of the rule.
+// - legacyGlob: Whether the expression is a legacy glob, which is not checked as an expression.
+// - runFilters: The run filters of the rule.
+//
+// Returns:
+// - bool: Whether the rule is valid.
+func validateResultsRule(context *gin.Context, expression string, legacyGlob bool, runFilters []string) bool {
+	if err := queries.ValidateRunFilters(runFilters); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	if legacyGlob {
+		return true
+	}
+	if _, err := rules.Compile(expression); err != nil {
+		respondExpressionError(context, err)
+		return false
+	}
+	return true
+}
+
+// validateRuleObject checks that the object a results rule targets, if any, is one of the objects
+// of its relationship and writes the 400 response if it is not.
+//
+// Parameters:
+// - dbOps: The database operations interface used for database interactions.
+// - context: The Gin context that provides request and response handling.
+// - relationID: The ID of the relationship of the rule.
+// - objectID: The ID of the object the rule targets, or an empty string if it targets every object.
+//
+// Returns:
+// - bool: Whether the object is valid.
+func validateRuleObject(dbOps db.DatabaseOperations, context *gin.Context, relationID string, objectID string) bool {
+	if objectID == "" {
+		return true
+	}
+
+	var relationship tables.Relationship
+	if err := dbOps.First(&relationship, "id = ?", relationID); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "objectId requires an existing relationship"})
+		return false
+	}
+	if !utils.Contains(relationship.ObjectIDs, objectID) {
+		context.JSON(http.StatusBadRequest, gin.H{"error": "objectId is not an object of the relationship"})
+		return false
+	}
+	return true
+}
+
+// saveResultsRule records a change to a results rule made by the request and writes the response.
+//
+// Parameters:
+// - dbOps: The database operations interface used for database interactions.
+// - context: The Gin context that provides request and response handling.
+// - rule: The rule, holding its new state.
+// - action: The action recorded with the revision, such as tables.RuleUpdated.
+func saveResultsRule(dbOps db.DatabaseOperations, context *gin.Context, rule *tables.ResultsRule, action string) {
+	if err := queries.SaveResultsRule(dbOps, rule, action, context.GetHeader(changedByHeader)); err != nil {
+		respondSaveRuleError(context, err)
+		return
+	}
+	context.JSON(http.StatusOK, rule)
+}
+
+// respondSaveRuleError writes the response for a change to a results rule that could not be saved.
+//
+// Parameters:
+// - context: The Gin context that provides request and response handling.
+// - err: The error returned by queries.SaveResultsRule.
+func respondSaveRuleError(context *gin.Context, err error) {
+	if errors.Is(err, queries.ErrRuleConflict) {
+		context.JSON(http.StatusConflict, gin.H{"error": "Results rule was changed by another request, retry the change"})
+		return
+	}
+	log.Error().Err(err).Msg("Failed to save results rule")
+	context.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save results rule"})
+}
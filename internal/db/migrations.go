@@ -0,0 +1,65 @@
+package db
+
+import (
+	"embed"
+	"hypha/api/internal/db/tables"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/go-orm/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// runMigrations applies every embedded data migration that has not been applied yet.
+// Migrations run in file name order, each inside its own transaction, and are recorded in the
+// schema_migrations table so they are applied exactly once.
+//
+// Parameters:
+//   - db: A pointer to the gorm.DB connection.
+//
+// Returns:
+//   - error: An error object if a migration fails, otherwise nil.
+func runMigrations(db *gorm.DB) error {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	wrapper := &DBConnWrapper{DB: db}
+	for _, name := range names {
+		id := path.Base(name)
+
+		var applied tables.SchemaMigration
+		if err := db.Where("id = ?", id).First(&applied).Error; err == nil {
+			continue
+		} else if err != gorm.ErrRecordNotFound {
+			log.Error().Err(err).Msgf("Failed to look up migration %s", id)
+			return err
+		}
+
+		content, err := migrations.ReadFile(name)
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to read embedded migration %s", id)
+			return err
+		}
+
+		log.Info().Msgf("Applying migration %s", id)
+		err = wrapper.Transaction(func(tx DatabaseOperations) error {
+			if err := tx.Connection().Exec(string(content)).Error; err != nil {
+				return err
+			}
+			return tx.Create(&tables.SchemaMigration{ID: id, AppliedAt: time.Now().UTC()})
+		})
+		if err != nil {
+			log.Error().Err(err).Msgf("Failed to apply migration %s", id)
+			return err
+		}
+		log.Info().Msgf("Successfully applied migration %s", id)
+	}
+	return nil
+}
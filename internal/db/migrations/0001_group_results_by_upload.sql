@@ -0,0 +1,89 @@
+-- Uploads used to create one result per <testsuite>. All results of an upload were inserted by
+-- the same request within moments of each other with the same run metadata, so results of the
+-- same product and run reported less than five seconds after the first result of their group are
+-- folded into that result. Groups are measured from their first result rather than chained from
+-- result to result, so a steady stream of uploads is never folded into one result.
+CREATE TEMP TABLE result_upload_order ON COMMIT DROP AS
+SELECT
+    id::text AS id,
+    date_reported,
+    DENSE_RANK() OVER (
+        ORDER BY product_id, commit, branch, pipeline, build_id, build_url, environment, executed_at
+    ) AS run_number,
+    ROW_NUMBER() OVER (
+        PARTITION BY product_id, commit, branch, pipeline, build_id, build_url, environment, executed_at
+        ORDER BY date_reported, id
+    ) AS position
+FROM
+    results;
+
+CREATE INDEX ON result_upload_order (run_number, position);
+
+CREATE TEMP TABLE result_upload_groups ON COMMIT DROP AS
+WITH RECURSIVE grouped AS (
+    SELECT
+        run_number,
+        position,
+        id,
+        id AS leader_id,
+        date_reported AS leader_date_reported
+    FROM
+        result_upload_order
+    WHERE
+        position = 1
+    UNION ALL
+    SELECT
+        o.run_number,
+        o.position,
+        o.id,
+        CASE WHEN o.date_reported - g.leader_date_reported < INTERVAL '5 seconds' THEN g.leader_id ELSE o.id END,
+        CASE WHEN o.date_reported - g.leader_date_reported < INTERVAL '5 seconds' THEN g.leader_date_reported ELSE o.date_reported END
+    FROM
+        grouped g
+        JOIN result_upload_order o ON o.run_number = g.run_number AND o.position = g.position + 1
+)
+SELECT
+    id,
+    leader_id
+FROM
+    grouped;
+
+UPDATE test_suites ts
+SET result_id = g.leader_id
+FROM result_upload_groups g
+WHERE ts.result_id::text = g.id AND g.id <> g.leader_id;
+
+DELETE FROM results r
+USING result_upload_groups g
+WHERE r.id::text = g.id AND g.id <> g.leader_id;
+
+-- Results created before totals were stored on the result take them from their suites.
+UPDATE results r
+SET
+    tests = s.tests,
+    failures = s.failures,
+    errors = s.errors,
+    skipped = s.skipped,
+    assertions = s.assertions,
+    time = s.time
+FROM (
+    SELECT
+        result_id,
+        SUM(tests) AS tests,
+        SUM(failures) AS failures,
+        SUM(errors) AS errors,
+        SUM(skipped) AS skipped,
+        SUM(assertions) AS assertions,
+        SUM(time) AS time
+    FROM
+        test_suites
+    GROUP BY
+        result_id
+) s
+WHERE r.id::text = s.result_id::text;
+
+UPDATE results r
+SET name = ts.name
+FROM test_suites ts
+WHERE r.id::text = ts.result_id::text
+  AND (SELECT COUNT(*) FROM test_suites WHERE result_id::text = r.id::text) = 1;
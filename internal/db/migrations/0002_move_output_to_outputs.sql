@@ -0,0 +1,28 @@
+-- system-out and system-err logs used to be stored inline in test_suites, test_cases and
+-- test_case_reruns. Every distinct log is moved into the outputs table, keyed by the SHA-256 of
+-- its content like logs stored by new uploads, and the rows reference it instead. The inline
+-- columns are dropped afterwards; databases created after the change never had them.
+DO $migration$
+DECLARE
+    target record;
+BEGIN
+    FOR target IN
+        SELECT table_name, column_name
+        FROM information_schema.columns
+        WHERE table_schema = current_schema()
+          AND table_name IN ('test_suites', 'test_cases', 'test_case_reruns')
+          AND column_name IN ('system_out', 'system_err')
+    LOOP
+        EXECUTE format(
+            'INSERT INTO outputs (id, size, stored_size, truncated, compression, content, created_at)
+             SELECT encode(sha256(content), ''hex''), octet_length(content), octet_length(content), false, '''', content, now()
+             FROM (SELECT DISTINCT convert_to(%I, ''UTF8'') AS content FROM %I WHERE %I <> '''') logs
+             ON CONFLICT (id) DO NOTHING',
+            target.column_name, target.table_name, target.column_name);
+        EXECUTE format(
+            'UPDATE %I SET %I = encode(sha256(convert_to(%I, ''UTF8'')), ''hex'') WHERE %I <> ''''',
+            target.table_name, target.column_name || '_id', target.column_name, target.column_name);
+        EXECUTE format('ALTER TABLE %I DROP COLUMN %I', target.table_name, target.column_name);
+    END LOOP;
+END
+$migration$;
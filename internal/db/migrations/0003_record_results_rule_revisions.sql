@@ -0,0 +1,19 @@
+-- Results rules could only be created before their changes were recorded as revisions. Every
+-- existing rule gets a first revision holding its current state, dated when the rule was created.
+UPDATE results_rules SET revision = 1 WHERE revision IS NULL OR revision = 0;
+
+INSERT INTO results_rule_revisions (id, rule_id, revision, action, expression, applies_to, run_filters, relationship_id, changed_by, created_at)
+SELECT md5(rr.id::text || ':1')::uuid, rr.id, 1, 'created', rr.expression, rr.applies_to, rr.run_filters, rr.relationship_id, '', rr.created_at
+FROM results_rules rr
+WHERE NOT EXISTS (SELECT 1 FROM results_rule_revisions rv WHERE rv.rule_id = rr.id);
+
+-- Revisions are the history of the rules and are never changed once recorded.
+CREATE OR REPLACE FUNCTION reject_results_rule_revision_change() RETURNS trigger AS $migration$
+BEGIN
+    RAISE EXCEPTION 'results rule revisions are immutable';
+END;
+$migration$ LANGUAGE plpgsql;
+
+CREATE TRIGGER results_rule_revisions_immutable
+    BEFORE UPDATE OR DELETE ON results_rule_revisions
+    FOR EACH ROW EXECUTE PROCEDURE reject_results_rule_revision_change();
@@ -0,0 +1,10 @@
+-- Before the expression language, the expression of a results rule was a single glob matched
+-- against names, optionally negated by a leading "!". Such globs can also be valid expressions with
+-- another meaning, as "Payments and Refunds" is, so every rule and revision stored before the
+-- expression language is flagged and keeps being read as a glob until its expression is changed.
+UPDATE results_rules SET legacy_glob = true;
+
+-- Revisions are immutable, except for recording how their expression was written.
+ALTER TABLE results_rule_revisions DISABLE TRIGGER results_rule_revisions_immutable;
+UPDATE results_rule_revisions SET legacy_glob = true;
+ALTER TABLE results_rule_revisions ENABLE TRIGGER results_rule_revisions_immutable;
@@ -9,11 +9,15 @@ import (
 )
 
 // DatabaseOperations defines the interface for database operations.
-// It includes methods for obtaining a database connection, creating a record, and fetching the first record that matches the criteria.
+// It includes methods for obtaining a database connection, creating a record, fetching the first record that matches the criteria,
+// inserting many records at once, optionally skipping records that already exist, and running a set of operations inside a single transaction.
 type DatabaseOperations interface {
 	Connection() *gorm.DB
 	Create(value interface{}) error
 	First(out interface{}, where ...interface{}) error
+	CreateInBatches(values interface{}, batchSize int) error
+	CreateMissingInBatches(values interface{}, batchSize int) error
+	Transaction(fn func(tx DatabaseOperations) error) error
 }
 
 // CreateResource handles the creation of a new resource in the database.
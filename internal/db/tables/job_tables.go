@@ -0,0 +1,70 @@
+package tables
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// States of an ingestion job.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// IngestionJob represents an upload accepted for asynchronous ingestion.
+// The uploaded files are kept in the blob store until the job has been processed,
+// so queued jobs survive a restart of the API.
+type IngestionJob struct {
+	ID             string               `gorm:"type:uuid;primaryKey" json:"id"`
+	ProductID      string               `json:"productID"`
+	State          string               `gorm:"index:idx_ingestion_jobs_state" json:"state"` // queued, running, succeeded or failed
+	ResultID       *string              `json:"resultID"`                                    // Result stored by the job, once it succeeded
+	Duplicate      bool                 `json:"duplicate"`                                   // Whether the upload repeated an earlier one
+	Error          string               `json:"error"`
+	ContentHash    string               `json:"contentHash"`
+	IdempotencyKey string               `json:"idempotencyKey"`
+	Format         string               `json:"format"`     // Format of the uploaded reports, or empty to detect it
+	ExecutedAt     *time.Time           `json:"executedAt"` // Execution time sent with the upload, if any
+	Tests          int                  `json:"tests"`
+	Failures       int                  `json:"failures"`
+	Errors         int                  `json:"errors"`
+	Skipped        int                  `json:"skipped"`
+	CreatedAt      time.Time            `json:"createdAt"`
+	StartedAt      *time.Time           `json:"startedAt"`
+	ClaimID        *string              `json:"-"` // Identifies the run of the job that owns it while it is running
+	FinishedAt     *time.Time           `json:"finishedAt"`
+	Files          []IngestionJobFile   `gorm:"foreignKey:JobID"`
+	Reports        []IngestionJobReport `gorm:"foreignKey:JobID"`
+	RunMetadata
+}
+
+// IngestionJobFile represents a file uploaded with an ingestion job.
+// The content is stored in the blob store under StorageKey and deleted once the job has been processed.
+type IngestionJobFile struct {
+	ID         string `gorm:"type:uuid;primaryKey" json:"id"`
+	JobID      string `gorm:"index:idx_ingestion_job_files_job_id" json:"jobID"`
+	Position   int    `json:"position"` // Order of the file within the upload
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	StorageKey string `json:"-"`
+}
+
+// IngestionJobReport records the outcome of a single report read by an ingestion job,
+// with the number of test suites and test cases stored for it.
+type IngestionJobReport struct {
+	ID       string         `gorm:"type:uuid;primaryKey" json:"id"`
+	JobID    string         `gorm:"index:idx_ingestion_job_reports_job_id" json:"jobID"`
+	Position int            `json:"position"` // Order of the report within the upload
+	Name     string         `json:"name"`
+	Format   string         `json:"format"`
+	Suites   int            `json:"suites"`
+	Tests    int            `json:"tests"`
+	Failures int            `json:"failures"`
+	Errors   int            `json:"errors"`
+	Skipped  int            `json:"skipped"`
+	Error    string         `json:"error"`
+	Warnings pq.StringArray `gorm:"type:text[]" json:"warnings"` // Parts of the report that were ignored
+}
@@ -0,0 +1,11 @@
+package tables
+
+import (
+	"time"
+)
+
+// SchemaMigration records a data migration that has already been applied to the database.
+type SchemaMigration struct {
+	ID        string    `gorm:"primaryKey" json:"id"` // File name of the migration script
+	AppliedAt time.Time `json:"appliedAt"`
+}
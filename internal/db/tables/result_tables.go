@@ -1,89 +1,282 @@
 package tables
 
 import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
 	"time"
 )
 
-// Result represents a test result for a product.
+// Result represents a single uploaded test report for a product.
+// The totals and timing are taken from the report's <testsuites> element.
 type Result struct {
-	ID           string      `gorm:"type:uuid;primaryKey" json:"id"`
-	ProductID    string      `json:"productID"`
-	TestSuites   []TestSuite `gorm:"foreignKey:ResultID"`
-	DateReported time.Time   `json:"dateReported"`
+	ID             string      `gorm:"type:uuid;primaryKey" json:"id"`
+	ProductID      string      `gorm:"index:idx_results_product_id" json:"productID"`
+	Name           string      `json:"name"`
+	Tests          int         `json:"tests"`
+	Failures       int         `json:"failures"`
+	Errors         int         `json:"errors"`
+	Skipped        int         `json:"skipped"`
+	Assertions     int         `json:"assertions"`
+	Time           float64     `json:"time"`
+	ExecutedAt     *time.Time  `json:"executedAt"`                                              // Time the tests ran, as sent with the upload or declared by the report, if known
+	ContentHash    string      `gorm:"index:idx_results_content_hash" json:"contentHash"`       // SHA-256 of the uploaded files
+	IdempotencyKey string      `gorm:"index:idx_results_idempotency_key" json:"idempotencyKey"` // Idempotency-Key header of the upload, if any
+	TestSuites     []TestSuite `gorm:"foreignKey:ResultID"`
+	DateReported   time.Time   `json:"dateReported"` // Time the upload was received
+	RunMetadata
+}
+
+// ExecutionTime returns the time the tests of the result ran, falling back to the time the result
+// was reported when neither the upload nor the report declared it. Histories are ordered by it.
+func (result Result) ExecutionTime() time.Time {
+	if result.ExecutedAt != nil {
+		return *result.ExecutedAt
+	}
+	return result.DateReported
+}
+
+// ResultsHistoryOrder orders queries over results by execution time, most recent first, in the same
+// way as Result.ExecutionTime.
+const ResultsHistoryOrder = "COALESCE(executed_at, date_reported) DESC"
+
+// RunMetadata describes the CI run that produced a result.
+type RunMetadata struct {
+	Commit      string `json:"commit"`
+	Branch      string `json:"branch"`
+	Pipeline    string `json:"pipeline"`
+	BuildID     string `json:"buildID"`
+	BuildURL    string `json:"buildURL"`
+	Environment string `json:"environment"`
+}
+
+// RunMetadataColumns maps the name of each run metadata field, as used in JSON, form fields,
+// query parameters and results rules, to its database column.
+var RunMetadataColumns = map[string]string{
+	"commit":      "commit",
+	"branch":      "branch",
+	"pipeline":    "pipeline",
+	"buildID":     "build_id",
+	"buildURL":    "build_url",
+	"environment": "environment",
+}
+
+// Value returns the value of the run metadata field with the given name.
+// The boolean is false if the name is not a run metadata field.
+func (metadata RunMetadata) Value(name string) (string, bool) {
+	if field := metadata.field(name); field != nil {
+		return *field, true
+	}
+	return "", false
+}
+
+// Set sets the run metadata field with the given name.
+// It returns false if the name is not a run metadata field.
+func (metadata *RunMetadata) Set(name string, value string) bool {
+	if field := metadata.field(name); field != nil {
+		*field = value
+		return true
+	}
+	return false
+}
+
+func (metadata *RunMetadata) field(name string) *string {
+	switch name {
+	case "commit":
+		return &metadata.Commit
+	case "branch":
+		return &metadata.Branch
+	case "pipeline":
+		return &metadata.Pipeline
+	case "buildID":
+		return &metadata.BuildID
+	case "buildURL":
+		return &metadata.BuildURL
+	case "environment":
+		return &metadata.Environment
+	}
+	return nil
 }
 
 // TestSuite represents a suite of tests within a test result.
 type TestSuite struct {
-	ID         string     `gorm:"type:uuid;primaryKey" json:"id"`
-	ResultID   string     `json:"resultID"`
-	Name       string     `json:"name"`
-	Tests      int        `json:"tests"`
-	Failures   int        `json:"failures"`
-	Errors     int        `json:"errors"`
-	Skipped    int        `json:"skipped"`
-	Assertions int        `json:"assertions"`
-	Time       float64    `json:"time"`
-	File       string     `json:"file"`
-	TestCases  []TestCase `gorm:"foreignKey:TestSuiteID"`
-	Properties []Property `gorm:"foreignKey:TestSuiteID"`
-	SystemOut  string     `json:"systemOut"`
-	SystemErr  string     `json:"systemErr"`
+	ID          string     `gorm:"type:uuid;primaryKey" json:"id"`
+	ResultID    string     `gorm:"index:idx_test_suites_result_id" json:"resultID"`
+	ParentID    *string    `json:"parentID"` // ID of the suite this suite is nested in, if any
+	Name        string     `json:"name"`
+	Tests       int        `json:"tests"`
+	Failures    int        `json:"failures"`
+	Errors      int        `json:"errors"`
+	Skipped     int        `json:"skipped"`
+	Assertions  int        `json:"assertions"`
+	Time        float64    `json:"time"`
+	File        string     `json:"file"`
+	ExecutedAt  *time.Time `json:"executedAt"` // Timestamp declared by the suite, if any
+	Hostname    string     `json:"hostname"`   // Host the suite ran on, if declared
+	TestCases   []TestCase `gorm:"foreignKey:TestSuiteID"`
+	Properties  []Property `gorm:"foreignKey:TestSuiteID"`
+	SystemOutID *string    `json:"systemOutID"`                  // ID of the Output holding the suite's system-out, if any
+	SystemErrID *string    `json:"systemErrID"`                  // ID of the Output holding the suite's system-err, if any
+	SystemOut   string     `gorm:"-" json:"systemOut,omitempty"` // Only filled when the output is requested
+	SystemErr   string     `gorm:"-" json:"systemErr,omitempty"` // Only filled when the output is requested
 }
 
 // TestCase represents an individual test case within a test suite.
 type TestCase struct {
+	ID          string            `gorm:"type:uuid;primaryKey" json:"id"`
+	TestSuiteID string            `gorm:"index:idx_test_cases_test_suite_id" json:"testSuiteID"`
+	ClassName   string            `json:"className"`
+	Name        string            `json:"name"`
+	Time        float64           `json:"time"`
+	Status      string            `json:"status"`
+	Message     *string           `json:"message"`
+	Type        *string           `json:"type"`
+	Assertions  int               `json:"assertions"`
+	File        string            `json:"file"`
+	Line        int               `json:"line"`
+	Properties  []Property        `gorm:"foreignKey:TestCaseID"`
+	Failures    []TestCaseFailure `gorm:"foreignKey:TestCaseID"`
+	Reruns      []TestCaseRerun   `gorm:"foreignKey:TestCaseID"`
+	Steps       []TestCaseStep    `gorm:"foreignKey:TestCaseID"`
+	Attachments []Attachment      `gorm:"foreignKey:TestCaseID"`
+	SystemOutID *string           `json:"systemOutID"`                  // ID of the Output holding the case's system-out, if any
+	SystemErrID *string           `json:"systemErrID"`                  // ID of the Output holding the case's system-err, if any
+	SystemOut   string            `gorm:"-" json:"systemOut,omitempty"` // Only filled when the output is requested
+	SystemErr   string            `gorm:"-" json:"systemErr,omitempty"` // Only filled when the output is requested
+}
+
+// TestCaseFailure represents a <failure> or <error> element of a test case, including its body text.
+type TestCaseFailure struct {
+	ID         string `gorm:"type:uuid;primaryKey" json:"id"`
+	TestCaseID string `gorm:"index:idx_test_case_failures_test_case_id" json:"testCaseID"`
+	Kind       string `json:"kind"`     // failure or error
+	Position   int    `json:"position"` // Order of the element within the test case
+	Message    string `json:"message"`
+	Type       string `json:"type"`
+	Body       string `json:"body"` // Element text, usually the stack trace
+}
+
+// TestCaseRerun represents a failed attempt of a test case that was run again.
+// Flaky attempts belong to a test case that eventually passed, rerun attempts to one that failed every time.
+type TestCaseRerun struct {
+	ID          string  `gorm:"type:uuid;primaryKey" json:"id"`
+	TestCaseID  string  `gorm:"index:idx_test_case_reruns_test_case_id" json:"testCaseID"`
+	Kind        string  `json:"kind"`    // flakyFailure, flakyError, rerunFailure or rerunError
+	Attempt     int     `json:"attempt"` // 1-based order of the attempt within the test case
+	Message     string  `json:"message"`
+	Type        string  `json:"type"`
+	StackTrace  string  `json:"stackTrace"`
+	SystemOutID *string `json:"systemOutID"`                  // ID of the Output holding the attempt's system-out, if any
+	SystemErrID *string `json:"systemErrID"`                  // ID of the Output holding the attempt's system-err, if any
+	SystemOut   string  `gorm:"-" json:"systemOut,omitempty"` // Only filled when the output is requested
+	SystemErr   string  `gorm:"-" json:"systemErr,omitempty"` // Only filled when the output is requested
+}
+
+// TestCaseStep represents a step of a behaviour-driven test case, such as a Cucumber scenario step or hook.
+type TestCaseStep struct {
+	ID         string  `gorm:"type:uuid;primaryKey" json:"id"`
+	TestCaseID string  `gorm:"index:idx_test_case_steps_test_case_id" json:"testCaseID"`
+	Position   int     `json:"position"` // Order of the step within the test case
+	Keyword    string  `json:"keyword"`  // Given, When, Then, And, But, Before or After
+	Text       string  `json:"text"`
+	Line       int     `json:"line"`
+	Status     string  `json:"status"` // passed, failed, skipped, pending, undefined or ambiguous
+	Duration   float64 `json:"duration"`
+	Error      string  `json:"error"`
+}
+
+// Attachment represents a file attached to a test case, such as a screenshot, a HAR file or a log.
+// Attachments referenced by a report through [[ATTACHMENT|path]] are recorded when the report is
+// ingested and have no content until a file for the referenced path is uploaded. The content is
+// kept in the blob store under StorageKey, which is derived from its hash so identical files are
+// stored once.
+type Attachment struct {
 	ID          string     `gorm:"type:uuid;primaryKey" json:"id"`
-	TestSuiteID string     `json:"testSuiteID"`
-	ClassName   string     `json:"className"`
+	TestCaseID  string     `gorm:"index:idx_attachments_test_case_id" json:"testCaseID"`
 	Name        string     `json:"name"`
-	Time        float64    `json:"time"`
-	Status      string     `json:"status"`
-	Message     *string    `json:"message"`
-	Type        *string    `json:"type"`
-	Assertions  int        `json:"assertions"`
-	File        string     `json:"file"`
-	Line        int        `json:"line"`
-	Properties  []Property `gorm:"foreignKey:TestCaseID"`
-	SystemOut   string     `json:"systemOut"`
-	SystemErr   string     `json:"systemErr"`
+	Path        string     `json:"path"` // Path referenced by the report, if any
+	ContentType string     `json:"contentType"`
+	Size        int64      `json:"size"`
+	ContentHash string     `json:"contentHash"` // SHA-256 of the content, empty until the content is uploaded
+	StorageKey  string     `json:"-"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UploadedAt  *time.Time `json:"uploadedAt"`
+}
+
+// Output holds a system-out or system-err log of a test suite, test case or rerun attempt.
+// Logs are kept apart from the test results so queries over results do not read them, and are
+// identified by the hash of the complete log so identical logs are stored once. Logs longer than
+// the configured limit have their middle replaced by a truncation marker before they are stored.
+type Output struct {
+	ID          string    `gorm:"primaryKey" json:"id"` // Hex encoded SHA-256 of the complete log
+	Size        int64     `json:"size"`                 // Size of the complete log in bytes
+	StoredSize  int64     `json:"storedSize"`           // Size of the stored log in bytes, after truncation and before compression
+	Truncated   bool      `json:"truncated"`
+	Compression string    `json:"compression"` // Encoding of Content: empty or gzip
+	Content     []byte    `json:"-"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// OutputGzip is the Compression of outputs whose content is gzip compressed.
+const OutputGzip = "gzip"
+
+// Text returns the stored log, decompressing it if needed.
+func (output Output) Text() (string, error) {
+	switch output.Compression {
+	case "":
+		return string(output.Content), nil
+	case OutputGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(output.Content))
+		if err != nil {
+			return "", err
+		}
+		defer reader.Close()
+		content, err := io.ReadAll(reader)
+		return string(content), err
+	default:
+		return "", fmt.Errorf("unknown output compression %q", output.Compression)
+	}
 }
 
 // Property represents a property associated with a test suite or test case.
 type Property struct {
 	ID          string  `gorm:"type:uuid;primaryKey" json:"id"`
-	TestSuiteID *string `json:"testSuiteID"`
-	TestCaseID  *string `json:"testCaseID"`
+	TestSuiteID *string `gorm:"index:idx_properties_test_suite_id" json:"testSuiteID"`
+	TestCaseID  *string `gorm:"index:idx_properties_test_case_id" json:"testCaseID"`
 	Name        string  `json:"name"`
 	Value       string  `json:"value"`
 }
 
 // TestResultsView represents the view for test results.
 type TestResultsView struct {
-	ResultID            string  `json:"result_id"`
-	ProductID           string  `json:"product_id"`
-	TestSuiteID         string  `json:"test_suite_id"`
-	TestSuiteName       string  `json:"test_suite_name"`
-	TestSuiteTests      int     `json:"test_suite_tests"`
-	TestSuiteFailures   int     `json:"test_suite_failures"`
-	TestSuiteErrors     int     `json:"test_suite_errors"`
-	TestSuiteSkipped    int     `json:"test_suite_skipped"`
-	TestSuiteAssertions int     `json:"test_suite_assertions"`
-	TestSuiteTime       float64 `json:"test_suite_time"`
-	TestSuiteFile       string  `json:"test_suite_file"`
-	TestSuiteSystemOut  string  `json:"test_suite_system_out"`
-	TestSuiteSystemErr  string  `json:"test_suite_system_err"`
-	TestCaseID          string  `json:"test_case_id"`
-	TestCaseName        string  `json:"test_case_name"`
-	TestCaseClassName   string  `json:"test_case_class_name"`
-	TestCaseTime        float64 `json:"test_case_time"`
-	TestCaseStatus      string  `json:"test_case_status"`
-	TestCaseMessage     *string `json:"test_case_message"`
-	TestCaseType        *string `json:"test_case_type"`
-	TestCaseAssertions  int     `json:"test_case_assertions"`
-	TestCaseFile        string  `json:"test_case_file"`
-	TestCaseLine        int     `json:"test_case_line"`
-	TestCaseSystemOut   string  `json:"test_case_system_out"`
-	TestCaseSystemErr   string  `json:"test_case_system_err"`
+	ResultID             string  `json:"result_id"`
+	ProductID            string  `json:"product_id"`
+	TestSuiteID          string  `json:"test_suite_id"`
+	TestSuiteParentID    *string `json:"test_suite_parent_id"`
+	TestSuiteName        string  `json:"test_suite_name"`
+	TestSuiteTests       int     `json:"test_suite_tests"`
+	TestSuiteFailures    int     `json:"test_suite_failures"`
+	TestSuiteErrors      int     `json:"test_suite_errors"`
+	TestSuiteSkipped     int     `json:"test_suite_skipped"`
+	TestSuiteAssertions  int     `json:"test_suite_assertions"`
+	TestSuiteTime        float64 `json:"test_suite_time"`
+	TestSuiteFile        string  `json:"test_suite_file"`
+	TestSuiteSystemOutID *string `json:"test_suite_system_out_id"`
+	TestSuiteSystemErrID *string `json:"test_suite_system_err_id"`
+	TestCaseID           string  `json:"test_case_id"`
+	TestCaseName         string  `json:"test_case_name"`
+	TestCaseClassName    string  `json:"test_case_class_name"`
+	TestCaseTime         float64 `json:"test_case_time"`
+	TestCaseStatus       string  `json:"test_case_status"`
+	TestCaseMessage      *string `json:"test_case_message"`
+	TestCaseType         *string `json:"test_case_type"`
+	TestCaseAssertions   int     `json:"test_case_assertions"`
+	TestCaseFile         string  `json:"test_case_file"`
+	TestCaseLine         int     `json:"test_case_line"`
+	TestCaseSystemOutID  *string `json:"test_case_system_out_id"`
+	TestCaseSystemErrID  *string `json:"test_case_system_err_id"`
+	RunMetadata
 }
 
 // TableName sets the insert table name for this struct type
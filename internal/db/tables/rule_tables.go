@@ -7,12 +7,68 @@ import (
 )
 
 // ResultsRule represents a rule applied to test results.
+// Every change to a rule is recorded as a ResultsRuleRevision. Deleted rules are kept, with
+// DeletedAt set, so their revisions can still be evaluated.
 type ResultsRule struct {
 	ID             string         `gorm:"type:uuid;primaryKey" json:"id"`
 	Expression     string         `json:"expression"`
-	AppliesTo      pq.StringArray `gorm:"type:text[]" json:"appliesTo"` // List of types: suite, case
+	LegacyGlob     bool           `json:"legacyGlob"`                    // Whether the expression is a single glob stored before the expression language
+	AppliesTo      pq.StringArray `gorm:"type:text[]" json:"appliesTo"`  // List of types: suite, case
+	RunFilters     pq.StringArray `gorm:"type:text[]" json:"runFilters"` // List of run metadata filters, e.g. "branch=main"
 	RelationshipID string         `gorm:"type:uuid" json:"relationshipId"`
 	Relationship   Relationship   `gorm:"foreignKey:RelationshipID"`
+	ObjectID       string         `json:"objectId"` // Object of the relationship the rule applies to; empty for every object
+	Revision       int            `json:"revision"` // Number of the latest revision of the rule
 	CreatedAt      time.Time      `json:"createdAt"`
 	UpdatedAt      time.Time      `json:"updatedAt"`
+	DeletedAt      *time.Time     `json:"deletedAt,omitempty"`
+}
+
+// TargetObjectIDs returns the IDs of the objects of the relationship whose results the rule applies
+// to: the object the rule targets, or every object of the relationship. The relationship must be loaded.
+func (rule ResultsRule) TargetObjectIDs() []string {
+	if rule.ObjectID != "" {
+		return []string{rule.ObjectID}
+	}
+	return rule.Relationship.ObjectIDs
+}
+
+// Actions recorded by revisions of results rules.
+const (
+	RuleCreated = "created"
+	RuleUpdated = "updated"
+	RuleDeleted = "deleted"
+)
+
+// ResultsRuleRevision records the state of a results rule right after it was created, updated or
+// deleted. Revisions are never modified, so the rules of a relationship can be evaluated as they
+// stood at any point in time.
+type ResultsRuleRevision struct {
+	ID             string         `gorm:"type:uuid;primaryKey" json:"id"`
+	RuleID         string         `gorm:"type:uuid;unique_index:idx_results_rule_revisions_rule_revision" json:"ruleId"`
+	Revision       int            `gorm:"unique_index:idx_results_rule_revisions_rule_revision" json:"revision"` // 1-based number of the revision within the rule
+	Action         string         `json:"action"`                                                                // created, updated or deleted
+	Expression     string         `json:"expression"`
+	LegacyGlob     bool           `json:"legacyGlob"`
+	AppliesTo      pq.StringArray `gorm:"type:text[]" json:"appliesTo"`
+	RunFilters     pq.StringArray `gorm:"type:text[]" json:"runFilters"`
+	RelationshipID string         `gorm:"type:uuid" json:"relationshipId"`
+	ObjectID       string         `json:"objectId"`
+	ChangedBy      string         `json:"changedBy"` // Who made the change, as given by the client, if known
+	CreatedAt      time.Time      `json:"createdAt"`
+}
+
+// Rule returns the results rule as it stood at the revision. The relationship is not loaded.
+func (revision ResultsRuleRevision) Rule() ResultsRule {
+	return ResultsRule{
+		ID:             revision.RuleID,
+		Expression:     revision.Expression,
+		LegacyGlob:     revision.LegacyGlob,
+		AppliesTo:      revision.AppliesTo,
+		RunFilters:     revision.RunFilters,
+		RelationshipID: revision.RelationshipID,
+		ObjectID:       revision.ObjectID,
+		Revision:       revision.Revision,
+		UpdatedAt:      revision.CreatedAt,
+	}
 }
@@ -1,8 +1,17 @@
+DROP VIEW IF EXISTS test_results_view;
+
 CREATE VIEW test_results_view AS
 SELECT
     r.id::text AS result_id,
     r.product_id::text AS product_id,
+    r.commit AS commit,
+    r.branch AS branch,
+    r.pipeline AS pipeline,
+    r.build_id AS build_id,
+    r.build_url AS build_url,
+    r.environment AS environment,
     ts.id::text AS test_suite_id,
+    ts.parent_id::text AS test_suite_parent_id,
     ts.name AS test_suite_name,
     ts.tests AS test_suite_tests,
     ts.failures AS test_suite_failures,
@@ -11,8 +20,8 @@ SELECT
     ts.assertions AS test_suite_assertions,
     ts.time AS test_suite_time,
     ts.file AS test_suite_file,
-    ts.system_out AS test_suite_system_out,
-    ts.system_err AS test_suite_system_err,
+    ts.system_out_id AS test_suite_system_out_id,
+    ts.system_err_id AS test_suite_system_err_id,
     tc.id::text AS test_case_id,
     tc.name AS test_case_name,
     tc.class_name AS test_case_class_name,
@@ -23,8 +32,8 @@ SELECT
     tc.assertions AS test_case_assertions,
     tc.file AS test_case_file,
     tc.line AS test_case_line,
-    tc.system_out AS test_case_system_out,
-    tc.system_err AS test_case_system_err
+    tc.system_out_id AS test_case_system_out_id,
+    tc.system_err_id AS test_case_system_err_id
 FROM
     results r
 JOIN
@@ -1,9 +1,12 @@
 package http
 
 import (
+	"hypha/api/internal/config"
 	"hypha/api/internal/db"
 	"hypha/api/internal/http/routes"
+	"hypha/api/internal/utils/blobs"
 	"hypha/api/internal/utils/logging"
+	"hypha/api/internal/utils/results"
 
 	"github.com/gin-gonic/gin"
 )
@@ -11,12 +14,15 @@ import (
 var log = logging.Logger
 
 // InitRoutes initializes all the routes for the given router engine.
-// It sets up the database-related routes and results-related routes.
+// It sets up the database-related routes, results-related routes and attachment routes.
 //
 // Parameters:
 // - router: The Gin engine to which the routes will be added.
 // - dbOps: The database operations interface used for database interactions.
-func InitRoutes(router *gin.Engine, dbOps db.DatabaseOperations) {
+// - cfg: The configuration object containing the ingestion settings.
+// - queue: The queue processing asynchronous uploads.
+// - store: The blob store holding the content of test attachments.
+func InitRoutes(router *gin.Engine, dbOps db.DatabaseOperations, cfg *config.Config, queue *results.Queue, store blobs.Store) {
 	log.Info().Msg("Initializing routes")
 
 	dbGroup := router.Group("/db")
@@ -25,7 +31,8 @@ func InitRoutes(router *gin.Engine, dbOps db.DatabaseOperations) {
 	routes.InitRuleRoutes(dbGroup, dbOps)
 
 	resultsGroup := router.Group("/results")
-	routes.InitResultsRoutes(resultsGroup, dbOps)
+	routes.InitResultsRoutes(resultsGroup, dbOps, cfg, queue)
+	routes.InitAttachmentRoutes(resultsGroup, dbOps, cfg, store)
 
 	log.Info().Msg("Routes initialized")
 }
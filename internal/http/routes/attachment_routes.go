@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"hypha/api/internal/config"
+	"hypha/api/internal/db"
+	"hypha/api/internal/db/handlers"
+	"hypha/api/internal/utils/blobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InitAttachmentRoutes initializes the attachment routes for the given router group.
+// It sets up the endpoints for uploading, listing and downloading test case attachments.
+//
+// Parameters:
+// - router: The router group to which the routes will be added.
+// - dbOps: The database operations interface used for database interactions.
+// - cfg: The configuration object containing the attachments settings.
+// - store: The blob store holding the content of the attachments.
+//
+// Routes:
+// - POST /case/:caseId/attachments: Calls UploadTestCaseAttachments to handle attaching files to a test case.
+// - GET /case/:caseId/attachments: Calls GetTestCaseAttachments to handle listing the attachments of a test case.
+// - POST /:id/attachments: Calls UploadResultAttachments to handle uploading the files referenced by a result.
+// - GET /attachments/:id: Calls DownloadAttachment to handle downloading the content of an attachment.
+func InitAttachmentRoutes(router *gin.RouterGroup, dbOps db.DatabaseOperations, cfg *config.Config, store blobs.Store) {
+	router.POST("/case/:caseId/attachments", func(context *gin.Context) {
+		handlers.UploadTestCaseAttachments(dbOps, store, cfg, context)
+	})
+	router.GET("/case/:caseId/attachments", func(context *gin.Context) {
+		handlers.GetTestCaseAttachments(dbOps, context)
+	})
+	router.POST("/:id/attachments", func(context *gin.Context) {
+		handlers.UploadResultAttachments(dbOps, store, cfg, context)
+	})
+	router.GET("/attachments/:id", func(context *gin.Context) {
+		handlers.DownloadAttachment(dbOps, store, context)
+	})
+}
@@ -54,7 +54,7 @@ func InitRelationshipRoutes(router *gin.RouterGroup, dbOps db.DatabaseOperations
 }
 
 // InitRuleRoutes initializes the rule routes for the given router group.
-// It sets up the POST and GET endpoints for creating and retrieving results rules.
+// It sets up the endpoints for creating, retrieving, changing and deleting results rules.
 //
 // Parameters:
 // - router: The router group to which the routes will be added.
@@ -64,6 +64,11 @@ func InitRelationshipRoutes(router *gin.RouterGroup, dbOps db.DatabaseOperations
 // - POST /results-rule: Calls CreateResultsRule to handle the creation of a new results rule.
 // - GET /results-rule/:id: Calls GetResultsRule to handle retrieving a results rule by ID.
 // - GET /results-rule/relation/:id: Calls GetRulesByRelationIDto handle retrieving results rules using a relation ID
+// - POST /results-rule/preview: Calls PreviewResultsRule to handle evaluating a draft results rule without saving it.
+// - GET /results-rule/:id/revisions: Calls GetResultsRuleRevisions to handle retrieving the history of a results rule.
+// - PUT /results-rule/:id: Calls UpdateResultsRule to handle replacing a results rule.
+// - PATCH /results-rule/:id: Calls PatchResultsRule to handle changing some fields of a results rule.
+// - DELETE /results-rule/:id: Calls DeleteResultsRule to handle deleting a results rule.
 func InitRuleRoutes(router *gin.RouterGroup, dbOps db.DatabaseOperations) {
 	router.POST("/results-rule", func(context *gin.Context) {
 		handlers.CreateResultsRule(dbOps, context)
@@ -74,4 +79,19 @@ func InitRuleRoutes(router *gin.RouterGroup, dbOps db.DatabaseOperations) {
 	router.GET("/results-rule/relation/:id", func(context *gin.Context) {
 		handlers.GetRulesByRelationID(dbOps, context)
 	})
+	router.POST("/results-rule/preview", func(context *gin.Context) {
+		handlers.PreviewResultsRule(dbOps, context)
+	})
+	router.GET("/results-rule/:id/revisions", func(context *gin.Context) {
+		handlers.GetResultsRuleRevisions(dbOps, context)
+	})
+	router.PUT("/results-rule/:id", func(context *gin.Context) {
+		handlers.UpdateResultsRule(dbOps, context)
+	})
+	router.PATCH("/results-rule/:id", func(context *gin.Context) {
+		handlers.PatchResultsRule(dbOps, context)
+	})
+	router.DELETE("/results-rule/:id", func(context *gin.Context) {
+		handlers.DeleteResultsRule(dbOps, context)
+	})
 }
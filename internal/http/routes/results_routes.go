@@ -1,8 +1,10 @@
 package routes
 
 import (
+	"hypha/api/internal/config"
 	"hypha/api/internal/db"
 	"hypha/api/internal/db/handlers"
+	"hypha/api/internal/utils/results"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,19 +15,33 @@ import (
 // Parameters:
 // - router: The router group to which the routes will be added.
 // - dpOps: The database operations interface used for database interactions.
+// - cfg: The configuration object containing the ingestion settings.
+// - queue: The queue processing asynchronous uploads.
 //
 // Routes:
 // - GET /integration/:id: Calls GetResultsByIntegrationID to handle retrieving results by integration ID.
 // - GET /product/:productId: Calls GetResultsByProductID to handle retrieving results by product ID.
+// - GET /jobs/:id: Calls GetIngestionJob to handle retrieving the state of an asynchronous upload.
+// - GET /:id/ctrf: Calls GetResultCTRF to handle exporting a result as a CTRF report.
+// - GET /output/:id: Calls GetOutput to handle retrieving a system-out or system-err log.
 // - POST /results: Calls ReportResults to handle reporting new results.
-func InitResultsRoutes(router *gin.RouterGroup, dpOps db.DatabaseOperations) {
+func InitResultsRoutes(router *gin.RouterGroup, dpOps db.DatabaseOperations, cfg *config.Config, queue *results.Queue) {
 	router.GET("/relationship/:id", func(context *gin.Context) {
 		handlers.GetResultsByRelationID(dpOps, context)
 	})
 	router.GET("/product/:productId", func(context *gin.Context) {
 		handlers.GetResultsByProductID(dpOps, context)
 	})
+	router.GET("/jobs/:id", func(context *gin.Context) {
+		handlers.GetIngestionJob(dpOps, context)
+	})
+	router.GET("/:id/ctrf", func(context *gin.Context) {
+		handlers.GetResultCTRF(dpOps, context)
+	})
+	router.GET("/output/:id", func(context *gin.Context) {
+		handlers.GetOutput(dpOps, context)
+	})
 	router.POST("/", func(context *gin.Context) {
-		handlers.ReportResults(dpOps, context)
+		handlers.ReportResults(dpOps, cfg, queue, context)
 	})
 }
@@ -0,0 +1,249 @@
+package blobs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// unsignedPayload is sent as the payload hash of requests whose body is streamed,
+// so uploads do not have to be read twice to be signed.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// emptyPayloadHash is the SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Store stores blobs as objects of a bucket of Amazon S3 or an S3-compatible service such as MinIO.
+// Requests are signed with AWS Signature Version 4.
+type S3Store struct {
+	client          *http.Client
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	prefix          string
+	accessKeyID     string
+	secretAccessKey string
+	pathStyle       bool
+	now             func() time.Time
+}
+
+// NewS3Store creates a store keeping blobs in an S3 bucket.
+//
+// Parameters:
+// - endpoint: The URL of the S3 service, e.g. https://s3.eu-west-1.amazonaws.com.
+// - region: The region of the bucket, used to sign requests.
+// - bucket: The name of the bucket.
+// - prefix: The prefix added to the key of every object.
+// - accessKeyID: The access key ID of the credentials.
+// - secretAccessKey: The secret access key of the credentials.
+// - pathStyle: Whether the bucket is addressed in the path instead of the host name.
+//
+// Returns:
+// - *S3Store: The store.
+// - error: An error if the endpoint is invalid or settings are missing.
+func NewS3Store(endpoint, region, bucket, prefix, accessKeyID, secretAccessKey string, pathStyle bool) (*S3Store, error) {
+	if endpoint == "" || region == "" || bucket == "" {
+		return nil, errors.New("the s3 attachment store requires an endpoint, a region and a bucket")
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	if endpointURL.Scheme == "" || endpointURL.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+	return &S3Store{
+		client:          &http.Client{},
+		endpoint:        endpointURL,
+		region:          region,
+		bucket:          bucket,
+		prefix:          prefix,
+		accessKeyID:     accessKeyID,
+		secretAccessKey: secretAccessKey,
+		pathStyle:       pathStyle,
+		now:             time.Now,
+	}, nil
+}
+
+// Put uploads the content as an object with a single PUT request.
+func (store *S3Store) Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error {
+	request, err := store.newRequest(ctx, http.MethodPut, key, content)
+	if err != nil {
+		return err
+	}
+	request.ContentLength = size
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	response, err := store.do(request, unsignedPayload)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// Get downloads the object. The caller must close the returned body.
+func (store *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	request, err := store.newRequest(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := store.do(request, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// Delete removes the object. S3 reports success for objects that do not exist.
+func (store *S3Store) Delete(ctx context.Context, key string) error {
+	request, err := store.newRequest(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	response, err := store.do(request, emptyPayloadHash)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// newRequest creates a request for the object stored under key.
+func (store *S3Store) newRequest(ctx context.Context, method string, key string, body io.Reader) (*http.Request, error) {
+	objectURL := *store.endpoint
+	objectPath := strings.TrimSuffix(objectURL.Path, "/")
+	if store.pathStyle {
+		objectPath += "/" + store.bucket
+	} else {
+		objectURL.Host = store.bucket + "." + objectURL.Host
+	}
+	objectURL.Path = objectPath + "/" + store.prefix + key
+	objectURL.RawPath = uriEncode(objectURL.Path, false)
+	return http.NewRequestWithContext(ctx, method, objectURL.String(), body)
+}
+
+// do signs and sends a request. Responses other than 2xx are closed and returned as errors,
+// with ErrNotFound for missing objects.
+func (store *S3Store) do(request *http.Request, payloadHash string) (*http.Response, error) {
+	store.sign(request, payloadHash, store.now().UTC())
+	response, err := store.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return response, nil
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	return nil, fmt.Errorf("s3 %s %s: %s: %s", request.Method, request.URL.Path, response.Status, strings.TrimSpace(string(body)))
+}
+
+// sign adds the AWS Signature Version 4 headers to a request. The host and every header already
+// set on the request are signed.
+//
+// Parameters:
+// - request: The request to sign.
+// - payloadHash: The hex encoded SHA-256 of the body, or UNSIGNED-PAYLOAD.
+// - now: The time of the request, in UTC.
+func (store *S3Store) sign(request *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": request.URL.Host}
+	for name, values := range request.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		canonicalQuery(request.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + store.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+store.secretAccessKey), date)
+	key = hmacSHA256(key, store.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		store.accessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns the query parameters sorted by name and encoded as required by Signature Version 4.
+func canonicalQuery(query url.Values) string {
+	parameters := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			parameters = append(parameters, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(parameters)
+	return strings.Join(parameters, "&")
+}
+
+// uriEncode percent-encodes every byte of value except unreserved characters, as required by
+// Signature Version 4. Slashes are kept unless encodeSlash is set.
+func uriEncode(value string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			encoded.WriteByte(c)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
+
+// hmacSHA256 returns the HMAC-SHA256 of data with the given key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// hexSHA256 returns the hex encoded SHA-256 of data.
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
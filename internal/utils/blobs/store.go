@@ -0,0 +1,43 @@
+package blobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hypha/api/internal/config"
+	"io"
+)
+
+// ErrNotFound is returned when a blob does not exist in the store.
+var ErrNotFound = errors.New("blob not found")
+
+// Store stores blobs, such as test attachments, by key.
+// Keys are slash-separated paths created by the API, never by clients.
+type Store interface {
+	// Put stores the content under key, replacing any blob stored under the same key.
+	Put(ctx context.Context, key string, content io.Reader, size int64, contentType string) error
+	// Get opens the blob stored under key. It returns ErrNotFound if there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStore creates the blob store selected by the attachments configuration.
+//
+// Parameters:
+// - cfg: The configuration object containing the attachments settings.
+//
+// Returns:
+// - Store: The configured blob store.
+// - error: An error if the store is unknown or cannot be set up.
+func NewStore(cfg *config.Config) (Store, error) {
+	switch cfg.Attachments.Store {
+	case "local":
+		return NewLocalStore(cfg.Attachments.Local.Path)
+	case "s3":
+		s3 := cfg.Attachments.S3
+		return NewS3Store(s3.Endpoint, s3.Region, s3.Bucket, s3.Prefix, s3.AccessKeyID, s3.SecretAccessKey, s3.PathStyle)
+	default:
+		return nil, fmt.Errorf("unknown attachment store %q", cfg.Attachments.Store)
+	}
+}
@@ -0,0 +1,71 @@
+package queries
+
+import (
+	"hypha/api/internal/db/tables"
+
+	"github.com/go-orm/gorm"
+)
+
+// FillOutputs loads the system-out and system-err logs referenced by the test suites, test cases
+// and reruns of the given results and sets them on the models. Logs are left out of results by
+// default and only loaded when a client asks for them.
+//
+// Parameters:
+// - dbConn: The gorm.DB connection.
+// - results: The results whose logs are loaded. They are modified in place.
+//
+// Returns:
+// - error: An error if the logs cannot be fetched or decompressed.
+func FillOutputs(dbConn *gorm.DB, results []tables.Result) error {
+	var ids []string
+	forEachOutput(results, func(id *string, content *string) {
+		if id != nil {
+			ids = append(ids, *id)
+		}
+	})
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var outputs []tables.Output
+	if err := dbConn.Where("id IN (?)", ids).Find(&outputs).Error; err != nil {
+		return err
+	}
+	texts := make(map[string]string, len(outputs))
+	for _, output := range outputs {
+		text, err := output.Text()
+		if err != nil {
+			return err
+		}
+		texts[output.ID] = text
+	}
+
+	forEachOutput(results, func(id *string, content *string) {
+		if id != nil {
+			*content = texts[*id]
+		}
+	})
+	return nil
+}
+
+// forEachOutput calls fn with the output ID and the log field of every system-out and system-err
+// of the test suites, test cases and reruns of the given results.
+func forEachOutput(results []tables.Result, fn func(id *string, content *string)) {
+	for i := range results {
+		for j := range results[i].TestSuites {
+			suite := &results[i].TestSuites[j]
+			fn(suite.SystemOutID, &suite.SystemOut)
+			fn(suite.SystemErrID, &suite.SystemErr)
+			for k := range suite.TestCases {
+				testCase := &suite.TestCases[k]
+				fn(testCase.SystemOutID, &testCase.SystemOut)
+				fn(testCase.SystemErrID, &testCase.SystemErr)
+				for l := range testCase.Reruns {
+					rerun := &testCase.Reruns[l]
+					fn(rerun.SystemOutID, &rerun.SystemOut)
+					fn(rerun.SystemErrID, &rerun.SystemErr)
+				}
+			}
+		}
+	}
+}
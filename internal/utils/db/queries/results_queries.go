@@ -3,189 +3,414 @@ package queries
 import (
 	"hypha/api/internal/db/tables"
 	"hypha/api/internal/utils"
+	"hypha/api/internal/utils/rules"
+	"sort"
+	"time"
 
 	"github.com/go-orm/gorm"
 	"github.com/lib/pq"
 )
 
-func FetchResultsByRules(dbConn *gorm.DB, rules []*tables.ResultsRule) ([]tables.Result, error) {
+// FetchResultsByRules fetches the test suites and test cases each results rule selects from the
+// results of the products of its relationship, or of the one product the rule targets, grouped into
+// their results. Rules are translated into SQL, so only the selected suites and cases are read from
+// the database.
+//
+// Parameters:
+// - dbConn: The gorm.DB connection.
+// - resultsRules: The results rules, with their relationship loaded.
+//
+// Returns:
+// - []tables.Result: The results holding the selected suites and cases, most recently executed first.
+// - error: An error if any database operation fails.
+func FetchResultsByRules(dbConn *gorm.DB, resultsRules []*tables.ResultsRule) ([]tables.Result, error) {
 	var resultsMap = make(map[string]tables.Result)
 
-	for _, rule := range rules {
-		var viewResults []tables.TestResultsView
+	for _, rule := range resultsRules {
+		expression := compileRuleExpression(rule)
 
-		// Fetch results from the view based on relationship object IDs
-		if err := dbConn.Where("product_id = ANY(?)", pq.Array(rule.Relationship.ObjectIDs)).Find(&viewResults).Error; err != nil {
-			return nil, err
-		}
-
-		// Fetch properties for test suites and test cases
-		var suiteProperties []tables.Property
-		var caseProperties []tables.Property
-		suiteIDs := make([]string, 0)
-		caseIDs := make([]string, 0)
-		for _, vr := range viewResults {
-			if vr.TestSuiteID != "" {
-				suiteIDs = append(suiteIDs, vr.TestSuiteID)
-			}
-			if vr.TestCaseID != "" {
-				caseIDs = append(caseIDs, vr.TestCaseID)
+		// Fetch the suites matching the rule's expression, with all of their test cases
+		var matchedSuites []tables.TestSuite
+		if utils.Contains(rule.AppliesTo, rules.TargetSuite) {
+			if err := ruleQuery(dbConn, rule, expression, rules.TargetSuite).Select("ts.*").Find(&matchedSuites).Error; err != nil {
+				return nil, err
 			}
 		}
-
+		suiteMatches := make(map[string]bool, len(matchedSuites))
+		suiteIDs := make([]string, 0, len(matchedSuites))
+		for _, suite := range matchedSuites {
+			suiteMatches[suite.ID] = true
+			suiteIDs = append(suiteIDs, suite.ID)
+		}
+		var testCases []tables.TestCase
 		if len(suiteIDs) > 0 {
-			if err := dbConn.Where("test_suite_id IN (?)", suiteIDs).Find(&suiteProperties).Error; err != nil {
+			if err := dbConn.Where("test_suite_id = ANY(?)", pq.Array(suiteIDs)).Find(&testCases).Error; err != nil {
 				return nil, err
 			}
 		}
 
-		if len(caseIDs) > 0 {
-			if err := dbConn.Where("test_case_id IN (?)", caseIDs).Find(&caseProperties).Error; err != nil {
+		// Fetch the cases matching the rule's expression, with the suites they belong to
+		var caseSuiteIDs []string
+		caseSuites := make(map[string]bool)
+		if utils.Contains(rule.AppliesTo, rules.TargetCase) {
+			var matchedCases []tables.TestCase
+			if err := ruleQuery(dbConn, rule, expression, rules.TargetCase).Select("tc.*").Find(&matchedCases).Error; err != nil {
 				return nil, err
 			}
-		}
-
-		// Filter the results based on the rule's expression
-		filteredSuites := make(map[string]tables.TestSuite)
-		filteredCases := make(map[string][]tables.TestCase)
-
-		for _, vr := range viewResults {
-			if utils.Contains(rule.AppliesTo, "suite") && utils.MatchesExpression(vr.TestSuiteName, rule.Expression) {
-				if _, exists := filteredSuites[vr.TestSuiteID]; !exists {
-					filteredSuites[vr.TestSuiteID] = tables.TestSuite{
-						ID:         vr.TestSuiteID,
-						ResultID:   vr.ResultID,
-						Name:       vr.TestSuiteName,
-						Tests:      vr.TestSuiteTests,
-						Failures:   vr.TestSuiteFailures,
-						Errors:     vr.TestSuiteErrors,
-						Skipped:    vr.TestSuiteSkipped,
-						Assertions: vr.TestSuiteAssertions,
-						Time:       vr.TestSuiteTime,
-						File:       vr.TestSuiteFile,
-						SystemOut:  vr.TestSuiteSystemOut,
-						SystemErr:  vr.TestSuiteSystemErr,
-						TestCases:  []tables.TestCase{},
-						Properties: []tables.Property{},
-					}
+			for _, testCase := range matchedCases {
+				// Cases of a matching suite are already included
+				if suiteMatches[testCase.TestSuiteID] {
+					continue
 				}
-				// Ensure all test cases for the matching suite are included
-				if vr.TestCaseID != "" {
-					filteredCases[vr.TestSuiteID] = append(filteredCases[vr.TestSuiteID], tables.TestCase{
-						ID:          vr.TestCaseID,
-						TestSuiteID: vr.TestSuiteID,
-						ClassName:   vr.TestCaseClassName,
-						Name:        vr.TestCaseName,
-						Time:        vr.TestCaseTime,
-						Status:      vr.TestCaseStatus,
-						Message:     vr.TestCaseMessage,
-						Type:        vr.TestCaseType,
-						Assertions:  vr.TestCaseAssertions,
-						File:        vr.TestCaseFile,
-						Line:        vr.TestCaseLine,
-						SystemOut:   vr.TestCaseSystemOut,
-						SystemErr:   vr.TestCaseSystemErr,
-						Properties:  []tables.Property{},
-					})
+				if !caseSuites[testCase.TestSuiteID] {
+					caseSuites[testCase.TestSuiteID] = true
+					caseSuiteIDs = append(caseSuiteIDs, testCase.TestSuiteID)
 				}
+				testCases = append(testCases, testCase)
 			}
-			if utils.Contains(rule.AppliesTo, "case") && utils.MatchesExpression(vr.TestCaseName, rule.Expression) {
-				filteredCases[vr.TestSuiteID] = append(filteredCases[vr.TestSuiteID], tables.TestCase{
-					ID:          vr.TestCaseID,
-					TestSuiteID: vr.TestSuiteID,
-					ClassName:   vr.TestCaseClassName,
-					Name:        vr.TestCaseName,
-					Time:        vr.TestCaseTime,
-					Status:      vr.TestCaseStatus,
-					Message:     vr.TestCaseMessage,
-					Type:        vr.TestCaseType,
-					Assertions:  vr.TestCaseAssertions,
-					File:        vr.TestCaseFile,
-					Line:        vr.TestCaseLine,
-					SystemOut:   vr.TestCaseSystemOut,
-					SystemErr:   vr.TestCaseSystemErr,
-					Properties:  []tables.Property{},
-				})
+		}
+		suites := matchedSuites
+		if len(caseSuiteIDs) > 0 {
+			var suitesOfCases []tables.TestSuite
+			if err := dbConn.Where("id = ANY(?::uuid[])", pq.Array(caseSuiteIDs)).Find(&suitesOfCases).Error; err != nil {
+				return nil, err
 			}
+			suites = append(suites, suitesOfCases...)
+		}
+		if len(suites) == 0 {
+			continue
 		}
 
-		// Ensure all test suites that have test cases are included
-		for suiteID, cases := range filteredCases {
-			if suite, exists := filteredSuites[suiteID]; exists {
-				suite.TestCases = cases
-				filteredSuites[suiteID] = suite
-			} else {
-				// Populate suite data when only test cases are matched
-				for _, vr := range viewResults {
-					if vr.TestSuiteID == suiteID {
-						filteredSuites[suiteID] = tables.TestSuite{
-							ID:         vr.TestSuiteID,
-							ResultID:   vr.ResultID,
-							Name:       vr.TestSuiteName,
-							Tests:      vr.TestSuiteTests,
-							Failures:   vr.TestSuiteFailures,
-							Errors:     vr.TestSuiteErrors,
-							Skipped:    vr.TestSuiteSkipped,
-							Assertions: vr.TestSuiteAssertions,
-							Time:       vr.TestSuiteTime,
-							File:       vr.TestSuiteFile,
-							SystemOut:  vr.TestSuiteSystemOut,
-							SystemErr:  vr.TestSuiteSystemErr,
-							TestCases:  cases,
-							Properties: []tables.Property{},
-						}
-						break
-					}
-				}
+		// Attach properties, failures, reruns, steps and attachments
+		if err := fillTestCaseDetails(dbConn, testCases); err != nil {
+			return nil, err
+		}
+		if err := fillTestSuiteProperties(dbConn, suites); err != nil {
+			return nil, err
+		}
+		casesBySuite := make(map[string][]tables.TestCase)
+		for _, testCase := range testCases {
+			casesBySuite[testCase.TestSuiteID] = append(casesBySuite[testCase.TestSuiteID], testCase)
+		}
+		for i := range suites {
+			suites[i].TestCases = casesBySuite[suites[i].ID]
+			if suites[i].TestCases == nil {
+				suites[i].TestCases = []tables.TestCase{}
 			}
 		}
 
-		// Add properties to the respective test suites and test cases
-		for _, prop := range suiteProperties {
-			if suite, exists := filteredSuites[*prop.TestSuiteID]; exists {
-				suite.Properties = append(suite.Properties, prop)
-				filteredSuites[*prop.TestSuiteID] = suite
+		// Fetch the results of the suites that are not already included
+		var resultIDs []string
+		missingResults := make(map[string]bool)
+		for _, suite := range suites {
+			if _, exists := resultsMap[suite.ResultID]; !exists && !missingResults[suite.ResultID] {
+				missingResults[suite.ResultID] = true
+				resultIDs = append(resultIDs, suite.ResultID)
 			}
 		}
-
-		for _, prop := range caseProperties {
-			for suiteID, cases := range filteredCases {
-				for i, testCase := range cases {
-					if testCase.ID == *prop.TestCaseID {
-						testCase.Properties = append(testCase.Properties, prop)
-						filteredCases[suiteID][i] = testCase
-					}
-				}
+		if len(resultIDs) > 0 {
+			var results []tables.Result
+			if err := dbConn.Where("id = ANY(?::uuid[])", pq.Array(resultIDs)).Find(&results).Error; err != nil {
+				return nil, err
+			}
+			for _, result := range results {
+				resultsMap[result.ID] = result
 			}
 		}
 
-		// Combine filtered suites into their respective results
-		for _, suite := range filteredSuites {
+		// Combine the suites into their respective results
+		for _, suite := range suites {
 			if result, exists := resultsMap[suite.ResultID]; exists {
 				result.TestSuites = append(result.TestSuites, suite)
 				resultsMap[suite.ResultID] = result
-			} else {
-				// Fetch the actual result to get the accurate DateReported
-				var actualResult tables.Result
-				if err := dbConn.First(&actualResult, "id = ?", suite.ResultID).Error; err != nil {
-					return nil, err
-				}
-
-				resultsMap[suite.ResultID] = tables.Result{
-					ID:           suite.ResultID,
-					ProductID:    viewResults[0].ProductID,
-					TestSuites:   []tables.TestSuite{suite},
-					DateReported: actualResult.DateReported,
-				}
 			}
 		}
 	}
 
-	// Convert resultsMap to a slice
+	// Convert resultsMap to a slice, ordered by execution time with the most recent first
 	var results []tables.Result
 	for _, result := range resultsMap {
 		results = append(results, result)
 	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].ExecutionTime().After(results[j].ExecutionTime())
+	})
 
 	return results, nil
 }
+
+// ruleQuery builds a query over the test suites (aliased ts) or test cases (aliased tc) a results
+// rule selects: those of the results of the products it applies to that match its run filters and
+// its expression.
+//
+// Parameters:
+// - dbConn: The gorm.DB connection.
+// - rule: The results rule, with its relationship loaded.
+// - expression: The compiled expression of the rule.
+// - target: rules.TargetSuite or rules.TargetCase.
+//
+// Returns:
+// - *gorm.DB: The query, joined to the suites (aliased ts) and results (aliased r).
+func ruleQuery(dbConn *gorm.DB, rule *tables.ResultsRule, expression *rules.Expression, target string) *gorm.DB {
+	query := dbConn.Table("test_suites ts")
+	if target == rules.TargetCase {
+		query = dbConn.Table("test_cases tc").Joins("JOIN test_suites ts ON ts.id::text = tc.test_suite_id")
+	}
+	query = query.Joins("JOIN results r ON r.id::text = ts.result_id").
+		Where("r.product_id = ANY(?)", pq.Array(rule.TargetObjectIDs()))
+	if condition, args := runFiltersCondition(rule.RunFilters); condition != "" {
+		query = query.Where(condition, args...)
+	}
+	if condition, args := ruleCondition(expression, target); condition != "" {
+		query = query.Where(condition, args...)
+	}
+	return query
+}
+
+// fillTestCaseDetails loads the properties, failures, reruns, steps and attachments of test cases
+// and sets them on the models.
+//
+// Parameters:
+// - dbConn: The gorm.DB connection.
+// - testCases: The test cases. They are modified in place.
+//
+// Returns:
+// - error: An error if any database operation fails.
+func fillTestCaseDetails(dbConn *gorm.DB, testCases []tables.TestCase) error {
+	if len(testCases) == 0 {
+		return nil
+	}
+	caseIDs := make([]string, 0, len(testCases))
+	for _, testCase := range testCases {
+		caseIDs = append(caseIDs, testCase.ID)
+	}
+
+	var properties []tables.Property
+	if err := dbConn.Where("test_case_id = ANY(?)", pq.Array(caseIDs)).Find(&properties).Error; err != nil {
+		return err
+	}
+	propertiesByCase := make(map[string][]tables.Property)
+	for _, prop := range properties {
+		propertiesByCase[*prop.TestCaseID] = append(propertiesByCase[*prop.TestCaseID], prop)
+	}
+
+	var failures []tables.TestCaseFailure
+	if err := dbConn.Where("test_case_id = ANY(?)", pq.Array(caseIDs)).Order("position").Find(&failures).Error; err != nil {
+		return err
+	}
+	failuresByCase := make(map[string][]tables.TestCaseFailure)
+	for _, failure := range failures {
+		failuresByCase[failure.TestCaseID] = append(failuresByCase[failure.TestCaseID], failure)
+	}
+
+	var reruns []tables.TestCaseRerun
+	if err := dbConn.Where("test_case_id = ANY(?)", pq.Array(caseIDs)).Order("attempt").Find(&reruns).Error; err != nil {
+		return err
+	}
+	rerunsByCase := make(map[string][]tables.TestCaseRerun)
+	for _, rerun := range reruns {
+		rerunsByCase[rerun.TestCaseID] = append(rerunsByCase[rerun.TestCaseID], rerun)
+	}
+
+	var steps []tables.TestCaseStep
+	if err := dbConn.Where("test_case_id = ANY(?)", pq.Array(caseIDs)).Order("position").Find(&steps).Error; err != nil {
+		return err
+	}
+	stepsByCase := make(map[string][]tables.TestCaseStep)
+	for _, step := range steps {
+		stepsByCase[step.TestCaseID] = append(stepsByCase[step.TestCaseID], step)
+	}
+
+	var attachments []tables.Attachment
+	if err := dbConn.Where("test_case_id = ANY(?)", pq.Array(caseIDs)).Order("created_at").Find(&attachments).Error; err != nil {
+		return err
+	}
+	attachmentsByCase := make(map[string][]tables.Attachment)
+	for _, attachment := range attachments {
+		attachmentsByCase[attachment.TestCaseID] = append(attachmentsByCase[attachment.TestCaseID], attachment)
+	}
+
+	for i := range testCases {
+		testCase := &testCases[i]
+		testCase.Properties = append([]tables.Property{}, propertiesByCase[testCase.ID]...)
+		testCase.Failures = failuresByCase[testCase.ID]
+		testCase.Reruns = rerunsByCase[testCase.ID]
+		testCase.Steps = stepsByCase[testCase.ID]
+		testCase.Attachments = attachmentsByCase[testCase.ID]
+	}
+	return nil
+}
+
+// fillTestSuiteProperties loads the properties of test suites and sets them on the models.
+//
+// Parameters:
+// - dbConn: The gorm.DB connection.
+// - suites: The test suites. They are modified in place.
+//
+// Returns:
+// - error: An error if the properties cannot be fetched.
+func fillTestSuiteProperties(dbConn *gorm.DB, suites []tables.TestSuite) error {
+	suiteIDs := make([]string, 0, len(suites))
+	for _, suite := range suites {
+		suiteIDs = append(suiteIDs, suite.ID)
+	}
+
+	var properties []tables.Property
+	if err := dbConn.Where("test_suite_id = ANY(?)", pq.Array(suiteIDs)).Find(&properties).Error; err != nil {
+		return err
+	}
+	propertiesBySuite := make(map[string][]tables.Property)
+	for _, prop := range properties {
+		propertiesBySuite[*prop.TestSuiteID] = append(propertiesBySuite[*prop.TestSuiteID], prop)
+	}
+
+	for i := range suites {
+		suites[i].Properties = append([]tables.Property{}, propertiesBySuite[suites[i].ID]...)
+	}
+	return nil
+}
+
+// previewSampleSize is the number of suite and case names given as samples in a RulePreview.
+const previewSampleSize = 10
+
+// RulePreview summarizes what a results rule selects from the latest results of its relationship.
+type RulePreview struct {
+	Results      int                 `json:"results"`      // Number of results the rule was evaluated against
+	Suites       int                 `json:"suites"`       // Number of suites the rule selects
+	Cases        int                 `json:"cases"`        // Number of cases the rule selects outside the selected suites
+	SampleSuites []string            `json:"sampleSuites"` // Distinct names of some of the selected suites
+	SampleCases  []string            `json:"sampleCases"`  // Distinct names of some of the selected cases
+	ByResult     []RulePreviewResult `json:"byResult"`     // Counts for each result, most recently executed first
+}
+
+// RulePreviewResult counts the suites and cases a results rule selects from a single result.
+type RulePreviewResult struct {
+	ResultID   string    `json:"resultID"`
+	ProductID  string    `json:"productID"`
+	ExecutedAt time.Time `json:"executedAt"` // Time the tests ran, or the result was reported if unknown
+	Suites     int       `json:"suites"`
+	Cases      int       `json:"cases"`
+}
+
+// PreviewResultsRule evaluates a results rule against the latest results of the products it applies
+// to that match its run filters, in the same way as FetchResultsByRules, and summarizes what it
+// selects. Nothing is written to the database.
+//
+// Parameters:
+// - dbConn: The gorm.DB connection.
+// - rule: The results rule, with its relationship loaded. Its expression must be valid.
+// - limit: The number of latest results to evaluate the rule against.
+//
+// Returns:
+// - RulePreview: The counts and samples of the selected suites and cases.
+// - error: An error if any database operation fails.
+func PreviewResultsRule(dbConn *gorm.DB, rule *tables.ResultsRule, limit int) (RulePreview, error) {
+	preview := RulePreview{SampleSuites: []string{}, SampleCases: []string{}, ByResult: []RulePreviewResult{}}
+
+	// Fetch the latest results the rule applies to
+	var results []tables.Result
+	query := dbConn.Table("results r").Where("r.product_id = ANY(?)", pq.Array(rule.TargetObjectIDs()))
+	if condition, args := runFiltersCondition(rule.RunFilters); condition != "" {
+		query = query.Where(condition, args...)
+	}
+	if err := query.Order(tables.ResultsHistoryOrder).Limit(limit).Find(&results).Error; err != nil {
+		return preview, err
+	}
+	preview.Results = len(results)
+	if len(results) == 0 {
+		return preview, nil
+	}
+	resultIDs := make([]string, 0, len(results))
+	for _, result := range results {
+		resultIDs = append(resultIDs, result.ID)
+	}
+
+	expression := compileRuleExpression(rule)
+	suitesByResult := make(map[string]int)
+	casesByResult := make(map[string]int)
+
+	// Count the suites matching the rule's expression
+	suiteMatches := make(map[string]bool)
+	if utils.Contains(rule.AppliesTo, rules.TargetSuite) {
+		var suites []struct {
+			ID       string
+			Name     string
+			ResultID string
+		}
+		err := ruleQuery(dbConn, rule, expression, rules.TargetSuite).
+			Where("r.id = ANY(?::uuid[])", pq.Array(resultIDs)).
+			Select("ts.id::text AS id, ts.name, ts.result_id").Scan(&suites).Error
+		if err != nil {
+			return preview, err
+		}
+		for _, suite := range suites {
+			suiteMatches[suite.ID] = true
+			suitesByResult[suite.ResultID]++
+			preview.SampleSuites = addSample(preview.SampleSuites, suite.Name)
+		}
+		preview.Suites = len(suites)
+	}
+
+	// Count the cases matching the rule's expression; cases of a matching suite are already included
+	if utils.Contains(rule.AppliesTo, rules.TargetCase) {
+		var cases []struct {
+			Name        string
+			TestSuiteID string
+			ResultID    string
+		}
+		err := ruleQuery(dbConn, rule, expression, rules.TargetCase).
+			Where("r.id = ANY(?::uuid[])", pq.Array(resultIDs)).
+			Select("tc.name, tc.test_suite_id, ts.result_id").Scan(&cases).Error
+		if err != nil {
+			return preview, err
+		}
+		for _, testCase := range cases {
+			if suiteMatches[testCase.TestSuiteID] {
+				continue
+			}
+			casesByResult[testCase.ResultID]++
+			preview.SampleCases = addSample(preview.SampleCases, testCase.Name)
+			preview.Cases++
+		}
+	}
+
+	for _, result := range results {
+		preview.ByResult = append(preview.ByResult, RulePreviewResult{
+			ResultID:   result.ID,
+			ProductID:  result.ProductID,
+			ExecutedAt: result.ExecutionTime(),
+			Suites:     suitesByResult[result.ID],
+			Cases:      casesByResult[result.ID],
+		})
+	}
+	return preview, nil
+}
+
+// addSample adds a name to the samples of a RulePreview unless it is already there or the samples are full.
+func addSample(samples []string, name string) []string {
+	if len(samples) >= previewSampleSize || utils.Contains(samples, name) {
+		return samples
+	}
+	return append(samples, name)
+}
+
+// compileRuleExpression compiles the expression of a results rule. Rules stored before the
+// expression language existed are flagged as legacy globs and read as a single wildcard pattern,
+// with the meaning it had then, even when the pattern is also a valid expression. Other expressions are checked when the
+// rule is saved; one that still fails to compile selects nothing.
+//
+// Parameters:
+// - rule: The results rule.
+//
+// Returns:
+// - *rules.Expression: The compiled expression.
+func compileRuleExpression(rule *tables.ResultsRule) *rules.Expression {
+	if rule.LegacyGlob {
+		return rules.CompileWildcard(rule.Expression)
+	}
+	expression, err := rules.Compile(rule.Expression)
+	if err != nil {
+		return rules.CompileWildcard("!*")
+	}
+	return expression
+}
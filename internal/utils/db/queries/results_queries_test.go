@@ -0,0 +1,49 @@
+package queries
+
+import (
+	"hypha/api/internal/db/tables"
+	"hypha/api/internal/utils/rules"
+	"testing"
+)
+
+func TestCompileRuleExpression(t *testing.T) {
+	tests := []struct {
+		expression string
+		legacyGlob bool
+		name       string
+		want       bool
+	}{
+		// Legacy globs are read as globs even when they are valid expressions
+		{"Payments and Refunds", true, "Payments and Refunds", true},
+		{"Payments and Refunds", true, "Payments", false},
+		{"Payments and Refunds", false, "Payments and Refunds", false},
+		{"Smoke or Regression", true, "Smoke or Regression", true},
+		{"Smoke or Regression", false, "Smoke", true},
+		{"not flaky", true, "not flaky", true},
+		{"not flaky", false, "stable", true},
+		{"!*Slow*", true, "Checkout Slow path", false},
+		{"!*Slow*", false, "Checkout Slow path", false},
+		{"Pay (EU", true, "Pay (EU", true},
+
+		// Legacy globs match anywhere in the name and only "*" is a wildcard
+		{"Pay", true, "Payments", true},
+		{"Pay", true, "xPayx", true},
+		{"Pay", false, "Payments", false},
+		{"Pay*Refund", true, "Payments and Refunds", true},
+		{"Refunds?v2", true, "RefundsXv2", false},
+		{"Refunds?v2", true, "Refunds?v2", true},
+		{"Refunds?v2", false, "RefundsXv2", true},
+		{`Pay\*`, true, "Pay*", false},
+
+		// Expressions that do not compile select nothing instead of being guessed at
+		{"Pay (EU", false, "Pay (EU", false},
+		{`name = "*"`, false, "anything", true},
+	}
+	for _, test := range tests {
+		rule := &tables.ResultsRule{Expression: test.expression, LegacyGlob: test.legacyGlob}
+		got := compileRuleExpression(rule).Matches(rules.Subject{Target: rules.TargetCase, Case: test.name})
+		if got != test.want {
+			t.Errorf("rule %q (legacy glob %v) matches %q = %v, want %v", test.expression, test.legacyGlob, test.name, got, test.want)
+		}
+	}
+}
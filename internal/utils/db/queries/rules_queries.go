@@ -1,10 +1,20 @@
 package queries
 
 import (
+	"errors"
+	"fmt"
 	"hypha/api/internal/db"
 	"hypha/api/internal/db/tables"
+	"strings"
+	"time"
+
+	"github.com/go-orm/gorm"
 )
 
+// ErrRuleConflict is returned by SaveResultsRule when the rule was changed by another request
+// since it was read.
+var ErrRuleConflict = errors.New("results rule was changed concurrently")
+
 // FetchRulesByRelationID retrieves results rules by their relation ID from the database.
 //
 // Parameters:
@@ -22,3 +32,153 @@ func FetchRulesByRelationID(dbOps db.DatabaseOperations, relationID string) ([]*
 	}
 	return rules, nil
 }
+
+// ValidateRunFilters checks that every run filter has the form "field=pattern",
+// where field is the name of a run metadata field.
+//
+// Parameters:
+// - filters: The run filters of a results rule.
+//
+// Returns:
+// - error: An error describing the first malformed filter, or nil if all filters are valid.
+func ValidateRunFilters(filters []string) error {
+	for _, filter := range filters {
+		name, _, found := strings.Cut(filter, "=")
+		if !found {
+			return fmt.Errorf("invalid run filter %q: expected field=pattern", filter)
+		}
+		if _, known := tables.RunMetadataColumns[name]; !known {
+			return fmt.Errorf("invalid run filter %q: unknown field %q", filter, name)
+		}
+	}
+	return nil
+}
+
+// SaveResultsRule creates, updates or deletes a results rule and records its new state as the next
+// revision of the rule, in a single transaction. Deleted rules are kept with DeletedAt set.
+//
+// Parameters:
+// - dbOps: The database operations interface used for database interactions.
+// - rule: The rule, holding its new state. Its revision and timestamps are updated.
+// - action: tables.RuleCreated, tables.RuleUpdated or tables.RuleDeleted.
+// - changedBy: Who made the change, if known.
+//
+// Returns:
+// - error: ErrRuleConflict if the rule was changed since it was read, or an error if any database operation fails.
+func SaveResultsRule(dbOps db.DatabaseOperations, rule *tables.ResultsRule, action string, changedBy string) error {
+	now := time.Now().UTC()
+	return dbOps.Transaction(func(tx db.DatabaseOperations) error {
+		previousRevision := rule.Revision
+		rule.Revision++
+		rule.UpdatedAt = now
+
+		if action == tables.RuleCreated {
+			rule.CreatedAt = now
+			if err := tx.Create(rule); err != nil {
+				return err
+			}
+		} else {
+			if action == tables.RuleDeleted {
+				rule.DeletedAt = &now
+			}
+			// Only the revision that was read is replaced, so concurrent changes are not lost
+			update := tx.Connection().Model(rule).Where("revision = ?", previousRevision).UpdateColumns(map[string]interface{}{
+				"expression":      rule.Expression,
+				"legacy_glob":     rule.LegacyGlob,
+				"applies_to":      rule.AppliesTo,
+				"run_filters":     rule.RunFilters,
+				"relationship_id": rule.RelationshipID,
+				"object_id":       rule.ObjectID,
+				"revision":        rule.Revision,
+				"updated_at":      rule.UpdatedAt,
+				"deleted_at":      rule.DeletedAt,
+			})
+			if update.Error != nil {
+				return update.Error
+			}
+			if update.RowsAffected == 0 {
+				return ErrRuleConflict
+			}
+		}
+
+		revision := tables.ResultsRuleRevision{
+			ID:             db.GenerateUniqueID(),
+			RuleID:         rule.ID,
+			Revision:       rule.Revision,
+			Action:         action,
+			Expression:     rule.Expression,
+			LegacyGlob:     rule.LegacyGlob,
+			AppliesTo:      rule.AppliesTo,
+			RunFilters:     rule.RunFilters,
+			RelationshipID: rule.RelationshipID,
+			ObjectID:       rule.ObjectID,
+			ChangedBy:      changedBy,
+			CreatedAt:      now,
+		}
+		return tx.Create(&revision)
+	})
+}
+
+// FetchRuleRevisions retrieves the revisions of a results rule, oldest first.
+// Revisions of deleted rules are included.
+//
+// Parameters:
+// - dbOps: The database operations interface used for database interactions.
+// - ruleID: The ID of the results rule.
+//
+// Returns:
+// - []tables.ResultsRuleRevision: The revisions of the rule, empty if the rule does not exist.
+// - error: An error if any database operation fails.
+func FetchRuleRevisions(dbOps db.DatabaseOperations, ruleID string) ([]tables.ResultsRuleRevision, error) {
+	var revisions []tables.ResultsRuleRevision
+	if err := dbOps.Connection().Where("rule_id = ?", ruleID).Order("revision").Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+// FetchRulesAsOf retrieves the results rules of a relationship as they stood at a point in time,
+// from the latest revision of each rule recorded at or before that time. Rules that did not exist
+// yet, were already deleted or belonged to another relationship at that time are left out.
+// Relationships have no history, so the current relationship is attached to the rules.
+//
+// Parameters:
+// - dbOps: The database operations interface used for database interactions.
+// - relationID: The relation ID to filter by.
+// - asOf: The point in time.
+//
+// Returns:
+// - []*tables.ResultsRule: The results rules as they stood at that time.
+// - error: An error if any database operation fails.
+func FetchRulesAsOf(dbOps db.DatabaseOperations, relationID string, asOf time.Time) ([]*tables.ResultsRule, error) {
+	var revisions []tables.ResultsRuleRevision
+	err := dbOps.Connection().Raw(`
+		SELECT * FROM (
+			SELECT DISTINCT ON (rule_id) * FROM results_rule_revisions
+			WHERE created_at <= ?
+			ORDER BY rule_id, revision DESC
+		) latest
+		WHERE relationship_id = ? AND action <> ?`, asOf, relationID, tables.RuleDeleted).Scan(&revisions).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return []*tables.ResultsRule{}, nil
+	}
+
+	var relationship tables.Relationship
+	if err := dbOps.First(&relationship, "id = ?", relationID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return []*tables.ResultsRule{}, nil
+		}
+		return nil, err
+	}
+
+	rules := make([]*tables.ResultsRule, 0, len(revisions))
+	for _, revision := range revisions {
+		rule := revision.Rule()
+		rule.Relationship = relationship
+		rules = append(rules, &rule)
+	}
+	return rules, nil
+}
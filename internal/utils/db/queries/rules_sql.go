@@ -0,0 +1,204 @@
+package queries
+
+import (
+	"fmt"
+	"hypha/api/internal/db/tables"
+	"hypha/api/internal/utils/rules"
+	"strings"
+)
+
+// Aliases of the tables in the queries that evaluate results rules.
+const (
+	resultAlias    = "r"
+	suiteAlias     = "ts"
+	caseAlias      = "tc"
+	suiteCaseAlias = "c" // Test cases of a suite, for predicates on case fields of suites
+)
+
+// ruleCondition translates the expression of a results rule into a SQL condition over the test
+// suites (aliased ts) or test cases (aliased tc, joined to their suite as ts) the rule selects.
+// The condition holds for exactly the suites or cases rules.Expression.Matches selects. Globs and
+// the wildcard patterns of legacy rules become LIKE patterns and regular expressions use the "~" operator of PostgreSQL with the pattern
+// translated from RE2 by the rules package.
+//
+// Parameters:
+// - expression: The compiled expression of the rule.
+// - target: rules.TargetSuite or rules.TargetCase.
+//
+// Returns:
+// - string: The SQL condition, or an empty string if the expression selects everything.
+// - []interface{}: The values of the placeholders of the condition.
+func ruleCondition(expression *rules.Expression, target string) (string, []interface{}) {
+	if expression.Root == nil {
+		return "", nil
+	}
+	builder := &conditionBuilder{target: target}
+	return builder.node(expression.Root), builder.args
+}
+
+// conditionBuilder accumulates a SQL condition and the values of its placeholders, in order.
+type conditionBuilder struct {
+	target string
+	args   []interface{}
+}
+
+// node translates a node of an expression.
+func (builder *conditionBuilder) node(node rules.Node) string {
+	switch n := node.(type) {
+	case *rules.And:
+		return "(" + builder.node(n.Left) + " AND " + builder.node(n.Right) + ")"
+	case *rules.Or:
+		return "(" + builder.node(n.Left) + " OR " + builder.node(n.Right) + ")"
+	case *rules.Not:
+		return "NOT " + builder.node(n.Operand)
+	case *rules.Predicate:
+		if builder.target == rules.TargetSuite && n.IsCaseField() {
+			// Predicates on case fields hold for a suite if they hold for any of its cases
+			return fmt.Sprintf("EXISTS (SELECT 1 FROM test_cases %s WHERE %s.test_suite_id = %s.id::text AND %s)",
+				suiteCaseAlias, suiteCaseAlias, suiteAlias, builder.predicate(n, suiteCaseAlias))
+		}
+		alias := caseAlias
+		if builder.target == rules.TargetSuite {
+			alias = suiteAlias
+		}
+		return builder.predicate(n, alias)
+	}
+	return "FALSE"
+}
+
+// predicate translates a predicate evaluated against the suite or case with the given alias.
+func (builder *conditionBuilder) predicate(predicate *rules.Predicate, alias string) string {
+	switch predicate.Field {
+	case rules.FieldDuration:
+		builder.args = append(builder.args, predicate.Seconds)
+		return fmt.Sprintf("COALESCE(%s.time, 0) %s ?", alias, predicate.Operator)
+	case rules.FieldProperty:
+		// A negated property predicate holds when no value of the property matches
+		owner := "test_case_id"
+		if alias == suiteAlias {
+			owner = "test_suite_id"
+		}
+		builder.args = append(builder.args, predicate.Property)
+		exists := fmt.Sprintf("EXISTS (SELECT 1 FROM properties p WHERE p.%s = %s.id::text AND p.name = ? AND %s)",
+			owner, alias, builder.match("p.value", predicate, false))
+		if predicate.Negated() {
+			return "NOT " + exists
+		}
+		return exists
+	}
+	return builder.match(predicateColumn(predicate.Field, alias), predicate, predicate.Negated())
+}
+
+// match translates the glob or regular expression of a predicate matched against a column.
+func (builder *conditionBuilder) match(column string, predicate *rules.Predicate, negated bool) string {
+	if predicate.Operator == rules.OpMatch || predicate.Operator == rules.OpNotMatch {
+		builder.args = append(builder.args, predicate.PostgresPattern())
+		if negated {
+			return column + " !~ ?"
+		}
+		return column + " ~ ?"
+	}
+	if predicate.Wildcard {
+		builder.args = append(builder.args, wildcardLikePattern(predicate.Value))
+	} else {
+		builder.args = append(builder.args, likePattern(predicate.Value))
+	}
+	if negated {
+		return column + ` NOT LIKE ? ESCAPE '\'`
+	}
+	return column + ` LIKE ? ESCAPE '\'`
+}
+
+// predicateColumn returns the column compared by a predicate on a text field. Missing values
+// compare as empty strings, as they do when expressions are evaluated in memory.
+func predicateColumn(field rules.Field, alias string) string {
+	column := alias + ".name"
+	switch field {
+	case rules.FieldSuite:
+		column = suiteAlias + ".name"
+	case rules.FieldClass:
+		column = alias + ".class_name"
+	case rules.FieldStatus:
+		column = alias + ".status"
+	case rules.FieldFile:
+		column = alias + ".file"
+	}
+	return "COALESCE(" + column + ", '')"
+}
+
+// runFiltersCondition translates the run filters of a results rule into a SQL condition over the
+// results they keep (aliased r). Patterns are globs anchored at both ends, negated by a leading "!".
+//
+// Parameters:
+// - filters: The run filters of a results rule, checked when the rule was created.
+//
+// Returns:
+// - string: The SQL condition, or an empty string if there are no filters.
+// - []interface{}: The values of the placeholders of the condition.
+func runFiltersCondition(filters []string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, filter := range filters {
+		name, pattern, _ := strings.Cut(filter, "=")
+		// Fields that are not run metadata have no value
+		column := "''"
+		if columnName, known := tables.RunMetadataColumns[name]; known {
+			column = "COALESCE(" + resultAlias + "." + columnName + ", '')"
+		}
+		operator := "LIKE"
+		if strings.HasPrefix(pattern, "!") {
+			operator = "NOT LIKE"
+		}
+		conditions = append(conditions, fmt.Sprintf(`%s %s ? ESCAPE '\'`, column, operator))
+		args = append(args, likePattern(strings.TrimPrefix(pattern, "!")))
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// likePattern translates an anchored glob into a LIKE pattern escaped with "\".
+// "*" matches any sequence of characters, "?" a single character and "\" escapes the next character.
+func likePattern(glob string) string {
+	var pattern strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			pattern.WriteByte('%')
+			continue
+		case '?':
+			pattern.WriteByte('_')
+			continue
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				c = glob[i]
+			}
+		}
+		if c == '%' || c == '_' || c == '\\' {
+			pattern.WriteByte('\\')
+		}
+		pattern.WriteByte(c)
+	}
+	return pattern.String()
+}
+
+// wildcardLikePattern translates the wildcard pattern of a legacy rule into a LIKE pattern escaped
+// with "\". The parts separated by "*" may appear anywhere in the value, in order, and every other
+// character stands for itself.
+func wildcardLikePattern(wildcard string) string {
+	var pattern strings.Builder
+	pattern.WriteByte('%')
+	for i := 0; i < len(wildcard); i++ {
+		c := wildcard[i]
+		switch c {
+		case '*':
+			pattern.WriteByte('%')
+			continue
+		case '%', '_', '\\':
+			pattern.WriteByte('\\')
+		}
+		pattern.WriteByte(c)
+	}
+	pattern.WriteByte('%')
+	return pattern.String()
+}
@@ -0,0 +1,408 @@
+package queries
+
+import (
+	"hypha/api/internal/db"
+	"hypha/api/internal/db/tables"
+	"hypha/api/internal/utils/rules"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-orm/gorm"
+)
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		glob string
+		want string
+	}{
+		{"Payments*", "Payments%"},
+		{"Refunds?v2", "Refunds_v2"},
+		{"100%", `100\%`},
+		{"a_b", `a\_b`},
+		{`a\*b`, "a*b"},
+		{`a\?b`, "a?b"},
+		{`a\\b`, `a\\b`},
+		{`C:\`, `C:\\`},
+		{`\%`, `\%`},
+	}
+	for _, test := range tests {
+		if got := likePattern(test.glob); got != test.want {
+			t.Errorf("likePattern(%q) = %q, want %q", test.glob, got, test.want)
+		}
+	}
+}
+
+func TestWildcardLikePattern(t *testing.T) {
+	tests := []struct {
+		wildcard string
+		want     string
+	}{
+		{"", "%%"},
+		{"Pay", "%Pay%"},
+		{"Pay*Refund", "%Pay%Refund%"},
+		{"Refunds?v2", "%Refunds?v2%"},
+		{"100%", `%100\%%`},
+		{"a_b", `%a\_b%`},
+		{`a\*b`, `%a\\%b%`},
+	}
+	for _, test := range tests {
+		if got := wildcardLikePattern(test.wildcard); got != test.want {
+			t.Errorf("wildcardLikePattern(%q) = %q, want %q", test.wildcard, got, test.want)
+		}
+	}
+}
+
+// baselineMatchesWildcard is how rules were matched before the expression language: the parts of
+// the pattern separated by "*" are found in the value in order, and a leading "!" negates the match.
+func baselineMatchesWildcard(value, expression string) bool {
+	negated := strings.HasPrefix(expression, "!")
+	for _, part := range strings.Split(strings.TrimPrefix(expression, "!"), "*") {
+		index := strings.Index(value, part)
+		if index == -1 {
+			return negated
+		}
+		value = value[index+len(part):]
+	}
+	return !negated
+}
+
+// likeMatches evaluates a LIKE pattern escaped with "\" in memory, as PostgreSQL does.
+func likeMatches(value, like string) bool {
+	var pattern strings.Builder
+	pattern.WriteString(`(?s)^`)
+	for i := 0; i < len(like); i++ {
+		switch c := like[i]; c {
+		case '%':
+			pattern.WriteString(".*")
+		case '_':
+			pattern.WriteString(".")
+		case '\\':
+			i++
+			pattern.WriteString(regexp.QuoteMeta(like[i : i+1]))
+		default:
+			pattern.WriteString(regexp.QuoteMeta(like[i : i+1]))
+		}
+	}
+	pattern.WriteString(`$`)
+	return regexp.MustCompile(pattern.String()).MatchString(value)
+}
+
+// TestLegacyRuleConditionMatchesWildcard checks without a database that the SQL condition of legacy
+// rules and their evaluation in memory select the same names as rules did before the expression
+// language.
+func TestLegacyRuleConditionMatchesWildcard(t *testing.T) {
+	expressions := []string{"", "*", "Pay", "!Pay", "Pay*Refund", "!*Slow*", "Refunds?v2", "100%", "a_b",
+		`C:\`, `Pay\*`, "Payments and Refunds", "not flaky", "**", "a*a"}
+	names := []string{"", "Pay", "Payments", "xPayx", "Payments and Refunds", "Refunds and Payments", "Checkout Slow path",
+		"RefundsXv2", "Refunds?v2", "100% done", "1000", "a_b", "axb", `C:\tmp`, `Pay\ments`, "Pay*", "not flaky", "a", "aa"}
+
+	for _, source := range expressions {
+		rule := &tables.ResultsRule{Expression: source, LegacyGlob: true}
+		expression := compileRuleExpression(rule)
+		for _, target := range []string{rules.TargetSuite, rules.TargetCase} {
+			condition, args := ruleCondition(expression, target)
+			column := "COALESCE(tc.name, '')"
+			if target == rules.TargetSuite {
+				column = "COALESCE(ts.name, '')"
+			}
+			negated := strings.HasPrefix(condition, column+" NOT LIKE")
+			if !negated && !strings.HasPrefix(condition, column+" LIKE") || len(args) != 1 {
+				t.Fatalf("%s %q: unexpected condition %s %v", target, source, condition, args)
+			}
+
+			for _, name := range names {
+				subject := rules.Subject{Target: target, Suite: name, Case: name}
+				want := baselineMatchesWildcard(name, source)
+				if got := likeMatches(name, args[0].(string)) != negated; got != want {
+					t.Errorf("%s %q: SQL condition %s %q matches %q = %v, want %v", target, source, condition, args[0], name, got, want)
+				}
+				if got := expression.Matches(subject); got != want {
+					t.Errorf("%s %q: evaluator matches %q = %v, want %v", target, source, name, got, want)
+				}
+			}
+		}
+	}
+}
+
+// ruleFixture is a test suite or test case stored for TestRuleConditionMatchesEvaluator.
+// Nil fields are stored as NULL and evaluated in memory as empty strings or zero.
+type ruleFixture struct {
+	name, className, status, file *string
+	time                          *float64
+	properties                    map[string][]string
+	cases                         []ruleFixture
+}
+
+// ruleFixtureRun is a result of the fixtures, with the branch and environment of its run.
+type ruleFixtureRun struct {
+	branch, environment *string
+	suites              []ruleFixture
+}
+
+func text(value string) *string      { return &value }
+func seconds(value float64) *float64 { return &value }
+
+func valueOf(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+var ruleFixtureRuns = []ruleFixtureRun{
+	{
+		branch: text("main"), environment: text("prod"),
+		suites: []ruleFixture{
+			{
+				name: text("Payments 100%"), file: text("pay/test_a.py"), time: seconds(1.5),
+				properties: map[string][]string{"browser": {"chrome", "firefox"}},
+				cases: []ruleFixture{
+					{className: text("com.example.api.PayTest"), name: text("refund_ok"), status: text("pass"), file: text("a_b.py"), time: seconds(0.2),
+						properties: map[string][]string{"tag": {"slow"}}},
+					{className: text("com.example.web.PayTest"), name: text(`refund\timeout`), status: text("fail"), time: seconds(2.5)},
+					{name: text("kelvin \u212A")},
+				},
+			},
+			{
+				name: text("Refunds_v2"),
+				cases: []ruleFixture{
+					{className: text("com.other"), name: text("line1\nline2"), status: text("skipped"), time: seconds(0)},
+					{className: text("a_b"), name: text("É ok"), status: text("flaky"), file: text("x%y"), time: seconds(3)},
+				},
+			},
+		},
+	},
+	{
+		environment: text("release/1.0"),
+		suites: []ruleFixture{
+			{
+				file: text(`pay\x`), time: seconds(0.5),
+				properties: map[string][]string{"browser": {"safari"}, "empty": {""}},
+			},
+			{
+				name: text("RefundsXv2"), time: seconds(1),
+				cases: []ruleFixture{
+					{className: text("com.example.api.Other"), name: text("100% done"), status: text("error"), time: seconds(1)},
+				},
+			},
+		},
+	},
+}
+
+var ruleExpressions = []string{
+	``,
+	`Payments*`,
+	`!*v2`,
+	`name = "*100%"`,
+	`name = "*100\%*"`,
+	`name = "Refunds?v2"`,
+	`name = "Refunds\_v2"`,
+	`name = ""`,
+	`name != "*"`,
+	`case = "*\\*"`,
+	`suite = "Pay*"`,
+	`suite != "Pay*"`,
+	`class = "com.example.*"`,
+	`class = "a_b"`,
+	`class = ""`,
+	`class ~ "^com\.example\.(api|web)\."`,
+	`class !~ "example"`,
+	`case ~ "(?i)^REFUND"`,
+	`case ~ "\btimeout\b"`,
+	`case ~ "\Btimeout"`,
+	`case ~ "^line2$"`,
+	`case ~ "(?m)^line2$"`,
+	`case ~ "line1.line2"`,
+	`case ~ "(?s)line1.line2"`,
+	`case ~ "(?i)kelvin k$"`,
+	`case ~ "\d"`,
+	`case ~ "^\pL ok"`,
+	`case ~ "[^a-z_ ]"`,
+	`case ~ "o{2}|%"`,
+	`case !~ ""`,
+	`status = fail`,
+	`status != pass`,
+	`status = "*"`,
+	`status != "?*"`,
+	`file = "*_*"`,
+	`file = "*\%*"`,
+	`file = "pay\\*"`,
+	`file = ""`,
+	`file != "*"`,
+	`duration > 1`,
+	`duration >= 1.5`,
+	`duration < 1s`,
+	`duration <= 0`,
+	`duration = 0`,
+	`duration != 0`,
+	`duration > 2000ms`,
+	`property.browser = chrome`,
+	`property.browser != chrome`,
+	`property.browser ~ "fox$"`,
+	`property.browser !~ "^s"`,
+	`property.tag = slow`,
+	`property.tag != slow`,
+	`property.empty = ""`,
+	`property.missing != "*"`,
+	`property."browser" = "*i*"`,
+	`suite = "Pay*" and not (status = skipped or duration > 2s)`,
+	`not name = "*"`,
+	`(class ~ "api" or file = "*_*") and !(property.browser = safari)`,
+	`status = error or property.browser = safari`,
+}
+
+var ruleRunFilters = [][]string{
+	nil,
+	{"branch=main"},
+	{"branch=!main"},
+	{"branch=*"},
+	{"branch=?*"},
+	{"environment=release/*"},
+	{"environment=!prod", "branch=!?*"},
+	{"unknown=*"},
+	{"unknown=x"},
+}
+
+// TestRuleConditionMatchesEvaluator checks that the SQL translation of results rules selects the
+// same suites and cases as their evaluation in memory. It runs against the PostgreSQL database
+// named by HYPHA_TEST_DATABASE, a connection string such as
+// "host=localhost user=hypha password=hypha dbname=hypha_test sslmode=disable", inside a
+// transaction that is rolled back.
+func TestRuleConditionMatchesEvaluator(t *testing.T) {
+	dsn := os.Getenv("HYPHA_TEST_DATABASE")
+	if dsn == "" {
+		t.Skip("HYPHA_TEST_DATABASE is not set")
+	}
+	conn, err := gorm.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close()
+	if err := db.AutoMigrate(conn); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	tx := conn.Begin()
+	defer tx.Rollback()
+
+	productID := db.GenerateUniqueID()
+	var suites, cases []evaluatedSubject
+	for _, run := range ruleFixtureRuns {
+		suites, cases = insertRuleFixtures(t, tx, productID, run, suites, cases)
+	}
+
+	for _, filters := range ruleRunFilters {
+		for _, source := range ruleExpressions {
+			expression, err := rules.Compile(source)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", source, err)
+			}
+			rule := &tables.ResultsRule{ObjectID: productID, RunFilters: filters}
+			for _, target := range []string{rules.TargetSuite, rules.TargetCase} {
+				subjects, column := suites, "ts.id"
+				if target == rules.TargetCase {
+					subjects, column = cases, "tc.id"
+				}
+
+				var want []string
+				for _, subject := range subjects {
+					if matchesRunFilters(subject.run, filters) && expression.Matches(subject.subject) {
+						want = append(want, subject.id)
+					}
+				}
+				var got []string
+				if err := ruleQuery(tx, rule, expression, target).Pluck(column+"::text", &got).Error; err != nil {
+					t.Fatalf("%s %q with filters %v: %v", target, source, filters, err)
+				}
+				sort.Strings(want)
+				sort.Strings(got)
+				if len(got) != len(want) || (len(got) > 0 && !reflect.DeepEqual(got, want)) {
+					t.Errorf("%s %q with filters %v: SQL selects %v, evaluator %v", target, source, filters, got, want)
+				}
+			}
+		}
+	}
+}
+
+// evaluatedSubject is a stored suite or case with its run and its in-memory subject.
+type evaluatedSubject struct {
+	id      string
+	run     ruleFixtureRun
+	subject rules.Subject
+}
+
+// insertRuleFixtures stores a result with its suites and cases and appends their subjects.
+func insertRuleFixtures(t *testing.T, tx *gorm.DB, productID string, run ruleFixtureRun, suites, cases []evaluatedSubject) ([]evaluatedSubject, []evaluatedSubject) {
+	t.Helper()
+	exec := func(query string, args ...interface{}) {
+		if err := tx.Exec(query, args...).Error; err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+	}
+
+	resultID := db.GenerateUniqueID()
+	exec("INSERT INTO results (id, product_id, branch, environment) VALUES (?, ?, ?, ?)", resultID, productID, run.branch, run.environment)
+	for _, suite := range run.suites {
+		suiteID := db.GenerateUniqueID()
+		exec("INSERT INTO test_suites (id, result_id, name, file, time) VALUES (?, ?, ?, ?, ?)", suiteID, resultID, suite.name, suite.file, suite.time)
+		insertRuleProperties(exec, "test_suite_id", suiteID, suite.properties)
+		suiteSubject := rules.Subject{
+			Target:     rules.TargetSuite,
+			Suite:      valueOf(suite.name),
+			File:       valueOf(suite.file),
+			Properties: suite.properties,
+		}
+		if suite.time != nil {
+			suiteSubject.Duration = *suite.time
+		}
+
+		for _, testCase := range suite.cases {
+			caseID := db.GenerateUniqueID()
+			exec("INSERT INTO test_cases (id, test_suite_id, class_name, name, status, file, time) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				caseID, suiteID, testCase.className, testCase.name, testCase.status, testCase.file, testCase.time)
+			insertRuleProperties(exec, "test_case_id", caseID, testCase.properties)
+			caseSubject := rules.Subject{
+				Target:     rules.TargetCase,
+				Suite:      valueOf(suite.name),
+				Class:      valueOf(testCase.className),
+				Case:       valueOf(testCase.name),
+				Status:     valueOf(testCase.status),
+				File:       valueOf(testCase.file),
+				Properties: testCase.properties,
+			}
+			if testCase.time != nil {
+				caseSubject.Duration = *testCase.time
+			}
+			suiteSubject.Cases = append(suiteSubject.Cases, caseSubject)
+			cases = append(cases, evaluatedSubject{id: caseID, run: run, subject: caseSubject})
+		}
+		suites = append(suites, evaluatedSubject{id: suiteID, run: run, subject: suiteSubject})
+	}
+	return suites, cases
+}
+
+// insertRuleProperties stores the properties of a suite or case.
+func insertRuleProperties(exec func(string, ...interface{}), owner, ownerID string, properties map[string][]string) {
+	for name, values := range properties {
+		for _, value := range values {
+			exec("INSERT INTO properties (id, "+owner+", name, value) VALUES (?, ?, ?, ?)", db.GenerateUniqueID(), ownerID, name, value)
+		}
+	}
+}
+
+// matchesRunFilters evaluates run filters in memory: every filter must match the value of its
+// run metadata field, which is empty when the field is unknown or missing.
+func matchesRunFilters(run ruleFixtureRun, filters []string) bool {
+	values := map[string]string{"branch": valueOf(run.branch), "environment": valueOf(run.environment)}
+	for _, filter := range filters {
+		name, pattern, _ := strings.Cut(filter, "=")
+		negated := strings.HasPrefix(pattern, "!")
+		if rules.MatchGlob(values[name], strings.TrimPrefix(pattern, "!")) == negated {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package results
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// sniffLength is the number of bytes read ahead to recognize compressed files and archives.
+const sniffLength = 512
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is the offset of the "ustar" magic within a tar header.
+const tarMagicOffset = 257
+
+// walkReports calls fn for every report contained in an uploaded file.
+// Gzip-compressed files, zip archives and tar archives (optionally gzip-compressed) are expanded
+// one level deep; any other file is passed to fn as is. Reports found in an archive are named
+// after the upload followed by their path within the archive.
+//
+// Parameters:
+// - name: The file name of the upload.
+// - file: The content of the upload.
+// - size: The size of the upload in bytes.
+// - maxSize: The maximum size of a single report after decompression, or 0 for no limit.
+// - fn: The function called for every report.
+//
+// Returns:
+// - error: A *ParseError if the archive cannot be read, or the first error returned by fn.
+func walkReports(name string, file io.ReaderAt, size int64, maxSize int64, fn func(name string, reader io.Reader) error) error {
+	reader := bufio.NewReaderSize(io.NewSectionReader(file, 0, size), sniffLength)
+	header, _ := reader.Peek(sniffLength)
+
+	switch {
+	case bytes.HasPrefix(header, zipMagic):
+		return walkZip(name, file, size, maxSize, fn)
+	case bytes.HasPrefix(header, gzipMagic):
+		decompressed, err := gzip.NewReader(reader)
+		if err != nil {
+			return &ParseError{Err: err}
+		}
+		defer decompressed.Close()
+
+		inner := bufio.NewReaderSize(decompressed, sniffLength)
+		innerHeader, _ := inner.Peek(sniffLength)
+		if isTar(innerHeader) {
+			return walkTar(name, inner, maxSize, fn)
+		}
+		innerName := strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".gzip")
+		return fn(innerName, limitReportSize(inner, maxSize))
+	case isTar(header):
+		return walkTar(name, reader, maxSize, fn)
+	default:
+		return fn(name, limitReportSize(reader, maxSize))
+	}
+}
+
+// isTar checks if the given header starts a tar archive.
+func isTar(header []byte) bool {
+	return len(header) >= tarMagicOffset+len(tarMagic) &&
+		bytes.Equal(header[tarMagicOffset:tarMagicOffset+len(tarMagic)], tarMagic)
+}
+
+// walkZip calls fn for every regular file of a zip archive.
+func walkZip(name string, file io.ReaderAt, size int64, maxSize int64, fn func(name string, reader io.Reader) error) error {
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return &ParseError{Err: err}
+	}
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() || skipArchiveEntry(entry.Name) {
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			return &ParseError{Err: fmt.Errorf("%s: %w", entry.Name, err)}
+		}
+		err = fn(path.Join(name, entry.Name), limitReportSize(content, maxSize))
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTar calls fn for every regular file of a tar archive.
+func walkTar(name string, reader io.Reader, maxSize int64, fn func(name string, reader io.Reader) error) error {
+	archive := tar.NewReader(reader)
+	for {
+		entry, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &ParseError{Err: err}
+		}
+		if entry.Typeflag != tar.TypeReg || skipArchiveEntry(entry.Name) {
+			continue
+		}
+		if err := fn(path.Join(name, entry.Name), limitReportSize(archive, maxSize)); err != nil {
+			return err
+		}
+	}
+}
+
+// skipArchiveEntry checks if an archive entry is metadata added by the archiving tool rather than a report.
+func skipArchiveEntry(name string) bool {
+	return strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), "._")
+}
+
+// errReportTooLarge is returned when reading a report beyond the maximum report size.
+var errReportTooLarge = errors.New("report exceeds the maximum size")
+
+// limitedReport is a reader that fails once more than max bytes have been read.
+type limitedReport struct {
+	reader io.Reader
+	max    int64
+	read   int64
+}
+
+// limitReportSize wraps reader so that reading more than maxSize bytes fails instead of
+// silently truncating the report. A maxSize of 0 disables the limit.
+func limitReportSize(reader io.Reader, maxSize int64) io.Reader {
+	if maxSize <= 0 {
+		return reader
+	}
+	return &limitedReport{reader: reader, max: maxSize}
+}
+
+// Read reads from the underlying reader and fails once the limit is exceeded.
+func (report *limitedReport) Read(buffer []byte) (int, error) {
+	n, err := report.reader.Read(buffer)
+	report.read += int64(n)
+	if report.read > report.max {
+		return n, fmt.Errorf("%w of %d bytes", errReportTooLarge, report.max)
+	}
+	return n, err
+}
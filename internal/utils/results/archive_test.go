@@ -0,0 +1,174 @@
+package results
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// archiveEntry is a file to be written into an archive built by a test.
+type archiveEntry struct {
+	name    string
+	content string
+}
+
+// zipArchive returns a zip archive of the given entries.
+func zipArchive(t *testing.T, entries ...archiveEntry) string {
+	t.Helper()
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for _, entry := range entries {
+		file, err := archive.Create(entry.name)
+		if err != nil {
+			t.Fatalf("zip %s: %v", entry.name, err)
+		}
+		if _, err := file.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("zip %s: %v", entry.name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return buffer.String()
+}
+
+// tarGzipArchive returns a gzip-compressed tar archive of the given entries.
+func tarGzipArchive(t *testing.T, entries ...archiveEntry) string {
+	t.Helper()
+	var buffer bytes.Buffer
+	compressed := gzip.NewWriter(&buffer)
+	archive := tar.NewWriter(compressed)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644, Size: int64(len(entry.content)), Typeflag: tar.TypeReg}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatalf("tar %s: %v", entry.name, err)
+		}
+		if _, err := archive.Write([]byte(entry.content)); err != nil {
+			t.Fatalf("tar %s: %v", entry.name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("tar: %v", err)
+	}
+	if err := compressed.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return buffer.String()
+}
+
+// gzipFile returns content compressed with gzip.
+func gzipFile(t *testing.T, content string) string {
+	t.Helper()
+	compressed, err := gzipOutput([]byte(content))
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return string(compressed)
+}
+
+const (
+	cartReport     = `<testsuite name="Cart"><testcase name="adds an item"/></testsuite>`
+	paymentsReport = `<testsuite name="Payments"><testcase name="charges the card"><failure message="declined"/></testcase></testsuite>`
+	tapReport      = "TAP version 13\n1..1\nok 1 - loads the page\n"
+)
+
+func TestAddFileExpandsArchives(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		content     func(t *testing.T) string
+		wantReports string
+		wantCases   string
+	}{
+		{
+			name: "gzip",
+			file: "junit.xml.gz",
+			content: func(t *testing.T) string {
+				return gzipFile(t, cartReport)
+			},
+			wantReports: "junit.xml=junit",
+			wantCases:   "adds an item=pass",
+		},
+		{
+			name: "zip",
+			file: "results.zip",
+			content: func(t *testing.T) string {
+				return zipArchive(t,
+					archiveEntry{"reports/", ""},
+					archiveEntry{"reports/cart.xml", cartReport},
+					archiveEntry{"__MACOSX/reports/._cart.xml", "\x00\x05\x16\x07"},
+					archiveEntry{"reports/._payments.xml", "\x00\x05\x16\x07"},
+					archiveEntry{"reports/payments.xml", paymentsReport},
+				)
+			},
+			wantReports: "results.zip/reports/cart.xml=junit,results.zip/reports/payments.xml=junit",
+			wantCases:   "adds an item=pass,charges the card=fail",
+		},
+		{
+			name: "tar.gz",
+			file: "results.tar.gz",
+			content: func(t *testing.T) string {
+				return tarGzipArchive(t,
+					archiveEntry{"cart.xml", cartReport},
+					archiveEntry{"__MACOSX/._cart.xml", "\x00\x05\x16\x07"},
+					archiveEntry{"page.tap", tapReport},
+				)
+			},
+			wantReports: "results.tar.gz/cart.xml=junit,results.tar.gz/page.tap=tap",
+			wantCases:   "adds an item=pass,loads the page=pass",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, ingestion := readReport(t, "", test.file, test.content(t))
+			var reports []string
+			for _, report := range ingestion.files {
+				if report.Error != "" {
+					t.Errorf("report %s: %s", report.Name, report.Error)
+				}
+				reports = append(reports, report.Name+"="+report.Format)
+			}
+			if got := strings.Join(reports, ","); got != test.wantReports {
+				t.Errorf("reports = %s, want %s", got, test.wantReports)
+			}
+			if got := strings.Join(store.caseStatuses(), ","); got != test.wantCases {
+				t.Errorf("test cases = %s, want %s", got, test.wantCases)
+			}
+		})
+	}
+}
+
+func TestAddFileRejectsReportsOverMaximumSize(t *testing.T) {
+	upload := Upload{ProductID: "product", MaxReportSize: int64(len(cartReport))}
+	archive := zipArchive(t, archiveEntry{"cart.xml", cartReport}, archiveEntry{"payments.xml", paymentsReport})
+	store, ingestion := readUpload(t, upload, "results.zip", archive)
+
+	if len(ingestion.files) != 2 {
+		t.Fatalf("files = %+v, want two reports", ingestion.files)
+	}
+	if ingestion.files[0].Error != "" {
+		t.Errorf("cart.xml: %s, want it read as it fits the maximum size", ingestion.files[0].Error)
+	}
+	wantError := fmt.Sprintf("report exceeds the maximum size of %d bytes", len(cartReport))
+	if payments := ingestion.files[1]; payments.Name != "results.zip/payments.xml" || !strings.Contains(payments.Error, wantError) {
+		t.Errorf("payments.xml = %+v, want the error %q", payments, wantError)
+	}
+	if got := strings.Join(store.caseStatuses(), ","); got != "adds an item=pass" {
+		t.Errorf("test cases = %s, want only those of the report within the maximum size", got)
+	}
+	if ingestion.ingested != 1 {
+		t.Errorf("ingested = %d, want 1", ingestion.ingested)
+	}
+}
+
+func TestAddFileWithoutReports(t *testing.T) {
+	archive := zipArchive(t, archiveEntry{"__MACOSX/._cart.xml", "\x00\x05\x16\x07"})
+	_, ingestion := readReport(t, "", "results.zip", archive)
+	if len(ingestion.files) != 1 || ingestion.files[0].Name != "results.zip" || ingestion.files[0].Error != "no reports found in file" {
+		t.Errorf("files = %+v, want the archive recorded without reports", ingestion.files)
+	}
+}
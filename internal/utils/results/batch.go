@@ -0,0 +1,122 @@
+package results
+
+import (
+	"hypha/api/internal/db"
+	"hypha/api/internal/db/tables"
+)
+
+// resultBatch holds models produced from an upload that have not been written to the database yet.
+type resultBatch struct {
+	results     []tables.Result
+	testSuites  []tables.TestSuite
+	testCases   []tables.TestCase
+	failures    []tables.TestCaseFailure
+	reruns      []tables.TestCaseRerun
+	steps       []tables.TestCaseStep
+	attachments []tables.Attachment
+	properties  []tables.Property
+	outputs     []tables.Output // Logs read in pieces, which are referenced by ID already
+}
+
+// size returns the number of models held in the batch.
+func (batch *resultBatch) size() int {
+	return len(batch.results) + len(batch.testSuites) + len(batch.testCases) +
+		len(batch.failures) + len(batch.reruns) + len(batch.steps) + len(batch.attachments) + len(batch.properties) +
+		len(batch.outputs)
+}
+
+// addOutput adds a log read in pieces to the batch.
+//
+// Parameters:
+// - output: The Output model holding the log, or nil for an empty log.
+//
+// Returns:
+// - *string: The ID of the Output model, or nil for an empty log.
+func (batch *resultBatch) addOutput(output *tables.Output) *string {
+	if output == nil {
+		return nil
+	}
+	batch.outputs = append(batch.outputs, *output)
+	return &output.ID
+}
+
+// insert writes all models in the batch using multi-row inserts.
+// The system-out and system-err logs of the test suites, test cases and reruns are first moved into
+// Output models, which are only inserted if no identical log is stored yet.
+// It is meant to be called inside a transaction so a failed upload leaves nothing behind.
+//
+// Parameters:
+// - tx: The DatabaseOperations interface bound to the upload's transaction.
+// - options: The options for storing the logs.
+//
+// Returns:
+// - error: An error if any of the inserts fails.
+func (batch *resultBatch) insert(tx db.DatabaseOperations, options OutputOptions) error {
+	outputs, err := batch.collectOutputs(options)
+	if err != nil {
+		return err
+	}
+	if err := tx.CreateMissingInBatches(outputs, db.DefaultBatchSize); err != nil {
+		return err
+	}
+	if err := tx.CreateInBatches(batch.results, db.DefaultBatchSize); err != nil {
+		return err
+	}
+	if err := tx.CreateInBatches(batch.testSuites, db.DefaultBatchSize); err != nil {
+		return err
+	}
+	if err := tx.CreateInBatches(batch.testCases, db.DefaultBatchSize); err != nil {
+		return err
+	}
+	if err := tx.CreateInBatches(batch.failures, db.DefaultBatchSize); err != nil {
+		return err
+	}
+	if err := tx.CreateInBatches(batch.reruns, db.DefaultBatchSize); err != nil {
+		return err
+	}
+	if err := tx.CreateInBatches(batch.steps, db.DefaultBatchSize); err != nil {
+		return err
+	}
+	if err := tx.CreateInBatches(batch.attachments, db.DefaultBatchSize); err != nil {
+		return err
+	}
+	return tx.CreateInBatches(batch.properties, db.DefaultBatchSize)
+}
+
+// collectOutputs replaces the logs of the test suites, test cases and reruns in the batch by
+// references to Output models. Models whose logs were read in pieces reference their Output already.
+//
+// Parameters:
+// - options: The options for storing the logs.
+//
+// Returns:
+// - []tables.Output: The Output models holding the distinct logs of the batch.
+// - error: An error if a log cannot be compressed.
+func (batch *resultBatch) collectOutputs(options OutputOptions) ([]tables.Output, error) {
+	set := newOutputSet(options)
+	for _, output := range batch.outputs {
+		set.addOutput(output)
+	}
+	var err error
+	reference := func(id **string, content string) {
+		if err == nil && content != "" {
+			*id, err = set.add(content)
+		}
+	}
+	for i := range batch.testSuites {
+		suite := &batch.testSuites[i]
+		reference(&suite.SystemOutID, suite.SystemOut)
+		reference(&suite.SystemErrID, suite.SystemErr)
+	}
+	for i := range batch.testCases {
+		testCase := &batch.testCases[i]
+		reference(&testCase.SystemOutID, testCase.SystemOut)
+		reference(&testCase.SystemErrID, testCase.SystemErr)
+	}
+	for i := range batch.reruns {
+		rerun := &batch.reruns[i]
+		reference(&rerun.SystemOutID, rerun.SystemOut)
+		reference(&rerun.SystemErrID, rerun.SystemErr)
+	}
+	return set.outputs, err
+}
@@ -0,0 +1,464 @@
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hypha/api/internal/db/tables"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CTRFReport represents a report in the Common Test Report Format (https://ctrf.io).
+type CTRFReport struct {
+	ReportFormat string      `json:"reportFormat"`
+	SpecVersion  string      `json:"specVersion"`
+	Results      CTRFResults `json:"results"`
+}
+
+// CTRFResults represents the results object of a CTRF report.
+type CTRFResults struct {
+	Tool        CTRFTool               `json:"tool"`
+	Summary     CTRFSummary            `json:"summary"`
+	Tests       []CTRFTest             `json:"tests"`
+	Environment *CTRFEnvironment       `json:"environment,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+}
+
+// CTRFTool represents the tool that produced a CTRF report.
+type CTRFTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// CTRFSummary represents the summary of a CTRF report. Start and stop are Unix times in milliseconds.
+type CTRFSummary struct {
+	Tests   int   `json:"tests"`
+	Passed  int   `json:"passed"`
+	Failed  int   `json:"failed"`
+	Pending int   `json:"pending"`
+	Skipped int   `json:"skipped"`
+	Other   int   `json:"other"`
+	Start   int64 `json:"start"`
+	Stop    int64 `json:"stop"`
+}
+
+// CTRFEnvironment represents the environment a CTRF report was produced in.
+type CTRFEnvironment struct {
+	AppName         string `json:"appName,omitempty"`
+	BuildName       string `json:"buildName,omitempty"`
+	BuildNumber     string `json:"buildNumber,omitempty"`
+	BuildURL        string `json:"buildUrl,omitempty"`
+	BranchName      string `json:"branchName,omitempty"`
+	Commit          string `json:"commit,omitempty"`
+	TestEnvironment string `json:"testEnvironment,omitempty"`
+}
+
+// CTRFTest represents a single test of a CTRF report. Durations are in milliseconds.
+type CTRFTest struct {
+	Name      string                 `json:"name"`
+	Status    string                 `json:"status"` // passed, failed, skipped, pending or other
+	Duration  float64                `json:"duration"`
+	Start     int64                  `json:"start,omitempty"`
+	Stop      int64                  `json:"stop,omitempty"`
+	Suite     CTRFSuite              `json:"suite,omitempty"`
+	Message   string                 `json:"message,omitempty"`
+	Trace     string                 `json:"trace,omitempty"`
+	Line      int                    `json:"line,omitempty"`
+	RawStatus string                 `json:"rawStatus,omitempty"`
+	Tags      []string               `json:"tags,omitempty"`
+	Type      string                 `json:"type,omitempty"`
+	FilePath  string                 `json:"filePath,omitempty"`
+	Retries   int                    `json:"retries,omitempty"`
+	Flaky     bool                   `json:"flaky,omitempty"`
+	Stdout    []string               `json:"stdout,omitempty"`
+	Stderr    []string               `json:"stderr,omitempty"`
+	Steps     []CTRFStep             `json:"steps,omitempty"`
+	Extra     map[string]interface{} `json:"extra,omitempty"`
+}
+
+// CTRFStep represents a step of a CTRF test.
+type CTRFStep struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// CTRFSuite is the suite of a CTRF test. Older versions of the specification use a single
+// string, newer ones the list of nested suite names; both are accepted and joined with " > ".
+type CTRFSuite string
+
+// UnmarshalJSON decodes a suite given as a string or as a list of strings.
+func (suite *CTRFSuite) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err == nil {
+		*suite = CTRFSuite(strings.Join(names, " > "))
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	*suite = CTRFSuite(name)
+	return nil
+}
+
+// AddCTRF decodes a CTRF JSON report from reader and adds it to the ingestion.
+//
+// Tests are grouped into one test suite per CTRF suite; tests without a suite are grouped under the
+// name of the tool. Flaky tests are stored as flaky test cases with one flaky failure per retry, and
+// failed tests that were retried get one rerun failure per retry. Tags become properties named "tag"
+// and the entries of extra become properties named after their keys, with non-string values stored
+// as JSON. The CTRF environment fills in run metadata the upload did not set.
+//
+// The tests array is decoded one test at a time, so only the suites are kept in memory.
+//
+// Parameters:
+// - reader: The reader providing the CTRF report.
+//
+// Returns:
+// - error: A *ParseError if the report is not a CTRF report, or an error if saving the models fails.
+func (ingestion *Ingestion) AddCTRF(reader io.Reader) error {
+	decoder := json.NewDecoder(reader)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return &ParseError{Err: err}
+	}
+
+	groups := newSuiteGroups(ingestion)
+	var tool CTRFTool
+	var summary CTRFSummary
+	sawResults := false
+
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return &ParseError{Err: err}
+		}
+		if key != "results" {
+			if err := skipJSONValue(decoder); err != nil {
+				return &ParseError{Err: err}
+			}
+			continue
+		}
+
+		sawResults = true
+		if err := expectDelim(decoder, '{'); err != nil {
+			return &ParseError{Err: err}
+		}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return &ParseError{Err: err}
+			}
+			switch key {
+			case "tool":
+				err = decoder.Decode(&tool)
+			case "summary":
+				err = decoder.Decode(&summary)
+			case "environment":
+				var environment CTRFEnvironment
+				if err = decoder.Decode(&environment); err == nil {
+					applyCTRFEnvironment(&ingestion.result.RunMetadata, environment)
+				}
+			case "tests":
+				// Tests without a suite are grouped under the tool name, or under a suite that is
+				// named once the report ends if the tool follows the tests.
+				if err := ingestion.addCTRFTests(decoder, groups, tool.Name); err != nil {
+					return err
+				}
+			default:
+				err = skipJSONValue(decoder)
+			}
+			if err != nil {
+				return &ParseError{Err: err}
+			}
+		}
+		if _, err := decoder.Token(); err != nil {
+			return &ParseError{Err: err}
+		}
+	}
+	if !sawResults {
+		return &ParseError{Err: errors.New("CTRF report has no results")}
+	}
+
+	groups.rename("", tool.Name)
+	root := JUnitTestSuites{Name: tool.Name}
+	if summary.Start > 0 {
+		root.Timestamp = time.UnixMilli(summary.Start).UTC().Format(time.RFC3339Nano)
+	}
+	groups.close(&root)
+	addReportTotals(&ingestion.result, root)
+	return ingestion.flushIfFull()
+}
+
+// addCTRFTests decodes the tests array of a CTRF report one test at a time and adds every test
+// to the suite it belongs to. Tests without a suite are added to the suite named toolName, the
+// name of the tool if it has been read already.
+func (ingestion *Ingestion) addCTRFTests(decoder *json.Decoder, groups *suiteGroups, toolName string) error {
+	if err := expectDelim(decoder, '['); err != nil {
+		return &ParseError{Err: err}
+	}
+	for decoder.More() {
+		var test CTRFTest
+		if err := decoder.Decode(&test); err != nil {
+			return &ParseError{Err: err}
+		}
+		suite := string(test.Suite)
+		if suite == "" {
+			suite = toolName
+		}
+		if err := groups.add(suite, test.FilePath, createCTRFTestCase(test)); err != nil {
+			return err
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return &ParseError{Err: err}
+	}
+	return nil
+}
+
+// createCTRFTestCase converts a CTRF test into a JUnitTestCase.
+//
+// Parameters:
+// - test: The CTRF test.
+//
+// Returns:
+// - JUnitTestCase: The test case.
+func createCTRFTestCase(test CTRFTest) JUnitTestCase {
+	testCase := JUnitTestCase{
+		ClassName: string(test.Suite),
+		Name:      test.Name,
+		Time:      test.Duration / 1000,
+		File:      test.FilePath,
+		Line:      test.Line,
+		SystemOut: strings.Join(test.Stdout, "\n"),
+		SystemErr: strings.Join(test.Stderr, "\n"),
+	}
+
+	retries := make([]Rerun, test.Retries)
+	switch test.Status {
+	case "passed":
+		if test.Flaky && len(retries) == 0 {
+			retries = make([]Rerun, 1)
+		}
+		if test.Flaky {
+			testCase.FlakyFailures = retries
+		}
+	case "failed":
+		testCase.Failures = []Failure{{Message: test.Message, Type: test.RawStatus, Text: test.Trace}}
+		testCase.RerunFailures = retries
+	case "skipped", "pending":
+		message := test.Message
+		if message == "" {
+			message = test.Status
+		}
+		testCase.Skipped = &Skipped{Message: message}
+	default:
+		errorType := test.RawStatus
+		if errorType == "" {
+			errorType = test.Status
+		}
+		testCase.Errors = []Error{{Message: test.Message, Type: errorType, Text: test.Trace}}
+	}
+
+	for _, tag := range test.Tags {
+		testCase.Properties = append(testCase.Properties, Property{Name: "tag", Value: tag})
+	}
+	keys := make([]string, 0, len(test.Extra))
+	for key := range test.Extra {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		testCase.Properties = append(testCase.Properties, Property{Name: key, Value: ctrfExtraValue(test.Extra[key])})
+	}
+
+	for _, step := range test.Steps {
+		testCase.Steps = append(testCase.Steps, Step{Text: step.Name, Status: step.Status})
+	}
+	return testCase
+}
+
+// ctrfExtraValue converts a value of a CTRF extra object into a property value.
+// Strings are kept as they are, other values are encoded as JSON.
+func ctrfExtraValue(value interface{}) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// applyCTRFEnvironment fills in run metadata fields that are empty from a CTRF environment.
+func applyCTRFEnvironment(metadata *tables.RunMetadata, environment CTRFEnvironment) {
+	for name, value := range map[string]string{
+		"commit":      environment.Commit,
+		"branch":      environment.BranchName,
+		"pipeline":    environment.BuildName,
+		"buildID":     environment.BuildNumber,
+		"buildURL":    environment.BuildURL,
+		"environment": environment.TestEnvironment,
+	} {
+		if current, _ := metadata.Value(name); current == "" && value != "" {
+			metadata.Set(name, value)
+		}
+	}
+}
+
+// expectDelim reads the next token of decoder and checks that it is the given delimiter.
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if found, ok := token.(json.Delim); !ok || found != delim {
+		return fmt.Errorf("expected %q, found %v", delim, token)
+	}
+	return nil
+}
+
+// skipJSONValue reads and discards the next value of decoder.
+func skipJSONValue(decoder *json.Decoder) error {
+	var value json.RawMessage
+	return decoder.Decode(&value)
+}
+
+// ExportCTRF renders a stored result, including its test suites and test cases, as a CTRF report.
+//
+// Test case statuses are mapped to CTRF statuses: errors are reported as failed tests with the
+// raw status "error", and flaky tests as passed tests marked flaky. Properties named "tag" become
+// tags and other properties entries of extra. Nested suites are rendered as the path of suite names.
+//
+// Parameters:
+// - result: The result to export, with its test suites, test cases, failures, reruns, steps and properties loaded.
+//
+// Returns:
+// - CTRFReport: The CTRF report of the result.
+func ExportCTRF(result tables.Result) CTRFReport {
+	report := CTRFReport{
+		ReportFormat: "CTRF",
+		SpecVersion:  "0.0.0",
+		Results: CTRFResults{
+			Tool:  CTRFTool{Name: "hypha"},
+			Tests: []CTRFTest{},
+			Extra: map[string]interface{}{
+				"resultId":     result.ID,
+				"productId":    result.ProductID,
+				"name":         result.Name,
+				"dateReported": result.DateReported,
+			},
+		},
+	}
+
+	start := result.DateReported
+	if result.ExecutedAt != nil {
+		start = *result.ExecutedAt
+	}
+	report.Results.Summary.Start = start.UnixMilli()
+	report.Results.Summary.Stop = start.Add(time.Duration(result.Time * float64(time.Second))).UnixMilli()
+
+	metadata := result.RunMetadata
+	if metadata != (tables.RunMetadata{}) {
+		report.Results.Environment = &CTRFEnvironment{
+			BuildName:       metadata.Pipeline,
+			BuildNumber:     metadata.BuildID,
+			BuildURL:        metadata.BuildURL,
+			BranchName:      metadata.Branch,
+			Commit:          metadata.Commit,
+			TestEnvironment: metadata.Environment,
+		}
+	}
+
+	suiteNames := make(map[string]string, len(result.TestSuites))
+	parents := make(map[string]*string, len(result.TestSuites))
+	for _, suite := range result.TestSuites {
+		suiteNames[suite.ID] = suite.Name
+		parents[suite.ID] = suite.ParentID
+	}
+
+	for _, suite := range result.TestSuites {
+		path := []string{suite.Name}
+		for parent := suite.ParentID; parent != nil && len(path) <= len(result.TestSuites); parent = parents[*parent] {
+			path = append([]string{suiteNames[*parent]}, path...)
+		}
+		for _, testCase := range suite.TestCases {
+			test := createCTRFTest(testCase, strings.Join(path, " > "))
+			report.Results.Tests = append(report.Results.Tests, test)
+			summary := &report.Results.Summary
+			summary.Tests++
+			switch test.Status {
+			case "passed":
+				summary.Passed++
+			case "failed":
+				summary.Failed++
+			case "skipped":
+				summary.Skipped++
+			default:
+				summary.Other++
+			}
+		}
+	}
+	return report
+}
+
+// createCTRFTest converts a stored test case into a CTRF test.
+func createCTRFTest(testCase tables.TestCase, suite string) CTRFTest {
+	test := CTRFTest{
+		Name:     testCase.Name,
+		Duration: testCase.Time * 1000,
+		Suite:    CTRFSuite(suite),
+		FilePath: testCase.File,
+		Line:     testCase.Line,
+		Retries:  len(testCase.Reruns),
+	}
+	if testCase.Message != nil {
+		test.Message = *testCase.Message
+	}
+	if len(testCase.Failures) > 0 {
+		test.Trace = testCase.Failures[0].Body
+	}
+	if testCase.SystemOut != "" {
+		test.Stdout = []string{testCase.SystemOut}
+	}
+	if testCase.SystemErr != "" {
+		test.Stderr = []string{testCase.SystemErr}
+	}
+
+	switch testCase.Status {
+	case "pass":
+		test.Status = "passed"
+	case "flaky":
+		test.Status = "passed"
+		test.Flaky = true
+	case "fail":
+		test.Status = "failed"
+	case "error":
+		test.Status = "failed"
+		test.RawStatus = "error"
+	case "skipped":
+		test.Status = "skipped"
+	default:
+		test.Status = "other"
+		test.RawStatus = testCase.Status
+	}
+
+	for _, property := range testCase.Properties {
+		if property.Name == "tag" {
+			test.Tags = append(test.Tags, property.Value)
+			continue
+		}
+		if test.Extra == nil {
+			test.Extra = map[string]interface{}{}
+		}
+		test.Extra[property.Name] = property.Value
+	}
+	for _, step := range testCase.Steps {
+		name := strings.TrimSpace(step.Keyword + " " + step.Text)
+		test.Steps = append(test.Steps, CTRFStep{Name: name, Status: step.Status})
+	}
+	return test
+}
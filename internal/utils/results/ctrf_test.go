@@ -0,0 +1,161 @@
+package results
+
+import (
+	"hypha/api/internal/db/tables"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ctrfReport is a CTRF report listing its tests before the tool that ran them.
+const ctrfReport = `{
+  "reportFormat": "CTRF",
+  "specVersion": "0.0.0",
+  "results": {
+    "tests": [
+      {"name": "adds an item", "status": "passed", "duration": 250, "suite": ["checkout", "cart"], "filePath": "cart.spec.ts", "line": 4,
+       "tags": ["@smoke"], "extra": {"owner": "team-a", "browser": {"name": "chromium"}}, "stdout": ["added", "1 item"]},
+      {"name": "computes the total", "status": "failed", "duration": 10, "suite": ["checkout", "cart"], "message": "expected 0", "trace": "at cart.spec.ts:20",
+       "retries": 1},
+      {"name": "charges the card", "status": "passed", "suite": "payments", "flaky": true, "retries": 2},
+      {"name": "refunds", "status": "skipped", "suite": "payments"},
+      {"name": "pays later", "status": "pending", "suite": "payments", "message": "not implemented"},
+      {"name": "pays in store", "status": "other", "rawStatus": "interrupted", "suite": "payments"},
+      {"name": "loads the page", "status": "passed", "steps": [{"name": "open", "status": "passed"}]}
+    ],
+    "tool": {"name": "playwright", "version": "1.44.0"},
+    "summary": {"tests": 7, "passed": 3, "failed": 1, "pending": 1, "skipped": 1, "other": 1, "start": 1714557600000, "stop": 1714557605000},
+    "environment": {"branchName": "feature/cart", "commit": "abc123", "buildNumber": "42"}
+  }
+}`
+
+func TestAddCTRF(t *testing.T) {
+	upload := Upload{ProductID: "product", Metadata: tables.RunMetadata{Branch: "main"}}
+	store, ingestion := readUpload(t, upload, "ctrf-report.json", ctrfReport)
+	report := onlyReport(t, ingestion)
+	if report.Format != FormatCTRF {
+		t.Errorf("format = %q, want %q", report.Format, FormatCTRF)
+	}
+
+	wantSuites := "checkout > cart,payments,playwright"
+	if got := strings.Join(store.suiteNames(), ","); got != wantSuites {
+		t.Errorf("suites = %s, want %s with tests without a suite named after the tool", got, wantSuites)
+	}
+	wantCases := "adds an item=pass,computes the total=fail,charges the card=flaky,refunds=skipped,pays later=skipped," +
+		"pays in store=error,loads the page=pass"
+	if got := strings.Join(store.caseStatuses(), ","); got != wantCases {
+		t.Errorf("test cases = %s, want %s", got, wantCases)
+	}
+
+	add := store.testCase(t, "adds an item")
+	if add.ClassName != "checkout > cart" || add.Time != 0.25 || add.File != "cart.spec.ts" || add.Line != 4 ||
+		store.output(t, add.SystemOutID) != "added\n1 item" {
+		t.Errorf("adds an item = %+v, want the suite path as class, time 0.25, its file, line and output", add)
+	}
+	var properties []string
+	for _, property := range store.properties {
+		if property.TestCaseID != nil && *property.TestCaseID == add.ID {
+			properties = append(properties, property.Name+"="+property.Value)
+		}
+	}
+	if got := strings.Join(properties, ","); got != `tag=@smoke,browser={"name":"chromium"},owner=team-a` {
+		t.Errorf("properties of adds an item = %s, want its tag and sorted extra values", got)
+	}
+
+	total := store.testCase(t, "computes the total")
+	if failures := store.failuresOf(total.ID); len(failures) != 1 || failures[0].Message != "expected 0" || failures[0].Body != "at cart.spec.ts:20" {
+		t.Errorf("failures of computes the total = %+v, want the message and trace", failures)
+	}
+	reruns := map[string]int{}
+	for _, rerun := range store.reruns {
+		reruns[rerun.TestCaseID]++
+	}
+	if card := store.testCase(t, "charges the card"); reruns[card.ID] != 2 || reruns[total.ID] != 1 {
+		t.Errorf("reruns = %v, want 2 for charges the card and 1 for computes the total", reruns)
+	}
+	if later := store.testCase(t, "pays later"); later.Message == nil || *later.Message != "not implemented" {
+		t.Errorf("pays later message = %v, want the pending message", later.Message)
+	}
+	if inStore := store.testCase(t, "pays in store"); inStore.Type == nil || *inStore.Type != "interrupted" {
+		t.Errorf("pays in store type = %v, want the raw status", inStore.Type)
+	}
+	if page := store.testCase(t, "loads the page"); countSteps(store, page.ID) != 1 {
+		t.Errorf("loads the page has %d steps, want 1", countSteps(store, page.ID))
+	}
+
+	metadata := ingestion.result.RunMetadata
+	if metadata.Branch != "main" || metadata.Commit != "abc123" || metadata.BuildID != "42" {
+		t.Errorf("metadata = %+v, want the uploaded branch and the commit and build of the report", metadata)
+	}
+	if ingestion.result.Name != "playwright" {
+		t.Errorf("result name = %q, want the tool name", ingestion.result.Name)
+	}
+	want := time.UnixMilli(1714557600000).UTC()
+	if executedAt := ingestion.result.ExecutedAt; executedAt == nil || !executedAt.Equal(want) {
+		t.Errorf("executed at = %v, want %v", executedAt, want)
+	}
+}
+
+func TestAddCTRFRequiresResults(t *testing.T) {
+	_, ingestion := readReport(t, FormatCTRF, "report.json", `{"reportFormat": "CTRF"}`)
+	if len(ingestion.files) != 1 || !strings.Contains(ingestion.files[0].Error, "CTRF report has no results") {
+		t.Errorf("files = %+v, want an error for a report without results", ingestion.files)
+	}
+}
+
+func TestExportCTRF(t *testing.T) {
+	message := "expected 0"
+	checkoutID := "checkout"
+	executedAt := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	result := tables.Result{
+		ID:          "result",
+		Time:        2.5,
+		ExecutedAt:  &executedAt,
+		RunMetadata: tables.RunMetadata{Branch: "main", Commit: "abc123"},
+		TestSuites: []tables.TestSuite{
+			{ID: checkoutID, Name: "checkout"},
+			{ID: "cart", Name: "cart", ParentID: &checkoutID, TestCases: []tables.TestCase{
+				{Name: "adds an item", Status: "pass", Time: 0.25, SystemOut: "added",
+					Properties: []tables.Property{{Name: "tag", Value: "@smoke"}, {Name: "owner", Value: "team-a"}},
+					Steps:      []tables.TestCaseStep{{Keyword: "When", Text: "the customer adds an item", Status: "passed"}}},
+				{Name: "computes the total", Status: "fail", Message: &message,
+					Failures: []tables.TestCaseFailure{{Body: "at cart.spec.ts:20"}}},
+				{Name: "charges the card", Status: "flaky", Reruns: []tables.TestCaseRerun{{}, {}}},
+				{Name: "refunds", Status: "skipped"},
+				{Name: "pays in store", Status: "error"},
+			}},
+		},
+	}
+
+	report := ExportCTRF(result)
+	tests := report.Results.Tests
+	var statuses []string
+	for _, test := range tests {
+		statuses = append(statuses, test.Name+"="+test.Status+"/"+test.RawStatus)
+	}
+	want := "adds an item=passed/,computes the total=failed/,charges the card=passed/,refunds=skipped/,pays in store=failed/error"
+	if got := strings.Join(statuses, ","); got != want {
+		t.Errorf("tests = %s, want %s", got, want)
+	}
+	if tests[0].Suite != "checkout > cart" || tests[0].Duration != 250 || tests[0].Tags[0] != "@smoke" ||
+		tests[0].Extra["owner"] != "team-a" || tests[0].Stdout[0] != "added" || tests[0].Steps[0].Name != "When the customer adds an item" {
+		t.Errorf("adds an item = %+v, want its suite path, duration in milliseconds, tag, extra, output and step", tests[0])
+	}
+	if tests[1].Message != "expected 0" || tests[1].Trace != "at cart.spec.ts:20" {
+		t.Errorf("computes the total = %+v, want its message and trace", tests[1])
+	}
+	if !tests[2].Flaky || tests[2].Retries != 2 {
+		t.Errorf("charges the card = %+v, want flaky with 2 retries", tests[2])
+	}
+
+	summary := report.Results.Summary
+	if summary.Tests != 5 || summary.Passed != 2 || summary.Failed != 2 || summary.Skipped != 1 {
+		t.Errorf("summary = %+v, want 5 tests, 2 passed, 2 failed and 1 skipped", summary)
+	}
+	if summary.Start != executedAt.UnixMilli() || summary.Stop != executedAt.Add(2500*time.Millisecond).UnixMilli() {
+		t.Errorf("summary start and stop = %d, %d, want the execution time and 2.5s later", summary.Start, summary.Stop)
+	}
+	if environment := report.Results.Environment; environment == nil || environment.BranchName != "main" || environment.Commit != "abc123" {
+		t.Errorf("environment = %+v, want the run metadata", environment)
+	}
+}
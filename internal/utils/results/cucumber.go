@@ -0,0 +1,245 @@
+package results
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CucumberFeature represents a feature of a Cucumber JSON report.
+type CucumberFeature struct {
+	URI         string            `json:"uri"`
+	ID          string            `json:"id"`
+	Keyword     string            `json:"keyword"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Line        int               `json:"line"`
+	Tags        []CucumberTag     `json:"tags"`
+	Elements    []CucumberElement `json:"elements"`
+}
+
+// CucumberElement represents a scenario or background of a Cucumber feature.
+type CucumberElement struct {
+	ID      string         `json:"id"`
+	Keyword string         `json:"keyword"`
+	Name    string         `json:"name"`
+	Line    int            `json:"line"`
+	Type    string         `json:"type"` // scenario or background
+	Tags    []CucumberTag  `json:"tags"`
+	Before  []CucumberStep `json:"before"`
+	Steps   []CucumberStep `json:"steps"`
+	After   []CucumberStep `json:"after"`
+}
+
+// CucumberStep represents a step or hook of a Cucumber scenario.
+type CucumberStep struct {
+	Keyword string         `json:"keyword"`
+	Name    string         `json:"name"`
+	Line    int            `json:"line"`
+	Result  CucumberResult `json:"result"`
+	Match   struct {
+		Location string `json:"location"`
+	} `json:"match"`
+	Output []string `json:"output"`
+}
+
+// CucumberResult represents the result of a Cucumber step or hook.
+type CucumberResult struct {
+	Status       string  `json:"status"`
+	Duration     float64 `json:"duration"` // In nanoseconds
+	ErrorMessage string  `json:"error_message"`
+}
+
+// CucumberTag represents a tag of a Cucumber feature or scenario.
+type CucumberTag struct {
+	Name string `json:"name"`
+	Line int    `json:"line"`
+}
+
+// AddCucumber decodes a Cucumber JSON report from reader and adds it to the ingestion.
+//
+// Every feature becomes a test suite and every scenario a test case of that suite, with its steps
+// and hooks stored as TestCaseSteps. Background steps are added in front of the steps of the
+// scenarios that follow them, after their Before hooks. Tags become properties named "tag". Features are decoded one at a
+// time, so only a single feature is kept in memory.
+//
+// Parameters:
+// - reader: The reader providing the Cucumber JSON report.
+//
+// Returns:
+// - error: A *ParseError if the report is not a Cucumber JSON report, or an error if saving the models fails.
+func (ingestion *Ingestion) AddCucumber(reader io.Reader) error {
+	decoder := json.NewDecoder(reader)
+	token, err := decoder.Token()
+	if err != nil {
+		return &ParseError{Err: err}
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return &ParseError{Err: errors.New("cucumber report is not a JSON array of features")}
+	}
+
+	var root JUnitTestSuites
+	for decoder.More() {
+		var feature CucumberFeature
+		if err := decoder.Decode(&feature); err != nil {
+			return &ParseError{Err: err}
+		}
+
+		suite := createCucumberSuite(feature)
+		if err := ingestion.addTestSuite(suite, nil); err != nil {
+			return err
+		}
+		suite.TestCases = nil
+		root.TestSuites = append(root.TestSuites, suite)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return &ParseError{Err: err}
+	}
+
+	addReportTotals(&ingestion.result, root)
+	return ingestion.flushIfFull()
+}
+
+// createCucumberSuite converts a Cucumber feature into a JUnitTestSuite with one test case per scenario.
+func createCucumberSuite(feature CucumberFeature) JUnitTestSuite {
+	suite := JUnitTestSuite{
+		ID:         feature.ID,
+		Name:       feature.Name,
+		File:       feature.URI,
+		Properties: cucumberTagProperties(feature.Tags),
+	}
+
+	var background []Step
+	for _, element := range feature.Elements {
+		if element.Type == "background" {
+			background = createCucumberSteps(element)
+			continue
+		}
+		testCase := createCucumberTestCase(feature, element, background)
+		countTestCase(&suite, testCase)
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	return suite
+}
+
+// createCucumberTestCase converts a Cucumber scenario into a JUnitTestCase.
+//
+// The status of the scenario is derived from its steps: a failed step or hook fails the scenario,
+// undefined or ambiguous steps make it an error, and a scenario whose steps are pending or were all
+// skipped is skipped. The error message of the first failed step becomes the failure.
+//
+// Parameters:
+// - feature: The feature containing the scenario.
+// - element: The scenario.
+// - background: The steps of the background preceding the scenario, if any.
+//
+// Returns:
+// - JUnitTestCase: The test case of the scenario.
+func createCucumberTestCase(feature CucumberFeature, element CucumberElement, background []Step) JUnitTestCase {
+	// Before hooks run ahead of the background, so the background steps go right after them.
+	scenarioSteps := createCucumberSteps(element)
+	steps := make([]Step, 0, len(background)+len(scenarioSteps))
+	steps = append(steps, scenarioSteps[:len(element.Before)]...)
+	steps = append(steps, background...)
+	steps = append(steps, scenarioSteps[len(element.Before):]...)
+	testCase := JUnitTestCase{
+		ID:         element.ID,
+		ClassName:  feature.Name,
+		Name:       element.Name,
+		File:       feature.URI,
+		Line:       element.Line,
+		Properties: cucumberTagProperties(element.Tags),
+		Steps:      steps,
+	}
+
+	var output []string
+	skipped, gherkinSteps := 0, 0
+	for _, step := range steps {
+		testCase.Time += step.Duration
+	}
+	for _, group := range [][]CucumberStep{element.Before, element.Steps, element.After} {
+		for _, step := range group {
+			output = append(output, step.Output...)
+		}
+	}
+	testCase.SystemOut = strings.Join(output, "\n")
+
+	for _, step := range steps {
+		if step.Keyword != "Before" && step.Keyword != "After" {
+			gherkinSteps++
+		}
+		description := strings.TrimSpace(step.Keyword + " " + step.Text)
+		switch step.Status {
+		case "failed":
+			if len(testCase.Failures) == 0 && len(testCase.Errors) == 0 {
+				testCase.Failures = []Failure{{Message: firstLineOf(step.Error), Type: description, Text: step.Error}}
+			}
+		case "undefined", "ambiguous":
+			if len(testCase.Failures) == 0 && len(testCase.Errors) == 0 {
+				message := fmt.Sprintf("%s step: %s", step.Status, description)
+				testCase.Errors = []Error{{Message: message, Type: step.Status, Text: step.Error}}
+			}
+		case "pending":
+			if testCase.Skipped == nil {
+				testCase.Skipped = &Skipped{Message: "Pending step: " + description}
+			}
+		case "skipped":
+			if step.Keyword != "Before" && step.Keyword != "After" {
+				skipped++
+			}
+		}
+	}
+	if len(testCase.Failures) > 0 || len(testCase.Errors) > 0 {
+		testCase.Skipped = nil
+	} else if testCase.Skipped == nil && gherkinSteps > 0 && skipped == gherkinSteps {
+		testCase.Skipped = &Skipped{Message: "All steps were skipped"}
+	}
+	return testCase
+}
+
+// createCucumberSteps converts the hooks and steps of a Cucumber scenario or background into Steps,
+// in execution order.
+func createCucumberSteps(element CucumberElement) []Step {
+	steps := make([]Step, 0, len(element.Before)+len(element.Steps)+len(element.After))
+	for _, hook := range element.Before {
+		steps = append(steps, createCucumberStep("Before", hook.Match.Location, hook))
+	}
+	for _, step := range element.Steps {
+		steps = append(steps, createCucumberStep(strings.TrimSpace(step.Keyword), step.Name, step))
+	}
+	for _, hook := range element.After {
+		steps = append(steps, createCucumberStep("After", hook.Match.Location, hook))
+	}
+	return steps
+}
+
+// createCucumberStep converts a Cucumber step or hook into a Step.
+func createCucumberStep(keyword string, text string, step CucumberStep) Step {
+	return Step{
+		Keyword:  keyword,
+		Text:     text,
+		Line:     step.Line,
+		Status:   step.Result.Status,
+		Duration: step.Result.Duration / 1e9,
+		Error:    step.Result.ErrorMessage,
+	}
+}
+
+// cucumberTagProperties converts Cucumber tags into properties named "tag".
+func cucumberTagProperties(tags []CucumberTag) []Property {
+	properties := make([]Property, 0, len(tags))
+	for _, tag := range tags {
+		properties = append(properties, Property{Name: "tag", Value: tag.Name})
+	}
+	return properties
+}
+
+// firstLineOf returns the first line of a text, which is the message of most Cucumber step errors.
+func firstLineOf(text string) string {
+	if end := strings.IndexByte(text, '\n'); end >= 0 {
+		return strings.TrimSpace(text[:end])
+	}
+	return strings.TrimSpace(text)
+}
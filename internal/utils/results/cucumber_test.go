@@ -0,0 +1,148 @@
+package results
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// cucumberCheckout is a Cucumber JSON report of a feature with a background and scenarios that pass,
+// fail, use an undefined step and skip all steps. As in reports written by Cucumber, the background
+// is repeated before every scenario with the results of its steps for that scenario. Durations are
+// in nanoseconds.
+const cucumberCheckout = `[
+  {
+    "uri": "features/checkout.feature", "id": "checkout", "keyword": "Feature", "name": "Checkout", "line": 2,
+    "tags": [{"name": "@payments", "line": 1}],
+    "elements": [
+      {
+        "keyword": "Background", "name": "", "line": 4, "type": "background",
+        "steps": [{"keyword": "Given ", "name": "a signed in customer", "line": 5, "result": {"status": "passed", "duration": 100000000}}]
+      },
+      {
+        "id": "checkout;pays-by-card", "keyword": "Scenario", "name": "Pays by card", "line": 7, "type": "scenario",
+        "tags": [{"name": "@smoke", "line": 6}],
+        "before": [{"match": {"location": "hooks.rb:3"}, "result": {"status": "passed", "duration": 50000000}, "output": ["seeded the catalog"]}],
+        "steps": [
+          {"keyword": "When ", "name": "the customer pays by card", "line": 8, "result": {"status": "passed", "duration": 200000000}},
+          {"keyword": "Then ", "name": "the order is confirmed", "line": 9, "result": {"status": "passed", "duration": 150000000}, "output": ["order 42"]}
+        ],
+        "after": [{"match": {"location": "hooks.rb:9"}, "result": {"status": "passed", "duration": 0}}]
+      },
+      {
+        "keyword": "Background", "name": "", "line": 4, "type": "background",
+        "steps": [{"keyword": "Given ", "name": "a signed in customer", "line": 5, "result": {"status": "passed", "duration": 100000000}}]
+      },
+      {
+        "id": "checkout;pays-by-voucher", "keyword": "Scenario", "name": "Pays by voucher", "line": 11, "type": "scenario",
+        "steps": [
+          {"keyword": "When ", "name": "the customer pays by voucher", "line": 12, "result": {"status": "failed", "duration": 1000000, "error_message": "expected 0 to be 1\n./steps.rb:20"}},
+          {"keyword": "Then ", "name": "the order is confirmed", "line": 13, "result": {"status": "skipped"}}
+        ]
+      },
+      {
+        "keyword": "Background", "name": "", "line": 4, "type": "background",
+        "steps": [{"keyword": "Given ", "name": "a signed in customer", "line": 5, "result": {"status": "passed", "duration": 100000000}}]
+      },
+      {
+        "id": "checkout;pays-later", "keyword": "Scenario", "name": "Pays later", "line": 15, "type": "scenario",
+        "steps": [{"keyword": "When ", "name": "the customer pays later", "line": 16, "result": {"status": "undefined"}}]
+      },
+      {
+        "keyword": "Background", "name": "", "line": 4, "type": "background",
+        "steps": [{"keyword": "Given ", "name": "a signed in customer", "line": 5, "result": {"status": "skipped"}}]
+      },
+      {
+        "id": "checkout;pays-in-store", "keyword": "Scenario", "name": "Pays in store", "line": 18, "type": "scenario",
+        "before": [{"match": {"location": "hooks.rb:3"}, "result": {"status": "passed"}}],
+        "steps": [{"keyword": "When ", "name": "the customer pays in store", "line": 19, "result": {"status": "skipped"}}]
+      }
+    ]
+  }
+]`
+
+func TestAddCucumber(t *testing.T) {
+	store, ingestion := readReport(t, "", "cucumber.json", cucumberCheckout)
+	report := onlyReport(t, ingestion)
+	if report.Format != FormatCucumber {
+		t.Errorf("format = %q, want %q", report.Format, FormatCucumber)
+	}
+
+	suite := store.suite(t, "Checkout")
+	if suite.File != "features/checkout.feature" || suite.Tests != 4 || suite.Failures != 1 || suite.Errors != 1 || suite.Skipped != 1 {
+		t.Errorf("suite = %+v, want file features/checkout.feature, 4 tests, 1 failure, 1 error and 1 skipped", suite)
+	}
+	wantCases := "Pays by card=pass,Pays by voucher=fail,Pays later=error,Pays in store=skipped"
+	if got := strings.Join(store.caseStatuses(), ","); got != wantCases {
+		t.Errorf("test cases = %s, want %s", got, wantCases)
+	}
+
+	card := store.testCase(t, "Pays by card")
+	var steps []string
+	for _, step := range store.steps {
+		if step.TestCaseID == card.ID {
+			steps = append(steps, fmt.Sprintf("%d %s %s %s", step.Position, step.Keyword, step.Text, step.Status))
+		}
+	}
+	wantSteps := []string{
+		"0 Before hooks.rb:3 passed",
+		"1 Given a signed in customer passed",
+		"2 When the customer pays by card passed",
+		"3 Then the order is confirmed passed",
+		"4 After hooks.rb:9 passed",
+	}
+	if strings.Join(steps, "\n") != strings.Join(wantSteps, "\n") {
+		t.Errorf("steps of Pays by card =\n%s\nwant the Before hook, then the background, then the scenario steps:\n%s",
+			strings.Join(steps, "\n"), strings.Join(wantSteps, "\n"))
+	}
+	if card.ClassName != "Checkout" || card.Line != 7 || card.Time != 0.5 {
+		t.Errorf("Pays by card = %+v, want class Checkout, line 7 and time 0.5", card)
+	}
+	if got := store.output(t, card.SystemOutID); got != "seeded the catalog\norder 42" {
+		t.Errorf("output of Pays by card = %q, want the hook and step output", got)
+	}
+
+	var tags []string
+	for _, property := range store.properties {
+		if property.Name == "tag" {
+			tags = append(tags, property.Value)
+		}
+	}
+	if got := strings.Join(tags, ","); got != "@payments,@smoke" {
+		t.Errorf("tags = %s, want the feature and scenario tags", got)
+	}
+
+	voucher := store.testCase(t, "Pays by voucher")
+	failures := store.failuresOf(voucher.ID)
+	if len(failures) != 1 || failures[0].Message != "expected 0 to be 1" || failures[0].Type != "When the customer pays by voucher" ||
+		failures[0].Body != "expected 0 to be 1\n./steps.rb:20" {
+		t.Errorf("failures of Pays by voucher = %+v, want the first line of the error, the step and the full error", failures)
+	}
+	if later := store.testCase(t, "Pays later"); later.Message == nil || *later.Message != "undefined step: When the customer pays later" {
+		t.Errorf("Pays later message = %v, want the undefined step", later.Message)
+	}
+	if inStore := store.testCase(t, "Pays in store"); inStore.Message == nil || *inStore.Message != "All steps were skipped" {
+		t.Errorf("Pays in store message = %v, want all steps skipped although its Before hook passed", inStore.Message)
+	}
+	if voucherSteps := countSteps(store, voucher.ID); voucherSteps != 3 {
+		t.Errorf("Pays by voucher has %d steps, want its own background step and 2 steps", voucherSteps)
+	}
+}
+
+func TestAddCucumberRejectsObjects(t *testing.T) {
+	_, ingestion := readReport(t, FormatCucumber, "cucumber.json", `{"features": []}`)
+	if len(ingestion.files) != 1 || !strings.Contains(ingestion.files[0].Error, "not a JSON array of features") {
+		t.Errorf("files = %+v, want an error for a JSON object", ingestion.files)
+	}
+}
+
+// countSteps returns the number of steps stored for a test case.
+func countSteps(store *recordingDB, testCaseID string) int {
+	count := 0
+	for _, step := range store.steps {
+		if step.TestCaseID == testCaseID {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,206 @@
+package results
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+)
+
+// Report formats that can be ingested.
+const (
+	FormatJUnit    = "junit"
+	FormatGoTest   = "gotest"   // Event stream written by go test -json
+	FormatTRX      = "trx"      // MSTest/VSTest results
+	FormatTAP      = "tap"      // Test Anything Protocol v13
+	FormatCucumber = "cucumber" // Cucumber JSON
+	FormatCTRF     = "ctrf"     // Common Test Report Format
+	FormatTestNG   = "testng"   // testng-results.xml
+	FormatNUnit    = "nunit"    // NUnit 3 TestResult.xml
+)
+
+// Formats lists the report formats that can be ingested, in the order they are detected.
+var Formats = []string{FormatJUnit, FormatGoTest, FormatTRX, FormatTAP, FormatCucumber, FormatCTRF,
+	FormatTestNG, FormatNUnit}
+
+// sniffSize is the number of bytes inspected to detect the format of a report.
+const sniffSize = 4096
+
+// ValidFormat reports whether format names a report format that can be ingested.
+func ValidFormat(format string) bool {
+	for _, known := range Formats {
+		if format == known {
+			return true
+		}
+	}
+	return false
+}
+
+// detectFormat detects the format of a report from its first bytes without consuming them.
+// Reports that match no other format are treated as JUnit XML.
+//
+// Parameters:
+// - reader: The buffered reader providing the report.
+//
+// Returns:
+// - string: The detected report format.
+func detectFormat(reader *bufio.Reader) string {
+	header, _ := reader.Peek(sniffSize)
+	trimmed := bytes.TrimLeft(header, " \t\r\n\ufeff")
+
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("{")) && isCTRF(header):
+		return FormatCTRF
+	case bytes.HasPrefix(trimmed, []byte("{")) && isGoTestEvent(firstLine(trimmed)):
+		return FormatGoTest
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return FormatCucumber
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		switch xmlRootElement(header) {
+		case "TestRun":
+			return FormatTRX
+		case "testng-results":
+			return FormatTestNG
+		case "test-run", "test-results":
+			return FormatNUnit
+		}
+	case bytes.HasPrefix(trimmed, []byte("TAP version")), tapPlan.Match(firstLine(trimmed)),
+		tapTestLine.Match(firstLine(trimmed)):
+		return FormatTAP
+	}
+	return FormatJUnit
+}
+
+// firstLine returns the first line of text, without its line ending.
+func firstLine(text []byte) []byte {
+	if end := bytes.IndexByte(text, '\n'); end >= 0 {
+		text = text[:end]
+	}
+	return bytes.TrimRight(text, "\r")
+}
+
+// isCTRF reports whether a JSON document starts like a CTRF report: an object whose results
+// object has a tool or tests member within the header.
+func isCTRF(header []byte) bool {
+	decoder := json.NewDecoder(bytes.NewReader(header))
+	if expectDelim(decoder, '{') != nil {
+		return false
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		if key != "results" {
+			if skipJSONValue(decoder) != nil {
+				return false
+			}
+			continue
+		}
+
+		if expectDelim(decoder, '{') != nil {
+			return false
+		}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return false
+			}
+			if key == "tool" || key == "tests" {
+				return true
+			}
+			if skipJSONValue(decoder) != nil {
+				return false
+			}
+		}
+		return false
+	}
+	return false
+}
+
+// isGoTestEvent reports whether a line starts like an event written by go test -json: an object
+// with top-level Action and Time members, or Action and ImportPath members for the build events of
+// Go 1.24 and later, which have no time. The line may be cut off by the end of the header.
+func isGoTestEvent(line []byte) bool {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	if expectDelim(decoder, '{') != nil {
+		return false
+	}
+	hasAction, hasTime, hasImportPath := false, false, false
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+		switch key {
+		case "Action":
+			hasAction = true
+		case "Time":
+			hasTime = true
+		case "ImportPath":
+			hasImportPath = true
+		}
+		if hasAction && (hasTime || hasImportPath) {
+			return true
+		}
+		if skipJSONValue(decoder) != nil {
+			return false
+		}
+	}
+	return false
+}
+
+// xmlRootElement returns the local name of the first element of an XML document,
+// skipping the declaration, comments and doctype. It returns an empty string if the
+// header contains no start element.
+func xmlRootElement(header []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(header))
+	decoder.Strict = false
+	for {
+		token, err := decoder.RawToken()
+		if err != nil {
+			return ""
+		}
+		if element, ok := token.(xml.StartElement); ok {
+			return element.Name.Local
+		}
+	}
+}
+
+// addFormattedReport adds a report of the given format to the ingestion.
+// When format is empty, the format is detected from the content of the report.
+//
+// Parameters:
+// - format: The format of the report, or an empty string to detect it.
+// - name: The name of the report, used by formats that do not name their suites.
+// - reader: The reader providing the report.
+//
+// Returns:
+// - error: A *ParseError if the report is not valid for its format, or an error if saving the models fails.
+func (ingestion *Ingestion) addFormattedReport(format string, name string, reader io.Reader) error {
+	buffered := bufio.NewReaderSize(reader, sniffSize)
+	if format == "" {
+		format = detectFormat(buffered)
+	}
+	ingestion.report.Format = format
+
+	switch format {
+	case FormatGoTest:
+		return ingestion.AddGoTest(buffered)
+	case FormatTRX:
+		return ingestion.AddTRX(buffered)
+	case FormatTAP:
+		return ingestion.AddTAP(name, buffered)
+	case FormatCucumber:
+		return ingestion.AddCucumber(buffered)
+	case FormatCTRF:
+		return ingestion.AddCTRF(buffered)
+	case FormatTestNG:
+		return ingestion.AddTestNG(buffered)
+	case FormatNUnit:
+		return ingestion.AddNUnit(buffered)
+	default:
+		return ingestion.AddJUnit(buffered)
+	}
+}
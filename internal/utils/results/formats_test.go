@@ -0,0 +1,44 @@
+package results
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	longOutput := strings.Repeat("x", 2*sniffSize)
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"junit", `<?xml version="1.0"?><testsuites><testsuite name="a"/></testsuites>`, FormatJUnit},
+		{"junit with a comment", "\ufeff<!-- generated --><testsuite name=\"a\"/>", FormatJUnit},
+		{"trx", `<?xml version="1.0" encoding="UTF-8"?><TestRun id="1" xmlns="http://microsoft.com/schemas/VisualStudio/TeamTest/2010">`, FormatTRX},
+		{"testng", `<testng-results skipped="0" failed="1" total="3" passed="2">`, FormatTestNG},
+		{"nunit 3", `<test-run id="2" testcasecount="3">`, FormatNUnit},
+		{"nunit 2", `<test-results name="tests.dll" total="3">`, FormatNUnit},
+		{"go test", `{"Time":"2024-05-01T10:00:00Z","Action":"start","Package":"example.com/shop"}` + "\n", FormatGoTest},
+		{"go test build event", `{"ImportPath":"example.com/shop [example.com/shop.test]","Action":"build-output","Output":"# example.com/shop\n"}`, FormatGoTest},
+		{"go test with a long first event", `{"Time":"2024-05-01T10:00:00Z","Action":"output","Output":"` + longOutput + `"}`, FormatGoTest},
+		{"go test keys below the top level", `{"Output":{"Action":"run","Time":"now"}}`, FormatJUnit},
+		{"ctrf", `{"results":{"tool":{"name":"jest"},"summary":{"tests":1}}}`, FormatCTRF},
+		{"ctrf with go test keys in its tests", `{"results":{"tests":[{"name":"a","extra":{"Action":"run","Time":"now"}}],"tool":{"name":"x"}}}`, FormatCTRF},
+		{"ctrf with go test keys first", `{"Action":"export","Time":"now","results":{"tool":{"name":"jest"}}}`, FormatCTRF},
+		{"cucumber", `[{"keyword":"Feature","name":"Checkout","elements":[]}]`, FormatCucumber},
+		{"tap version", "TAP version 13\n1..2\nok 1 - a\n", FormatTAP},
+		{"tap plan", "1..2\nok 1\nnot ok 2\n", FormatTAP},
+		{"tap test line", "ok 1 - adds items\n1..1\n", FormatTAP},
+		{"unknown json", `{"name":"report"}`, FormatJUnit},
+		{"html", `<!DOCTYPE html><html><body>Bad gateway</body></html>`, FormatJUnit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := bufio.NewReaderSize(strings.NewReader(test.header), sniffSize)
+			if got := detectFormat(reader); got != test.want {
+				t.Errorf("detectFormat = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package results
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// GoTestEvent represents a single event of the stream written by go test -json (see go doc test2json).
+type GoTestEvent struct {
+	Time    time.Time `json:"Time"`
+	Action  string    `json:"Action"`
+	Package string    `json:"Package"`
+	Test    string    `json:"Test"`
+	Elapsed float64   `json:"Elapsed"`
+	Output  string    `json:"Output"`
+}
+
+// goTestPackage collects the events of a package whose final event has not been read yet.
+type goTestPackage struct {
+	name   string
+	output strings.Builder
+	tests  []*goTestCase
+	byName map[string]*goTestCase
+}
+
+// goTestCase collects the events of a test or subtest.
+type goTestCase struct {
+	name    string
+	action  string // pass, fail or skip once the test has finished
+	elapsed float64
+	output  strings.Builder
+}
+
+// AddGoTest decodes the event stream written by go test -json from reader and adds it to the ingestion.
+//
+// Every package becomes a test suite and every test and subtest a test case of that suite, named
+// after its full path such as TestParse/empty_input. The output of a test is kept as the body of its
+// failure, or as its system-out if it did not fail. A package that fails without a failing test,
+// for example because it did not build or a test panicked, gets an error test case holding the package output.
+// Tests that have not finished when their package ends are reported as errors.
+//
+// Lines that are not JSON, such as compiler output mixed into the stream, are ignored.
+//
+// Parameters:
+// - reader: The reader providing the event stream.
+//
+// Returns:
+// - error: A *ParseError if the stream contains no events, or an error if saving the models fails.
+func (ingestion *Ingestion) AddGoTest(reader io.Reader) error {
+	buffered := bufio.NewReader(reader)
+	packages := map[string]*goTestPackage{}
+	var order []string
+	var root JUnitTestSuites
+	var started time.Time
+	events := 0
+
+	for {
+		line, err := buffered.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return &ParseError{Err: err}
+		}
+
+		line = bytes.TrimSpace(line)
+		var event GoTestEvent
+		if bytes.HasPrefix(line, []byte("{")) && json.Unmarshal(line, &event) == nil && event.Action != "" {
+			events++
+			if !event.Time.IsZero() && (started.IsZero() || event.Time.Before(started)) {
+				started = event.Time
+			}
+
+			// Build events of Go 1.24 and later carry an ImportPath instead of a Package.
+			if event.Package == "" {
+				continue
+			}
+
+			pkg, ok := packages[event.Package]
+			if !ok {
+				pkg = &goTestPackage{name: event.Package, byName: map[string]*goTestCase{}}
+				packages[event.Package] = pkg
+				order = append(order, event.Package)
+			}
+
+			if pkg.addEvent(event) {
+				delete(packages, event.Package)
+				if suite, ok := pkg.testSuite(event); ok {
+					if err := ingestion.addGoTestSuite(&root, suite); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if events == 0 {
+		return &ParseError{Err: errors.New("report contains no go test events")}
+	}
+
+	// Packages without a final event were cut off, for example by a timeout killing go test.
+	for _, name := range order {
+		pkg, ok := packages[name]
+		if !ok {
+			continue
+		}
+		if suite, ok := pkg.testSuite(GoTestEvent{Action: "fail", Package: name}); ok {
+			if err := ingestion.addGoTestSuite(&root, suite); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !started.IsZero() {
+		root.Timestamp = started.UTC().Format(time.RFC3339Nano)
+	}
+	addReportTotals(&ingestion.result, root)
+	return ingestion.flushIfFull()
+}
+
+// addGoTestSuite adds a package suite to the ingestion and keeps only its totals in root.
+func (ingestion *Ingestion) addGoTestSuite(root *JUnitTestSuites, suite JUnitTestSuite) error {
+	if err := ingestion.addTestSuite(suite, nil); err != nil {
+		return err
+	}
+	suite.TestCases = nil
+	suite.SystemOut = ""
+	root.TestSuites = append(root.TestSuites, suite)
+	return nil
+}
+
+// addEvent records an event of the package.
+//
+// Parameters:
+// - event: The event to record.
+//
+// Returns:
+// - bool: Whether the event is the final event of the package.
+func (pkg *goTestPackage) addEvent(event GoTestEvent) bool {
+	if event.Test == "" {
+		switch event.Action {
+		case "output":
+			pkg.output.WriteString(event.Output)
+		case "pass", "fail", "skip":
+			return true
+		}
+		return false
+	}
+
+	testCase, ok := pkg.byName[event.Test]
+	if !ok {
+		testCase = &goTestCase{name: event.Test}
+		pkg.byName[event.Test] = testCase
+		pkg.tests = append(pkg.tests, testCase)
+	}
+	switch event.Action {
+	case "output":
+		testCase.output.WriteString(event.Output)
+	case "pass", "fail", "skip":
+		testCase.action = event.Action
+		testCase.elapsed = event.Elapsed
+	}
+	return false
+}
+
+// testSuite converts the package into a JUnitTestSuite.
+//
+// Parameters:
+// - final: The final event of the package.
+//
+// Returns:
+// - JUnitTestSuite: The suite of the package.
+// - bool: False if the package has no tests and did not fail, as for packages without test files.
+func (pkg *goTestPackage) testSuite(final GoTestEvent) (JUnitTestSuite, bool) {
+	suite := JUnitTestSuite{
+		Name:      pkg.name,
+		Time:      final.Elapsed,
+		SystemOut: pkg.output.String(),
+	}
+
+	for _, test := range pkg.tests {
+		testCase := JUnitTestCase{
+			ClassName: pkg.name,
+			Name:      test.name,
+			Time:      test.elapsed,
+		}
+		output := test.output.String()
+		switch test.action {
+		case "pass":
+			testCase.SystemOut = output
+		case "skip":
+			testCase.Skipped = &Skipped{Message: "Skipped"}
+			testCase.SystemOut = output
+			suite.Skipped++
+		case "fail":
+			testCase.Failures = []Failure{{Message: "Failed", Text: output}}
+			suite.Failures++
+		default:
+			testCase.Errors = []Error{{Message: "Test did not finish", Text: output}}
+			suite.Errors++
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	if final.Action == "fail" && suite.Failures == 0 && suite.Errors == 0 {
+		suite.TestCases = append(suite.TestCases, JUnitTestCase{
+			ClassName: pkg.name,
+			Name:      "[package failed]",
+			Errors:    []Error{{Message: "Package failed without a failing test", Text: suite.SystemOut}},
+		})
+		suite.Errors++
+	}
+	suite.Tests = len(suite.TestCases)
+	return suite, suite.Tests > 0
+}
@@ -0,0 +1,89 @@
+package results
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// goTestStream is the output of go test -json for a package with passing, failing, skipped and
+// nested tests, a package that failed to build as reported by Go 1.24, a package without test files
+// and a package cut off while a test was running.
+const goTestStream = `{"ImportPath":"example.com/shop/broken [example.com/shop/broken.test]","Action":"build-output","Output":"# example.com/shop/broken\n"}
+{"ImportPath":"example.com/shop/broken [example.com/shop/broken.test]","Action":"build-output","Output":"broken_test.go:3:1: syntax error\n"}
+{"ImportPath":"example.com/shop/broken [example.com/shop/broken.test]","Action":"build-fail"}
+{"Time":"2024-05-01T10:00:01Z","Action":"start","Package":"example.com/shop/broken"}
+{"Time":"2024-05-01T10:00:01Z","Action":"output","Package":"example.com/shop/broken","Output":"FAIL\texample.com/shop/broken [build failed]\n"}
+{"Time":"2024-05-01T10:00:01Z","Action":"fail","Package":"example.com/shop/broken","Elapsed":0,"FailedBuild":"example.com/shop/broken [example.com/shop/broken.test]"}
+{"Time":"2024-05-01T10:00:00Z","Action":"start","Package":"example.com/shop/cart"}
+{"Time":"2024-05-01T10:00:00Z","Action":"run","Package":"example.com/shop/cart","Test":"TestAdd"}
+{"Time":"2024-05-01T10:00:00Z","Action":"output","Package":"example.com/shop/cart","Test":"TestAdd","Output":"=== RUN   TestAdd\n"}
+{"Time":"2024-05-01T10:00:00Z","Action":"pass","Package":"example.com/shop/cart","Test":"TestAdd","Elapsed":0.25}
+{"Time":"2024-05-01T10:00:00Z","Action":"run","Package":"example.com/shop/cart","Test":"TestTotal"}
+{"Time":"2024-05-01T10:00:00Z","Action":"run","Package":"example.com/shop/cart","Test":"TestTotal/empty_cart"}
+{"Time":"2024-05-01T10:00:00Z","Action":"output","Package":"example.com/shop/cart","Test":"TestTotal/empty_cart","Output":"    cart_test.go:20: total = 1, want 0\n"}
+{"Time":"2024-05-01T10:00:00Z","Action":"fail","Package":"example.com/shop/cart","Test":"TestTotal/empty_cart","Elapsed":0.01}
+{"Time":"2024-05-01T10:00:00Z","Action":"fail","Package":"example.com/shop/cart","Test":"TestTotal","Elapsed":0.02}
+{"Time":"2024-05-01T10:00:00Z","Action":"skip","Package":"example.com/shop/cart","Test":"TestLegacy","Elapsed":0}
+{"Time":"2024-05-01T10:00:00Z","Action":"output","Package":"example.com/shop/cart","Output":"FAIL\n"}
+{"Time":"2024-05-01T10:00:00Z","Action":"fail","Package":"example.com/shop/cart","Elapsed":0.5}
+go: downloading example.com/util v1.0.0
+{"Time":"2024-05-01T10:00:02Z","Action":"skip","Package":"example.com/shop/docs","Elapsed":0}
+{"Time":"2024-05-01T10:00:02Z","Action":"run","Package":"example.com/shop/slow","Test":"TestHangs"}
+{"Time":"2024-05-01T10:00:02Z","Action":"output","Package":"example.com/shop/slow","Test":"TestHangs","Output":"=== RUN   TestHangs\n"}
+`
+
+func TestAddGoTest(t *testing.T) {
+	store, ingestion := readReport(t, "", "go-test.json", goTestStream)
+	report := onlyReport(t, ingestion)
+	if report.Format != FormatGoTest {
+		t.Errorf("format = %q, want %q", report.Format, FormatGoTest)
+	}
+
+	wantSuites := "example.com/shop/broken,example.com/shop/cart,example.com/shop/slow"
+	if got := strings.Join(store.suiteNames(), ","); got != wantSuites {
+		t.Errorf("suites = %s, want %s", got, wantSuites)
+	}
+	wantCases := "[package failed]=error,TestAdd=pass,TestTotal=fail,TestTotal/empty_cart=fail,TestLegacy=skipped,TestHangs=error"
+	if got := strings.Join(store.caseStatuses(), ","); got != wantCases {
+		t.Errorf("test cases = %s, want %s", got, wantCases)
+	}
+	if report.Suites != 3 || report.Tests != 6 || report.Failures != 2 || report.Errors != 2 || report.Skipped != 1 {
+		t.Errorf("summary = %+v, want 3 suites, 6 tests, 2 failures, 2 errors and 1 skipped", report)
+	}
+
+	cart := store.suite(t, "example.com/shop/cart")
+	if cart.Time != 0.5 || cart.Failures != 2 || cart.Skipped != 1 {
+		t.Errorf("cart suite = %+v, want time 0.5, 2 failures and 1 skipped", cart)
+	}
+	emptyCart := store.testCase(t, "TestTotal/empty_cart")
+	failures := store.failuresOf(emptyCart.ID)
+	if len(failures) != 1 || !strings.Contains(failures[0].Body, "total = 1, want 0") {
+		t.Errorf("failures of TestTotal/empty_cart = %+v, want its output as the body", failures)
+	}
+	if add := store.testCase(t, "TestAdd"); add.ClassName != "example.com/shop/cart" || add.Time != 0.25 ||
+		store.output(t, add.SystemOutID) != "=== RUN   TestAdd\n" {
+		t.Errorf("TestAdd = %+v, want class example.com/shop/cart, time 0.25 and its output", add)
+	}
+
+	packageFailed := store.testCase(t, "[package failed]")
+	if failures := store.failuresOf(packageFailed.ID); len(failures) != 1 || !strings.Contains(failures[0].Body, "[build failed]") {
+		t.Errorf("failures of the broken package = %+v, want the package output", failures)
+	}
+	hangs := store.testCase(t, "TestHangs")
+	if hangs.Message == nil || *hangs.Message != "Test did not finish" {
+		t.Errorf("TestHangs message = %v, want %q", hangs.Message, "Test did not finish")
+	}
+
+	want := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if executedAt := ingestion.result.ExecutedAt; executedAt == nil || !executedAt.Equal(want) {
+		t.Errorf("executed at = %v, want %v", executedAt, want)
+	}
+}
+
+func TestAddGoTestWithoutEvents(t *testing.T) {
+	_, ingestion := readReport(t, FormatGoTest, "go-test.json", "go: no Go files in /src\n{\"ImportPath\":\"x\"}\n")
+	if len(ingestion.files) != 1 || !strings.Contains(ingestion.files[0].Error, "no go test events") {
+		t.Errorf("files = %+v, want an error for a stream without events", ingestion.files)
+	}
+}
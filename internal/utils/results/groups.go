@@ -0,0 +1,73 @@
+package results
+
+import (
+	"hypha/api/internal/db/tables"
+)
+
+// groupedSuite is a test suite of a report format that lists test cases without nesting them in suites.
+type groupedSuite struct {
+	suite JUnitTestSuite
+	model tables.TestSuite
+}
+
+// suiteGroups groups the test cases of a report into suites by name.
+// Test cases are added to the ingestion as soon as they are read, while the suites are kept until
+// the end of the report so their totals can be counted.
+type suiteGroups struct {
+	ingestion *Ingestion
+	suites    map[string]*groupedSuite
+	order     []string
+}
+
+// newSuiteGroups creates an empty set of suites for a report added to the given ingestion.
+func newSuiteGroups(ingestion *Ingestion) *suiteGroups {
+	return &suiteGroups{ingestion: ingestion, suites: map[string]*groupedSuite{}}
+}
+
+// add adds a test case to the suite with the given name, creating the suite on first use.
+//
+// Parameters:
+// - name: The name of the suite.
+// - file: The file of the suite, used when the suite is created.
+// - testCase: The test case to add.
+//
+// Returns:
+// - error: An error if creating or saving the models fails.
+func (groups *suiteGroups) add(name string, file string, testCase JUnitTestCase) error {
+	suite, ok := groups.suites[name]
+	if !ok {
+		jUnitSuite := JUnitTestSuite{Name: name, File: file}
+		model, err := createTestSuiteModel(jUnitSuite, groups.ingestion.result.ID, nil)
+		if err != nil {
+			return err
+		}
+		suite = &groupedSuite{suite: jUnitSuite, model: model}
+		groups.suites[name] = suite
+		groups.order = append(groups.order, name)
+	}
+
+	countTestCase(&suite.suite, testCase)
+	if err := groups.ingestion.batch.addTestCases([]JUnitTestCase{testCase}, suite.model.ID); err != nil {
+		return err
+	}
+	return groups.ingestion.flushIfFull()
+}
+
+// rename renames the suite with the given name, if there is one, for suites whose name is only known
+// once the whole report has been read.
+func (groups *suiteGroups) rename(from string, to string) {
+	if suite, ok := groups.suites[from]; ok {
+		suite.suite.Name = to
+		suite.model.Name = to
+	}
+}
+
+// close adds the suites, with their counted totals, to the ingestion and to the top-level suites of root.
+func (groups *suiteGroups) close(root *JUnitTestSuites) {
+	for _, name := range groups.order {
+		suite := groups.suites[name]
+		setSuiteModelTotals(&suite.model, suite.suite)
+		groups.ingestion.batch.testSuites = append(groups.ingestion.batch.testSuites, suite.model)
+		root.TestSuites = append(root.TestSuites, suite.suite)
+	}
+}
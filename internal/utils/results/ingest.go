@@ -0,0 +1,449 @@
+package results
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hypha/api/internal/db"
+	"hypha/api/internal/db/tables"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/go-orm/gorm"
+)
+
+// Upload describes the product and CI run an uploaded report belongs to.
+type Upload struct {
+	ProductID      string
+	Metadata       tables.RunMetadata
+	ContentHash    string     // SHA-256 of the uploaded files and the run they belong to, used to detect repeated uploads
+	IdempotencyKey string     // Client supplied key identifying the upload, if any
+	Format         string     // Format of the uploaded reports, or empty to detect it for every report
+	MaxReportSize  int64      // Maximum size of a single report after decompression, or 0 for no limit
+	ExecutedAt     *time.Time // Time the tests ran, taking precedence over the timestamps of the reports
+	Output         OutputOptions
+}
+
+// UploadContentHash computes the content hash of an upload from the SHA-256 digests of its files.
+// The sorted digests are hashed again together with the run metadata, execution time and format,
+// so the hash does not depend on the order or names of the files but differs for the same reports
+// uploaded for another run.
+//
+// Parameters:
+// - digests: The SHA-256 digests of the uploaded files.
+// - upload: The upload, whose metadata, execution time and format are hashed.
+//
+// Returns:
+// - string: The hex encoded content hash.
+// - error: An error if the run metadata cannot be encoded.
+func UploadContentHash(digests [][]byte, upload Upload) (string, error) {
+	sorted := make([]string, 0, len(digests))
+	for _, digest := range digests {
+		sorted = append(sorted, string(digest))
+	}
+	sort.Strings(sorted)
+
+	executedAt := upload.ExecutedAt
+	if executedAt != nil {
+		utc := executedAt.UTC()
+		executedAt = &utc
+	}
+	run, err := json.Marshal(struct {
+		Metadata   tables.RunMetadata
+		ExecutedAt *time.Time
+		Format     string
+	}{upload.Metadata, executedAt, upload.Format})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, digest := range sorted {
+		hash.Write([]byte(digest))
+	}
+	hash.Write(run)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ErrIdempotencyKeyReused is returned when an Idempotency-Key is sent again with different content.
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different upload")
+
+// Summary describes what was stored for an upload.
+type Summary struct {
+	Result    tables.Result `json:"result"`
+	Files     []FileSummary `json:"files"`
+	Duplicate bool          `json:"duplicate"` // Whether the upload repeats an earlier one and nothing was stored
+}
+
+// FileSummary describes the outcome of a single report of an upload.
+// The counts are those of the test suites and test cases stored for the report.
+type FileSummary struct {
+	Name     string   `json:"name"`
+	Format   string   `json:"format,omitempty"`
+	Suites   int      `json:"suites"`
+	Tests    int      `json:"tests"`
+	Failures int      `json:"failures"`
+	Errors   int      `json:"errors"`
+	Skipped  int      `json:"skipped"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"` // Parts of the report that were ignored, or counts that do not add up
+
+	undeclared totalAttributes // Totals the report does not declare, taken from the test cases it contains
+}
+
+// duplicateWindow is how long after a result an upload with the same content hash is taken for a
+// retry of it. Uploads with an idempotency key are matched by key at any time.
+const duplicateWindow = 24 * time.Hour
+
+// totalAttributes is a set of the tests, failures, errors and skipped totals of a report.
+type totalAttributes uint8
+
+const (
+	totalTests totalAttributes = 1 << iota
+	totalFailures
+	totalErrors
+	totalSkipped
+
+	allTotals = totalTests | totalFailures | totalErrors | totalSkipped
+)
+
+// ParseError reports that an uploaded report could not be decoded.
+type ParseError struct {
+	Err error
+}
+
+// Error returns the message of the underlying decoding error.
+func (err *ParseError) Error() string {
+	return "invalid report: " + err.Err.Error()
+}
+
+// Unwrap returns the underlying decoding error.
+func (err *ParseError) Unwrap() error {
+	return err.Err
+}
+
+// Ingestion writes the reports of a single upload into one result.
+// Models are buffered and flushed to the database in batches while reports are read,
+// so memory use does not grow with the size of the upload.
+type Ingestion struct {
+	tx       db.DatabaseOperations
+	upload   Upload
+	result   tables.Result
+	batch    resultBatch
+	files    []FileSummary
+	report   FileSummary // Summary of the report being read
+	ingested int
+}
+
+// Ingest creates a result for the upload and calls fn to add reports to it.
+// Everything is written inside a single transaction: if fn or any write fails, nothing is stored.
+// If the product already has a result with the same idempotency key or, without a key, the same
+// content hash, that result is returned instead and fn is not called.
+// Reports added through AddFile that cannot be parsed are skipped and recorded in the summary,
+// but if none of the files of the upload could be parsed the upload fails with a *ParseError.
+//
+// Parameters:
+// - dbOps: The DatabaseOperations interface for interacting with the database.
+// - upload: The product and run metadata of the upload.
+// - fn: The function adding reports to the ingestion.
+//
+// Returns:
+// - Summary: The stored result, without its test suites, and the outcome of every file.
+// - error: The error returned by fn, ErrIdempotencyKeyReused, or an error if writing the result fails.
+func Ingest(dbOps db.DatabaseOperations, upload Upload, fn func(ingestion *Ingestion) error) (Summary, error) {
+	var summary Summary
+	resultModel, err := createResultModel(upload)
+	if err != nil {
+		return summary, err
+	}
+
+	err = dbOps.Transaction(func(tx db.DatabaseOperations) error {
+		existing, found, err := findDuplicate(tx, upload)
+		if err != nil {
+			return err
+		}
+		if found {
+			summary.Result = existing
+			summary.Duplicate = true
+			return nil
+		}
+
+		ingestion := &Ingestion{tx: tx, upload: upload, result: resultModel}
+		err = fn(ingestion)
+		summary.Files = ingestion.files
+		if err != nil {
+			return err
+		}
+		if len(ingestion.files) > 0 && ingestion.ingested == 0 {
+			return &ParseError{Err: errors.New("no valid reports in upload")}
+		}
+		if upload.ExecutedAt != nil {
+			ingestion.result.ExecutedAt = upload.ExecutedAt
+		}
+		ingestion.batch.results = append(ingestion.batch.results, ingestion.result)
+		if err := ingestion.flush(); err != nil {
+			return err
+		}
+		summary.Result = ingestion.result
+		return nil
+	})
+	return summary, err
+}
+
+// findDuplicate looks up an earlier result of the product for the same upload.
+// Uploads with an idempotency key are matched by key, other uploads by content hash among the
+// results reported within duplicateWindow. The content hash covers the run metadata and execution
+// time, so the same report uploaded for another run is stored again. A transaction
+// level advisory lock on the key or hash makes concurrent retries of the same upload wait for each
+// other, so only one of them stores a result.
+//
+// Parameters:
+// - tx: The DatabaseOperations interface bound to the upload's transaction.
+// - upload: The upload to look up.
+//
+// Returns:
+// - tables.Result: The earlier result, if found.
+// - bool: Whether an earlier result was found.
+// - error: ErrIdempotencyKeyReused if the key matches a result with different content, or an error if the lookup fails.
+func findDuplicate(tx db.DatabaseOperations, upload Upload) (tables.Result, bool, error) {
+	var existing tables.Result
+
+	column, value := "content_hash", upload.ContentHash
+	if upload.IdempotencyKey != "" {
+		column, value = "idempotency_key", upload.IdempotencyKey
+	}
+	if value == "" {
+		return existing, false, nil
+	}
+
+	conn := tx.Connection()
+	if err := conn.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", upload.ProductID+"/"+value).Error; err != nil {
+		return existing, false, err
+	}
+
+	query := conn.Where("product_id = ? AND "+column+" = ?", upload.ProductID, value)
+	if upload.IdempotencyKey == "" {
+		query = query.Where("date_reported > ?", time.Now().Add(-duplicateWindow))
+	}
+	err := query.Order("date_reported").
+		First(&existing).Error
+	if err == gorm.ErrRecordNotFound {
+		return existing, false, nil
+	}
+	if err != nil {
+		return existing, false, err
+	}
+	if upload.IdempotencyKey != "" && existing.ContentHash != upload.ContentHash {
+		return existing, false, ErrIdempotencyKeyReused
+	}
+	return existing, true, nil
+}
+
+// AddFile adds an uploaded file to the ingestion.
+// Compressed files and archives are expanded and every report they contain is added on its own.
+// A report that cannot be parsed is rolled back without affecting the other reports and its
+// error is recorded in the summary of the upload.
+//
+// Parameters:
+// - name: The file name of the upload.
+// - file: The content of the upload.
+// - size: The size of the upload in bytes.
+//
+// Returns:
+// - error: An error if saving the models fails.
+func (ingestion *Ingestion) AddFile(name string, file io.ReaderAt, size int64) error {
+	reports := len(ingestion.files)
+	err := walkReports(name, file, size, ingestion.upload.MaxReportSize, ingestion.addReport)
+	var parseError *ParseError
+	if errors.As(err, &parseError) {
+		ingestion.files = append(ingestion.files, FileSummary{Name: name, Error: parseError.Error()})
+		return nil
+	}
+	if err == nil && len(ingestion.files) == reports {
+		ingestion.files = append(ingestion.files, FileSummary{Name: name, Error: "no reports found in file"})
+	}
+	return err
+}
+
+// addReport adds a single report inside a savepoint of the ingestion's transaction.
+// If the report cannot be parsed, is empty or exceeds the maximum report size, the rows already
+// written for it are rolled back, the totals of the result are restored and the error is recorded
+// in the summary of the upload.
+//
+// Parameters:
+// - name: The name of the report.
+// - reader: The reader providing the report.
+//
+// Returns:
+// - error: An error if saving the models fails.
+func (ingestion *Ingestion) addReport(name string, reader io.Reader) error {
+	if err := ingestion.flush(); err != nil {
+		return err
+	}
+
+	previous := ingestion.result
+	ingestion.report = FileSummary{Name: name}
+	err := ingestion.tx.Transaction(func(tx db.DatabaseOperations) error {
+		if err := ingestion.addFormattedReport(ingestion.upload.Format, name, reader); err != nil {
+			return err
+		}
+		if err := ingestion.flush(); err != nil {
+			return err
+		}
+		return ingestion.validateReport(previous)
+	})
+
+	var parseError *ParseError
+	if errors.Is(err, errReportTooLarge) && !errors.As(err, &parseError) {
+		// Parsers that do not wrap read errors return the size limit error as is.
+		err = &ParseError{Err: err}
+	}
+	if errors.As(err, &parseError) {
+		ingestion.batch = resultBatch{}
+		ingestion.result = previous
+		ingestion.files = append(ingestion.files, FileSummary{Name: name, Format: ingestion.report.Format, Error: parseError.Error()})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	ingestion.files = append(ingestion.files, ingestion.report)
+	ingestion.ingested++
+	return nil
+}
+
+// validateReport checks the report that was just read against what was stored for it.
+// A report without any test suite, such as an HTML error page or an empty document, is rejected.
+// A report without test cases, or whose declared tests, failures or errors differ from the test
+// cases it contains, is stored with a warning. Totals the report does not declare are taken from
+// the test cases it contains.
+//
+// Parameters:
+// - previous: The result as it was before the report was read.
+//
+// Returns:
+// - error: A *ParseError if the report contains no test suites.
+func (ingestion *Ingestion) validateReport(previous tables.Result) error {
+	report := ingestion.report
+	if report.Suites == 0 {
+		return &ParseError{Err: errors.New("report contains no test suites")}
+	}
+	if report.Tests == 0 {
+		ingestion.warn("report contains no test cases")
+		return nil
+	}
+
+	totals := []struct {
+		name      string
+		attribute totalAttributes
+		total     *int
+		previous  int
+		found     int
+	}{
+		{"tests", totalTests, &ingestion.result.Tests, previous.Tests, report.Tests},
+		{"failures", totalFailures, &ingestion.result.Failures, previous.Failures, report.Failures},
+		{"errors", totalErrors, &ingestion.result.Errors, previous.Errors, report.Errors},
+		{"skipped", totalSkipped, &ingestion.result.Skipped, previous.Skipped, report.Skipped},
+	}
+	for _, count := range totals {
+		if report.undeclared&count.attribute != 0 {
+			*count.total = count.previous + count.found
+			continue
+		}
+		// Only tests, failures and errors are checked against the test cases; a declared skipped total is kept
+		declared := *count.total - count.previous
+		if count.attribute != totalSkipped && declared != count.found {
+			ingestion.warn("report declares %d %s but contains %d", declared, count.name, count.found)
+		}
+	}
+	return nil
+}
+
+// warn records a warning for the report being read, such as an element that was ignored.
+// Warnings are returned in the summary of the upload next to the name of the report.
+func (ingestion *Ingestion) warn(format string, args ...interface{}) {
+	ingestion.report.Warnings = append(ingestion.report.Warnings, fmt.Sprintf(format, args...))
+}
+
+// AddTestSuites adds a report that has already been decoded into JUnitTestSuites.
+//
+// Parameters:
+// - testSuites: The JUnitTestSuites of the report.
+//
+// Returns:
+// - error: An error if there is any issue during the creation or saving of the models.
+func (ingestion *Ingestion) AddTestSuites(testSuites JUnitTestSuites) error {
+	for _, suite := range testSuites.TestSuites {
+		if err := ingestion.addTestSuite(suite, nil); err != nil {
+			return err
+		}
+	}
+	addReportTotals(&ingestion.result, testSuites)
+	return nil
+}
+
+// addTestSuite creates a TestSuite model for the given suite and adds it to the ingestion together with
+// its properties, test cases and nested suites. Nested suites are walked recursively and reference
+// the suite that contains them through their ParentID.
+//
+// Parameters:
+// - suite: The JUnitTestSuite to add.
+// - parentID: The ID of the suite containing this suite, or nil for a top-level suite.
+//
+// Returns:
+// - error: An error if there is any issue during the creation or saving of the models.
+func (ingestion *Ingestion) addTestSuite(suite JUnitTestSuite, parentID *string) error {
+	testSuiteModel, err := createTestSuiteModel(suite, ingestion.result.ID, parentID)
+	if err != nil {
+		return err
+	}
+	ingestion.batch.testSuites = append(ingestion.batch.testSuites, testSuiteModel)
+	ingestion.batch.properties = append(ingestion.batch.properties, createProperties(suite.Properties, testSuiteModel.ID)...)
+
+	if err := ingestion.batch.addTestCases(suite.TestCases, testSuiteModel.ID); err != nil {
+		return err
+	}
+	if err := ingestion.flushIfFull(); err != nil {
+		return err
+	}
+
+	for _, nestedSuite := range suite.TestSuites {
+		if err := ingestion.addTestSuite(nestedSuite, &testSuiteModel.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushIfFull writes the buffered models once the batch holds at least db.DefaultBatchSize models.
+func (ingestion *Ingestion) flushIfFull() error {
+	if ingestion.batch.size() < db.DefaultBatchSize {
+		return nil
+	}
+	return ingestion.flush()
+}
+
+// flush writes all buffered models, counts them in the summary of the report being read and
+// empties the batch.
+func (ingestion *Ingestion) flush() error {
+	if err := ingestion.batch.insert(ingestion.tx, ingestion.upload.Output); err != nil {
+		return err
+	}
+	ingestion.report.Suites += len(ingestion.batch.testSuites)
+	for _, testCase := range ingestion.batch.testCases {
+		ingestion.report.Tests++
+		switch testCase.Status {
+		case "fail":
+			ingestion.report.Failures++
+		case "error":
+			ingestion.report.Errors++
+		case "skipped":
+			ingestion.report.Skipped++
+		}
+	}
+	ingestion.batch = resultBatch{}
+	return nil
+}
@@ -0,0 +1,195 @@
+package results
+
+import (
+	"fmt"
+	"hypha/api/internal/db"
+	"hypha/api/internal/db/tables"
+	"strings"
+	"testing"
+
+	"github.com/go-orm/gorm"
+)
+
+// recordingDB is an in-memory DatabaseOperations keeping the models written by an ingestion, so
+// parsers can be tested without a database. A failing transaction discards what was written in it,
+// like the savepoint a report is read in.
+type recordingDB struct {
+	outputs     []tables.Output
+	results     []tables.Result
+	suites      []tables.TestSuite
+	cases       []tables.TestCase
+	failures    []tables.TestCaseFailure
+	reruns      []tables.TestCaseRerun
+	steps       []tables.TestCaseStep
+	attachments []tables.Attachment
+	properties  []tables.Property
+}
+
+func (store *recordingDB) Connection() *gorm.DB {
+	return nil
+}
+
+func (store *recordingDB) Create(value interface{}) error {
+	return fmt.Errorf("recordingDB: Create(%T) is not supported", value)
+}
+
+func (store *recordingDB) First(out interface{}, where ...interface{}) error {
+	return fmt.Errorf("recordingDB: First(%T) is not supported", out)
+}
+
+func (store *recordingDB) CreateInBatches(values interface{}, batchSize int) error {
+	switch values := values.(type) {
+	case []tables.Output:
+		store.outputs = append(store.outputs, values...)
+	case []tables.Result:
+		store.results = append(store.results, values...)
+	case []tables.TestSuite:
+		store.suites = append(store.suites, values...)
+	case []tables.TestCase:
+		store.cases = append(store.cases, values...)
+	case []tables.TestCaseFailure:
+		store.failures = append(store.failures, values...)
+	case []tables.TestCaseRerun:
+		store.reruns = append(store.reruns, values...)
+	case []tables.TestCaseStep:
+		store.steps = append(store.steps, values...)
+	case []tables.Attachment:
+		store.attachments = append(store.attachments, values...)
+	case []tables.Property:
+		store.properties = append(store.properties, values...)
+	default:
+		return fmt.Errorf("recordingDB: CreateInBatches(%T) is not supported", values)
+	}
+	return nil
+}
+
+func (store *recordingDB) CreateMissingInBatches(values interface{}, batchSize int) error {
+	return store.CreateInBatches(values, batchSize)
+}
+
+func (store *recordingDB) Transaction(fn func(tx db.DatabaseOperations) error) error {
+	saved := *store
+	if err := fn(store); err != nil {
+		*store = saved
+		return err
+	}
+	return nil
+}
+
+// suite returns the stored suite with the given name.
+func (store *recordingDB) suite(t *testing.T, name string) tables.TestSuite {
+	t.Helper()
+	for _, suite := range store.suites {
+		if suite.Name == name {
+			return suite
+		}
+	}
+	t.Fatalf("no suite named %q in %v", name, store.suiteNames())
+	return tables.TestSuite{}
+}
+
+// suiteNames returns the names of the stored suites in the order they were written.
+func (store *recordingDB) suiteNames() []string {
+	names := make([]string, 0, len(store.suites))
+	for _, suite := range store.suites {
+		names = append(names, suite.Name)
+	}
+	return names
+}
+
+// testCase returns the stored test case with the given name.
+func (store *recordingDB) testCase(t *testing.T, name string) tables.TestCase {
+	t.Helper()
+	for _, testCase := range store.cases {
+		if testCase.Name == name {
+			return testCase
+		}
+	}
+	t.Fatalf("no test case named %q in %v", name, store.caseStatuses())
+	return tables.TestCase{}
+}
+
+// caseStatuses returns "name=status" for every stored test case in the order they were written.
+func (store *recordingDB) caseStatuses() []string {
+	statuses := make([]string, 0, len(store.cases))
+	for _, testCase := range store.cases {
+		statuses = append(statuses, testCase.Name+"="+testCase.Status)
+	}
+	return statuses
+}
+
+// failuresOf returns the failures and errors stored for a test case.
+func (store *recordingDB) failuresOf(testCaseID string) []tables.TestCaseFailure {
+	var failures []tables.TestCaseFailure
+	for _, failure := range store.failures {
+		if failure.TestCaseID == testCaseID {
+			failures = append(failures, failure)
+		}
+	}
+	return failures
+}
+
+// output returns the content of a stored log, or an empty string if id is nil.
+func (store *recordingDB) output(t *testing.T, id *string) string {
+	t.Helper()
+	if id == nil {
+		return ""
+	}
+	for _, output := range store.outputs {
+		if output.ID == *id {
+			return string(output.Content)
+		}
+	}
+	t.Fatalf("no output with ID %s", *id)
+	return ""
+}
+
+// readReport reads a file into a new ingestion backed by a recordingDB, as an upload of the
+// given format, or with the format detected if it is empty.
+func readReport(t *testing.T, format string, name string, content string) (*recordingDB, *Ingestion) {
+	t.Helper()
+	return readUpload(t, Upload{ProductID: "product", Format: format}, name, content)
+}
+
+// readUpload reads a file into a new ingestion of the upload backed by a recordingDB.
+func readUpload(t *testing.T, upload Upload, name string, content string) (*recordingDB, *Ingestion) {
+	t.Helper()
+	store := &recordingDB{}
+	result, err := createResultModel(upload)
+	if err != nil {
+		t.Fatalf("createResultModel: %v", err)
+	}
+	ingestion := &Ingestion{tx: store, upload: upload, result: result}
+	if err := ingestion.AddFile(name, strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatalf("AddFile(%q): %v", name, err)
+	}
+	return store, ingestion
+}
+
+// onlyReport returns the summary of the single report read by an ingestion, failing the test
+// if the report could not be read.
+func onlyReport(t *testing.T, ingestion *Ingestion) FileSummary {
+	t.Helper()
+	if len(ingestion.files) != 1 {
+		t.Fatalf("read %d reports, want 1: %+v", len(ingestion.files), ingestion.files)
+	}
+	report := ingestion.files[0]
+	if report.Error != "" {
+		t.Fatalf("report %q: %s", report.Name, report.Error)
+	}
+	return report
+}
+
+func TestAddFileRollsBackInvalidReports(t *testing.T) {
+	store, ingestion := readReport(t, FormatJUnit, "broken.xml",
+		`<testsuite name="Checkout" tests="1"><testcase name="pays"/></testsuite><testsuite name="Cart"><testcase`)
+	if len(ingestion.files) != 1 || ingestion.files[0].Error == "" {
+		t.Fatalf("files = %+v, want one file with an error", ingestion.files)
+	}
+	if len(store.suites) != 0 || len(store.cases) != 0 || ingestion.result.Tests != 0 {
+		t.Errorf("stored %v and %d tests for an invalid report, want nothing", store.caseStatuses(), ingestion.result.Tests)
+	}
+	if ingestion.ingested != 0 {
+		t.Errorf("ingested = %d, want 0", ingestion.ingested)
+	}
+}
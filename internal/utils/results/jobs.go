@@ -0,0 +1,462 @@
+package results
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hypha/api/internal/db"
+	"hypha/api/internal/db/tables"
+	"hypha/api/internal/utils/blobs"
+	"hypha/api/internal/utils/logging"
+	"io"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var log = logging.Logger
+
+// ErrQueueFull is returned when an upload is submitted while the ingestion queue is full.
+var ErrQueueFull = errors.New("ingestion queue is full")
+
+// JobFile is a file submitted with an asynchronous upload.
+type JobFile struct {
+	Name string
+	Size int64
+	Open func() (io.ReadCloser, error) // Opens the content of the file, which is closed once it is stored
+}
+
+// Queue processes asynchronous uploads with a bounded pool of workers.
+// Jobs are stored in the database and their files in the blob store before they are queued.
+// Workers claim a job before processing it, so a job queued by several instances of the API is
+// processed once, and jobs left running for longer than the lease are queued again.
+type Queue struct {
+	dbOps         db.DatabaseOperations
+	store         blobs.Store
+	workers       int
+	maxReportSize int64
+	lease         time.Duration
+	output        OutputOptions
+	slots         chan struct{} // Holds one element for every job that is queued or being processed
+	jobs          chan string   // IDs of the jobs waiting for a worker
+}
+
+// NewQueue creates an ingestion queue. Call Start to begin processing jobs.
+//
+// Parameters:
+// - dbOps: The DatabaseOperations interface for interacting with the database.
+// - store: The blob store holding the uploaded files until their job has been processed.
+// - workers: The number of jobs processed at once.
+// - size: The maximum number of jobs that can be queued or processed at once.
+// - maxReportSize: The maximum size of a single report after decompression, or 0 for no limit.
+// - lease: The time after which a running job is considered abandoned and queued again.
+// - output: The options for storing the system-out and system-err logs of the reports.
+//
+// Returns:
+// - *Queue: The created queue.
+func NewQueue(dbOps db.DatabaseOperations, store blobs.Store, workers int, size int, maxReportSize int64, lease time.Duration, output OutputOptions) *Queue {
+	return &Queue{
+		dbOps:         dbOps,
+		store:         store,
+		workers:       workers,
+		maxReportSize: maxReportSize,
+		lease:         lease,
+		output:        output,
+		slots:         make(chan struct{}, size),
+		jobs:          make(chan string, size),
+	}
+}
+
+// Start starts the workers of the queue and queues the jobs that were not finished before.
+// Jobs whose lease expired, because the instance running them stopped, are started over; their
+// results were never committed, so no data is stored twice. Expired jobs are looked for again
+// every lease, so jobs abandoned by another instance are picked up while this one runs.
+//
+// Returns:
+// - error: An error if the unfinished jobs cannot be loaded.
+func (queue *Queue) Start() error {
+	if _, err := queue.reclaimExpired(); err != nil {
+		return err
+	}
+
+	var pending []tables.IngestionJob
+	conn := queue.dbOps.Connection()
+	if err := conn.Select("id").Where("state = ?", tables.JobQueued).Order("created_at").Find(&pending).Error; err != nil {
+		return err
+	}
+
+	for i := 0; i < queue.workers; i++ {
+		go queue.work()
+	}
+
+	pendingIDs := make([]string, len(pending))
+	for i, job := range pending {
+		pendingIDs[i] = job.ID
+	}
+	if len(pendingIDs) > 0 {
+		log.Info().Int("jobs", len(pendingIDs)).Msg("Requeueing unfinished ingestion jobs")
+		go queue.requeue(pendingIDs)
+	}
+	go queue.watchLeases()
+	return nil
+}
+
+// watchLeases queues the jobs whose lease expired again, once every lease.
+func (queue *Queue) watchLeases() {
+	ticker := time.NewTicker(queue.lease)
+	defer ticker.Stop()
+	for range ticker.C {
+		reclaimed, err := queue.reclaimExpired()
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to reclaim expired ingestion jobs")
+			continue
+		}
+		if len(reclaimed) > 0 {
+			log.Warn().Int("jobs", len(reclaimed)).Msg("Requeueing ingestion jobs whose lease expired")
+			queue.requeue(reclaimed)
+		}
+	}
+}
+
+// reclaimExpired marks the running jobs that were started longer than the lease ago as queued.
+// Every job is reset with a conditional update, so a job finished or reclaimed meanwhile by another
+// instance is left alone.
+//
+// Returns:
+// - []string: The IDs of the jobs that were marked as queued.
+// - error: An error if the jobs cannot be loaded or updated.
+func (queue *Queue) reclaimExpired() ([]string, error) {
+	conn := queue.dbOps.Connection()
+	expiredBefore := time.Now().UTC().Add(-queue.lease)
+
+	var expired []tables.IngestionJob
+	if err := conn.Select("id").Where("state = ? AND started_at < ?", tables.JobRunning, expiredBefore).Find(&expired).Error; err != nil {
+		return nil, err
+	}
+
+	var reclaimed []string
+	for _, job := range expired {
+		update := conn.Model(&tables.IngestionJob{}).
+			Where("id = ? AND state = ? AND started_at < ?", job.ID, tables.JobRunning, expiredBefore).
+			Updates(map[string]interface{}{"state": tables.JobQueued, "started_at": nil, "claim_id": nil})
+		if update.Error != nil {
+			return reclaimed, update.Error
+		}
+		if update.RowsAffected == 1 {
+			reclaimed = append(reclaimed, job.ID)
+		}
+	}
+	return reclaimed, nil
+}
+
+// requeue hands stored jobs to the workers, waiting for free slots.
+func (queue *Queue) requeue(jobIDs []string) {
+	for _, jobID := range jobIDs {
+		queue.slots <- struct{}{}
+		queue.jobs <- jobID
+	}
+}
+
+// Submit stores an upload as a queued ingestion job and hands it to the workers.
+// The files are copied to the blob store; the job only records their keys. The content hash of the
+// upload is computed while the files are copied, so they are read only once.
+//
+// Parameters:
+// - upload: The product and run metadata of the upload. Its content hash is ignored.
+// - files: The uploaded files.
+//
+// Returns:
+// - tables.IngestionJob: The stored job, without its files.
+// - error: ErrQueueFull if the queue is full, or an error if storing the job fails.
+func (queue *Queue) Submit(upload Upload, files []JobFile) (tables.IngestionJob, error) {
+	job := tables.IngestionJob{
+		ID:             db.GenerateUniqueID(),
+		ProductID:      upload.ProductID,
+		State:          tables.JobQueued,
+		IdempotencyKey: upload.IdempotencyKey,
+		Format:         upload.Format,
+		ExecutedAt:     upload.ExecutedAt,
+		CreatedAt:      time.Now().UTC(),
+		RunMetadata:    upload.Metadata,
+	}
+
+	select {
+	case queue.slots <- struct{}{}:
+	default:
+		return job, ErrQueueFull
+	}
+
+	jobFiles := make([]tables.IngestionJobFile, 0, len(files))
+	err := func() error {
+		digests := make([][]byte, 0, len(files))
+		for i, file := range files {
+			jobFile := tables.IngestionJobFile{
+				ID:         db.GenerateUniqueID(),
+				JobID:      job.ID,
+				Position:   i,
+				Name:       file.Name,
+				Size:       file.Size,
+				StorageKey: fmt.Sprintf("ingestion-jobs/%s/%d", job.ID, i),
+			}
+			digest, err := queue.storeFile(jobFile.StorageKey, file)
+			if err != nil {
+				return err
+			}
+			digests = append(digests, digest)
+			jobFiles = append(jobFiles, jobFile)
+		}
+
+		var err error
+		if job.ContentHash, err = UploadContentHash(digests, upload); err != nil {
+			return err
+		}
+
+		return queue.dbOps.Transaction(func(tx db.DatabaseOperations) error {
+			if err := tx.Create(&job); err != nil {
+				return err
+			}
+			for i := range jobFiles {
+				if err := tx.Create(&jobFiles[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}()
+	if err != nil {
+		queue.deleteFiles(jobFiles)
+		<-queue.slots
+		return job, err
+	}
+
+	queue.jobs <- job.ID
+	return job, nil
+}
+
+// storeFile copies a submitted file to the blob store and closes it.
+//
+// Parameters:
+// - key: The storage key of the file.
+// - file: The submitted file.
+//
+// Returns:
+// - []byte: The SHA-256 digest of the file.
+// - error: An error if the file cannot be opened or stored.
+func (queue *Queue) storeFile(key string, file JobFile) ([]byte, error) {
+	content, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	hash := sha256.New()
+	if err := queue.store.Put(context.Background(), key, io.TeeReader(content, hash), file.Size, "application/octet-stream"); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// work processes queued jobs until the queue is closed.
+func (queue *Queue) work() {
+	for jobID := range queue.jobs {
+		if err := queue.process(jobID); err != nil {
+			log.Error().Err(err).Str("job", jobID).Msg("Failed to process ingestion job")
+		}
+		<-queue.slots
+	}
+}
+
+// errClaimLost is returned when a job was reclaimed by another worker before its outcome was stored.
+var errClaimLost = errors.New("ingestion job was reclaimed by another worker")
+
+// process claims a queued job, ingests its files and records the outcome on the job.
+// Failures of the upload itself, such as files that are not valid reports, are stored on the job;
+// only errors updating the job are returned. A panic while reading the reports fails the job.
+// The result and the outcome are committed together, and only while the job is still claimed by
+// this run, so a job whose lease expired meanwhile is stored once.
+//
+// Parameters:
+// - jobID: The ID of the job to process.
+//
+// Returns:
+// - error: An error if the job cannot be claimed, loaded or updated.
+func (queue *Queue) process(jobID string) (err error) {
+	conn := queue.dbOps.Connection()
+
+	claimID := db.GenerateUniqueID()
+	claim := conn.Model(&tables.IngestionJob{}).
+		Where("id = ? AND state = ?", jobID, tables.JobQueued).
+		Updates(map[string]interface{}{"state": tables.JobRunning, "started_at": time.Now().UTC(), "claim_id": claimID})
+	if claim.Error != nil {
+		return claim.Error
+	}
+	if claim.RowsAffected == 0 {
+		// Another worker claimed the job, or it is already finished
+		return nil
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Error().Str("job", jobID).Interface("panic", recovered).Bytes("stack", debug.Stack()).Msg("Ingestion job panicked")
+			err = queue.fail(jobID, claimID, "internal error while reading the reports")
+		}
+	}()
+
+	var job tables.IngestionJob
+	if err := conn.Where("id = ?", jobID).First(&job).Error; err != nil {
+		return err
+	}
+
+	var files []tables.IngestionJobFile
+	if err := conn.Where("job_id = ?", job.ID).Order("position").Find(&files).Error; err != nil {
+		return err
+	}
+
+	upload := Upload{
+		ProductID:      job.ProductID,
+		Metadata:       job.RunMetadata,
+		ContentHash:    job.ContentHash,
+		IdempotencyKey: job.IdempotencyKey,
+		Format:         job.Format,
+		ExecutedAt:     job.ExecutedAt,
+		MaxReportSize:  queue.maxReportSize,
+		Output:         queue.output,
+	}
+
+	err = queue.dbOps.Transaction(func(tx db.DatabaseOperations) error {
+		summary, err := Ingest(tx, upload, func(ingestion *Ingestion) error {
+			for _, file := range files {
+				if err := queue.addJobFile(ingestion, file); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+
+		updates := map[string]interface{}{"state": tables.JobSucceeded, "finished_at": time.Now().UTC(), "error": "", "claim_id": nil}
+		var parseError *ParseError
+		switch {
+		case err == nil:
+			updates["result_id"] = summary.Result.ID
+			updates["duplicate"] = summary.Duplicate
+			updates["tests"] = summary.Result.Tests
+			updates["failures"] = summary.Result.Failures
+			updates["errors"] = summary.Result.Errors
+			updates["skipped"] = summary.Result.Skipped
+		case errors.As(err, &parseError), errors.Is(err, ErrIdempotencyKeyReused):
+			updates["state"] = tables.JobFailed
+			updates["error"] = err.Error()
+		default:
+			log.Error().Err(err).Str("job", job.ID).Msg("Failed to store results of ingestion job")
+			updates["state"] = tables.JobFailed
+			updates["error"] = "internal error while storing results"
+		}
+
+		reports := make([]tables.IngestionJobReport, len(summary.Files))
+		for i, file := range summary.Files {
+			reports[i] = tables.IngestionJobReport{
+				ID:       db.GenerateUniqueID(),
+				JobID:    job.ID,
+				Position: i,
+				Name:     file.Name,
+				Format:   file.Format,
+				Suites:   file.Suites,
+				Tests:    file.Tests,
+				Failures: file.Failures,
+				Errors:   file.Errors,
+				Skipped:  file.Skipped,
+				Error:    file.Error,
+				Warnings: pq.StringArray(file.Warnings),
+			}
+		}
+
+		if err := queue.finish(tx, job.ID, claimID, updates); err != nil {
+			return err
+		}
+		return tx.CreateInBatches(reports, db.DefaultBatchSize)
+	})
+	if errors.Is(err, errClaimLost) {
+		log.Warn().Str("job", job.ID).Msg("Ingestion job was reclaimed before it finished, discarding its outcome")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	queue.deleteFiles(files)
+	return nil
+}
+
+// addJobFile adds a file of a job to an ingestion, reading it from the blob store. Archives are
+// read at random offsets, so content the store cannot seek is spooled to a temporary file first.
+func (queue *Queue) addJobFile(ingestion *Ingestion, file tables.IngestionJobFile) error {
+	content, err := queue.store.Get(context.Background(), file.StorageKey)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	if readerAt, ok := content.(io.ReaderAt); ok {
+		return ingestion.AddFile(file.Name, readerAt, file.Size)
+	}
+
+	spool, err := os.CreateTemp("", "ingestion-job-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, content)
+	if err != nil {
+		return err
+	}
+	return ingestion.AddFile(file.Name, spool, size)
+}
+
+// finish records the outcome of a job if it is still claimed by the given run, and clears the
+// content of its legacy files.
+//
+// Returns:
+// - error: errClaimLost if the job is no longer claimed by the run, or an error if updating the job fails.
+func (queue *Queue) finish(tx db.DatabaseOperations, jobID string, claimID string, updates map[string]interface{}) error {
+	conn := tx.Connection()
+	update := conn.Model(&tables.IngestionJob{}).
+		Where("id = ? AND state = ? AND claim_id = ?", jobID, tables.JobRunning, claimID).
+		Updates(updates)
+	if update.Error != nil {
+		return update.Error
+	}
+	if update.RowsAffected == 0 {
+		return errClaimLost
+	}
+	return conn.Exec("UPDATE ingestion_job_files SET content = NULL WHERE job_id = ?", jobID).Error
+}
+
+// fail marks a job claimed by the given run as failed and deletes its files.
+func (queue *Queue) fail(jobID string, claimID string, message string) error {
+	updates := map[string]interface{}{"state": tables.JobFailed, "finished_at": time.Now().UTC(), "error": message, "claim_id": nil}
+	if err := queue.finish(queue.dbOps, jobID, claimID, updates); err != nil {
+		if errors.Is(err, errClaimLost) {
+			return nil
+		}
+		return err
+	}
+
+	var files []tables.IngestionJobFile
+	if err := queue.dbOps.Connection().Where("job_id = ?", jobID).Find(&files).Error; err != nil {
+		return err
+	}
+	queue.deleteFiles(files)
+	return nil
+}
+
+// deleteFiles removes the files of a job from the blob store. Files left behind only take space,
+// so failures are logged and otherwise ignored.
+func (queue *Queue) deleteFiles(files []tables.IngestionJobFile) {
+	for _, file := range files {
+		if err := queue.store.Delete(context.Background(), file.StorageKey); err != nil {
+			log.Warn().Err(err).Str("job", file.JobID).Str("key", file.StorageKey).Msg("Failed to delete file of ingestion job")
+		}
+	}
+}
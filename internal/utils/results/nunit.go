@@ -0,0 +1,375 @@
+package results
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// NUnitTestRun represents the attributes of the <test-run> root element of an NUnit 3 report.
+type NUnitTestRun struct {
+	ID        string `xml:"id,attr"`
+	StartTime string `xml:"start-time,attr"`
+}
+
+// NUnitTestSuite represents the attributes of a <test-suite> element of an NUnit 3 report.
+// Assemblies, namespaces, fixtures and parameterized methods are all written as test suites.
+type NUnitTestSuite struct {
+	Type      string `xml:"type,attr"` // Assembly, TestSuite, TestFixture, ParameterizedMethod, ...
+	ID        string `xml:"id,attr"`
+	Name      string `xml:"name,attr"`
+	FullName  string `xml:"fullname,attr"`
+	ClassName string `xml:"classname,attr"`
+	Result    string `xml:"result,attr"`
+	Label     string `xml:"label,attr"`
+	Site      string `xml:"site,attr"` // Where a failure of the suite happened: SetUp, TearDown, Child, ...
+	StartTime string `xml:"start-time,attr"`
+}
+
+// NUnitEnvironment represents the <environment> element NUnit 3 writes in assembly suites.
+type NUnitEnvironment struct {
+	MachineName string `xml:"machine-name,attr"`
+}
+
+// NUnitTestCase represents a <test-case> of an NUnit 3 report.
+type NUnitTestCase struct {
+	ID         string        `xml:"id,attr"`
+	Name       string        `xml:"name,attr"`
+	FullName   string        `xml:"fullname,attr"`
+	MethodName string        `xml:"methodname,attr"`
+	ClassName  string        `xml:"classname,attr"`
+	Result     string        `xml:"result,attr"` // Passed, Failed, Skipped, Inconclusive or Warning
+	Label      string        `xml:"label,attr"`  // Error, Cancelled, Invalid, Ignored, Explicit, ...
+	Duration   float64       `xml:"duration,attr"`
+	Asserts    int           `xml:"asserts,attr"`
+	Properties []Property    `xml:"properties>property"`
+	Failure    *NUnitMessage `xml:"failure"`
+	Reason     *NUnitMessage `xml:"reason"`
+	Output     string        `xml:"output"`
+}
+
+// NUnitMessage represents the <failure> or <reason> element of an NUnit 3 test.
+type NUnitMessage struct {
+	Message    string `xml:"message"`
+	StackTrace string `xml:"stack-trace"`
+}
+
+// nunitMergedSuites lists the types of NUnit suites whose test cases are stored in the suite
+// containing them. They group the invocations of a single test method, such as the test cases
+// of a parameterized test, which read better next to the other tests of their fixture.
+var nunitMergedSuites = map[string]bool{
+	"ParameterizedMethod": true,
+	"GenericMethod":       true,
+	"Theory":              true,
+}
+
+// nunitSuite is a <test-suite> element of an NUnit report whose end has not been read yet.
+type nunitSuite struct {
+	openSuite
+	attributes NUnitTestSuite
+	merged     bool       // Whether the test cases of the suite are stored in the suite containing it
+	properties []Property // Properties of a merged suite, added to its test cases
+}
+
+// AddNUnit decodes an NUnit 3 report (TestResult.xml) from reader and adds it to the ingestion.
+//
+// Every <test-suite> becomes a test suite nested in the suite containing it, except for suites
+// of parameterized, generic and theory methods: their test cases, one per set of arguments, are
+// stored in the fixture containing them together with the properties of the method. Properties,
+// including categories, are kept on the suites and test cases they belong to. Failures in a
+// OneTimeTearDown, which NUnit reports on the fixture only, are stored as a test case with an
+// error. Suites are counted from their test cases.
+//
+// Elements that are not understood are skipped and recorded as warnings of the report.
+//
+// Parameters:
+// - reader: The reader providing the NUnit 3 report.
+//
+// Returns:
+// - error: A *ParseError if the report is not an NUnit 3 report, or an error if saving the models fails.
+func (ingestion *Ingestion) AddNUnit(reader io.Reader) error {
+	decoder := xml.NewDecoder(reader)
+
+	var root JUnitTestSuites
+	var open []*nunitSuite
+	sawRoot := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &ParseError{Err: err}
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if !sawRoot {
+				sawRoot = true
+				if element.Name.Local == "test-results" {
+					return &ParseError{Err: errors.New("NUnit 2 reports are not supported, use the NUnit 3 format")}
+				}
+				if element.Name.Local != "test-run" {
+					return &ParseError{Err: fmt.Errorf("unexpected root element <%s>", element.Name.Local)}
+				}
+				var run NUnitTestRun
+				if err := decodeAttributes(element, &run); err != nil {
+					return &ParseError{Err: err}
+				}
+				root.Timestamp = run.StartTime
+				continue
+			}
+
+			switch {
+			case element.Name.Local == "test-suite":
+				suite, err := ingestion.openNUnitSuite(element, open)
+				if err != nil {
+					return err
+				}
+				open = append(open, suite)
+				continue
+			case element.Name.Local == "test-case" && len(open) > 0:
+				var testCase NUnitTestCase
+				if err := decoder.DecodeElement(&testCase, &element); err != nil {
+					return &ParseError{Err: err}
+				}
+				if err := ingestion.addNUnitTestCase(testCase, open); err != nil {
+					return err
+				}
+				continue
+			case len(open) > 0:
+				if err := ingestion.decodeNUnitSuiteChild(decoder, element, open); err != nil {
+					return err
+				}
+				continue
+			case element.Name.Local != "command-line" && element.Name.Local != "filter":
+				ingestion.warn("ignored <%s> element", element.Name.Local)
+			}
+			if err := decoder.Skip(); err != nil {
+				return &ParseError{Err: err}
+			}
+
+		case xml.EndElement:
+			if element.Name.Local != "test-suite" || len(open) == 0 {
+				continue
+			}
+			suite := open[len(open)-1]
+			open = open[:len(open)-1]
+			if suite.merged {
+				continue
+			}
+			setSuiteModelTotals(&suite.model, suite.suite)
+			if err := ingestion.closeTestSuite(&suite.openSuite); err != nil {
+				return err
+			}
+			if parent := nearestNUnitSuite(open); parent != nil {
+				addSuiteTotals(&parent.suite, suite.suite)
+			} else {
+				root.TestSuites = append(root.TestSuites, suite.suite)
+			}
+		}
+	}
+
+	if !sawRoot {
+		return &ParseError{Err: errors.New("report has no root element")}
+	}
+	addReportTotals(&ingestion.result, root)
+	return ingestion.flushIfFull()
+}
+
+// openNUnitSuite creates the suite for a <test-suite> start element from its attributes.
+//
+// Parameters:
+// - element: The <test-suite> start element.
+// - open: The suites that are currently open.
+//
+// Returns:
+// - *nunitSuite: The opened suite.
+// - error: A *ParseError if the attributes are invalid, or an error if creating the model fails.
+func (ingestion *Ingestion) openNUnitSuite(element xml.StartElement, open []*nunitSuite) (*nunitSuite, error) {
+	var attributes NUnitTestSuite
+	if err := decodeAttributes(element, &attributes); err != nil {
+		return nil, &ParseError{Err: err}
+	}
+
+	parent := nearestNUnitSuite(open)
+	if parent != nil && nunitMergedSuites[attributes.Type] {
+		return &nunitSuite{attributes: attributes, merged: true}, nil
+	}
+
+	var parentID *string
+	if parent != nil {
+		parentID = &parent.model.ID
+	}
+	suite := JUnitTestSuite{ID: attributes.ID, Name: attributes.Name, Timestamp: attributes.StartTime}
+	model, err := createTestSuiteModel(suite, ingestion.result.ID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	return &nunitSuite{openSuite: openSuite{suite: suite, model: model}, attributes: attributes}, nil
+}
+
+// decodeNUnitSuiteChild decodes a child element of an open suite other than a suite or test case.
+// Properties and output are kept on the suite until it is closed, and a failure in a
+// OneTimeTearDown is added as a test case. Unknown elements are skipped.
+//
+// Parameters:
+// - decoder: The decoder positioned right after the child's start element.
+// - element: The child's start element.
+// - open: The suites that are currently open; the last one contains the child.
+//
+// Returns:
+// - error: A *ParseError if the element cannot be decoded, or an error if saving the models fails.
+func (ingestion *Ingestion) decodeNUnitSuiteChild(decoder *xml.Decoder, element xml.StartElement, open []*nunitSuite) error {
+	suite := open[len(open)-1]
+
+	switch element.Name.Local {
+	case "properties":
+		var properties struct {
+			Properties []Property `xml:"property"`
+		}
+		if err := decoder.DecodeElement(&properties, &element); err != nil {
+			return &ParseError{Err: err}
+		}
+		if suite.merged {
+			suite.properties = append(suite.properties, properties.Properties...)
+		} else {
+			suite.suite.Properties = append(suite.suite.Properties, properties.Properties...)
+		}
+		return nil
+	case "output":
+		var output string
+		if err := decoder.DecodeElement(&output, &element); err != nil {
+			return &ParseError{Err: err}
+		}
+		if !suite.merged {
+			suite.model.SystemOut = output
+		}
+		return nil
+	case "environment":
+		var environment NUnitEnvironment
+		if err := decoder.DecodeElement(&environment, &element); err != nil {
+			return &ParseError{Err: err}
+		}
+		if !suite.merged {
+			suite.model.Hostname = environment.MachineName
+		}
+		return nil
+	case "failure":
+		var failure NUnitMessage
+		if err := decoder.DecodeElement(&failure, &element); err != nil {
+			return &ParseError{Err: err}
+		}
+		// Failures of children and of a OneTimeSetUp are already reported on the test cases.
+		if suite.attributes.Site != "TearDown" {
+			return nil
+		}
+		testCase := NUnitTestCase{
+			ClassName: suite.attributes.ClassName,
+			Name:      "[OneTimeTearDown]",
+			Result:    "Failed",
+			Label:     "Error",
+			Failure:   &failure,
+		}
+		return ingestion.addNUnitTestCase(testCase, open)
+	case "settings", "reason", "attachments", "assertions":
+	default:
+		ingestion.warn("ignored <%s> element in suite %q", element.Name.Local, suite.attributes.FullName)
+	}
+
+	if err := decoder.Skip(); err != nil {
+		return &ParseError{Err: err}
+	}
+	return nil
+}
+
+// addNUnitTestCase adds a test case to the innermost open suite that is not merged into its parent.
+// The properties of the merged suites in between are added to the test case.
+func (ingestion *Ingestion) addNUnitTestCase(nunitTestCase NUnitTestCase, open []*nunitSuite) error {
+	var properties []Property
+	for i := len(open) - 1; i >= 0 && open[i].merged; i-- {
+		properties = append(properties, open[i].properties...)
+	}
+
+	testCase := createNUnitTestCase(nunitTestCase)
+	testCase.Properties = append(properties, testCase.Properties...)
+	switch nunitTestCase.Result {
+	case "Passed", "Failed", "Skipped", "Inconclusive", "Warning":
+	default:
+		ingestion.warn("%s: unknown result %q", nunitTestCase.FullName, nunitTestCase.Result)
+	}
+
+	suite := nearestNUnitSuite(open)
+	countTestCase(&suite.suite, testCase)
+	if err := ingestion.batch.addTestCases([]JUnitTestCase{testCase}, suite.model.ID); err != nil {
+		return err
+	}
+	return ingestion.flushIfFull()
+}
+
+// nearestNUnitSuite returns the innermost open suite that is not merged into its parent,
+// or nil if no suite is open.
+func nearestNUnitSuite(open []*nunitSuite) *nunitSuite {
+	for i := len(open) - 1; i >= 0; i-- {
+		if !open[i].merged {
+			return open[i]
+		}
+	}
+	return nil
+}
+
+// createNUnitTestCase converts an NUnit 3 test case into a JUnitTestCase.
+//
+// Failed test cases labelled Error, Invalid or Cancelled become errors and other failed test cases
+// failures. Skipped and inconclusive test cases are skipped with the reason NUnit gives. Test
+// cases with a warning passed.
+//
+// Parameters:
+// - testCase: The NUnit 3 test case.
+//
+// Returns:
+// - JUnitTestCase: The test case.
+func createNUnitTestCase(testCase NUnitTestCase) JUnitTestCase {
+	jUnitTestCase := JUnitTestCase{
+		ID:         testCase.ID,
+		ClassName:  testCase.ClassName,
+		Name:       testCase.Name,
+		Time:       testCase.Duration,
+		Assertions: testCase.Asserts,
+		Properties: testCase.Properties,
+		SystemOut:  testCase.Output,
+	}
+
+	var failure, reason NUnitMessage
+	if testCase.Failure != nil {
+		failure = *testCase.Failure
+	}
+	if testCase.Reason != nil {
+		reason = *testCase.Reason
+	}
+	message := strings.TrimSpace(failure.Message)
+	stackTrace := strings.TrimSpace(failure.StackTrace)
+
+	switch testCase.Result {
+	case "Passed", "Warning":
+	case "Failed":
+		switch testCase.Label {
+		case "Error", "Invalid", "Cancelled":
+			jUnitTestCase.Errors = []Error{{Message: message, Type: testCase.Label, Text: stackTrace}}
+		default:
+			jUnitTestCase.Failures = []Failure{{Message: message, Type: testCase.Label, Text: stackTrace}}
+		}
+	case "Skipped", "Inconclusive":
+		skipped := strings.TrimSpace(reason.Message)
+		if skipped == "" {
+			skipped = strings.TrimSpace(testCase.Result + " " + testCase.Label)
+		}
+		jUnitTestCase.Skipped = &Skipped{Message: skipped}
+	default:
+		jUnitTestCase.Errors = []Error{{Message: fmt.Sprintf("unknown result %q", testCase.Result), Type: testCase.Result}}
+	}
+	return jUnitTestCase
+}
@@ -0,0 +1,119 @@
+package results
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// nunitTestResult is an NUnit 3 TestResult.xml with a parameterized test and a failing OneTimeTearDown.
+const nunitTestResult = `<?xml version="1.0" encoding="utf-8"?>
+<test-run id="2" testcasecount="7" result="Failed" start-time="2024-05-01 10:00:00Z">
+  <command-line><![CDATA[nunit3-console Shop.Tests.dll]]></command-line>
+  <test-suite type="Assembly" id="1-1" name="Shop.Tests.dll" fullname="Shop.Tests.dll" result="Failed">
+    <environment machine-name="ci-01"/>
+    <properties><property name="_PID" value="42"/></properties>
+    <test-suite type="TestFixture" id="1-2" name="CartTests" fullname="Shop.Tests.CartTests" classname="Shop.Tests.CartTests" result="Failed" site="TearDown">
+      <properties><property name="Category" value="Cart"/></properties>
+      <failure><message>TearDown : cleanup failed</message></failure>
+      <test-case id="1-3" name="AddsItem" fullname="Shop.Tests.CartTests.AddsItem" classname="Shop.Tests.CartTests" result="Passed" duration="0.12" asserts="2">
+        <output><![CDATA[added]]></output>
+      </test-case>
+      <test-case id="1-4" name="ComputesTotal" fullname="Shop.Tests.CartTests.ComputesTotal" classname="Shop.Tests.CartTests" result="Failed">
+        <failure><message><![CDATA[ Expected 1 ]]></message><stack-trace><![CDATA[at CartTests.cs:20]]></stack-trace></failure>
+      </test-case>
+      <test-suite type="ParameterizedMethod" id="1-5" name="Pays" fullname="Shop.Tests.CartTests.Pays" classname="Shop.Tests.CartTests" result="Failed">
+        <properties><property name="Category" value="Payments"/></properties>
+        <test-case id="1-6" name="Pays(&quot;card&quot;)" classname="Shop.Tests.CartTests" result="Passed"/>
+        <test-case id="1-7" name="Pays(&quot;voucher&quot;)" classname="Shop.Tests.CartTests" result="Failed" label="Error">
+          <failure><message><![CDATA[NullReferenceException]]></message></failure>
+        </test-case>
+      </test-suite>
+      <test-case id="1-8" name="Refunds" classname="Shop.Tests.CartTests" result="Skipped" label="Ignored">
+        <reason><message><![CDATA[not ready]]></message></reason>
+      </test-case>
+      <test-case id="1-9" name="PaysLater" classname="Shop.Tests.CartTests" result="Inconclusive"/>
+    </test-suite>
+  </test-suite>
+</test-run>`
+
+func TestAddNUnit(t *testing.T) {
+	store, ingestion := readReport(t, "", "TestResult.xml", nunitTestResult)
+	report := onlyReport(t, ingestion)
+	if report.Format != FormatNUnit {
+		t.Errorf("format = %q, want %q", report.Format, FormatNUnit)
+	}
+	if len(report.Warnings) != 0 {
+		t.Errorf("warnings = %q, want none", report.Warnings)
+	}
+
+	wantSuites := "CartTests,Shop.Tests.dll"
+	if got := strings.Join(store.suiteNames(), ","); got != wantSuites {
+		t.Errorf("suites = %s, want %s without a suite for the parameterized method", got, wantSuites)
+	}
+	wantCases := `[OneTimeTearDown]=error,AddsItem=pass,ComputesTotal=fail,Pays("card")=pass,Pays("voucher")=error,` +
+		`Refunds=skipped,PaysLater=skipped`
+	if got := strings.Join(store.caseStatuses(), ","); got != wantCases {
+		t.Errorf("test cases = %s, want %s", got, wantCases)
+	}
+
+	assembly := store.suite(t, "Shop.Tests.dll")
+	fixture := store.suite(t, "CartTests")
+	if fixture.ParentID == nil || *fixture.ParentID != assembly.ID {
+		t.Errorf("CartTests parent = %v, want the assembly", fixture.ParentID)
+	}
+	if assembly.Hostname != "ci-01" {
+		t.Errorf("assembly hostname = %q, want the machine name", assembly.Hostname)
+	}
+	if assembly.Tests != 7 || assembly.Failures != 1 || assembly.Errors != 2 || assembly.Skipped != 2 {
+		t.Errorf("assembly totals = %d/%d/%d/%d, want 7 tests, 1 failure, 2 errors and 2 skipped",
+			assembly.Tests, assembly.Failures, assembly.Errors, assembly.Skipped)
+	}
+	wantStart := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if executedAt := ingestion.result.ExecutedAt; executedAt == nil || !executedAt.Equal(wantStart) {
+		t.Errorf("executed at = %v, want the start time of the run %v", executedAt, wantStart)
+	}
+
+	properties := map[string][]string{}
+	for _, property := range store.properties {
+		owner := ""
+		if property.TestSuiteID != nil {
+			owner = *property.TestSuiteID
+		} else if property.TestCaseID != nil {
+			owner = *property.TestCaseID
+		}
+		properties[owner] = append(properties[owner], property.Name+"="+property.Value)
+	}
+	if got := strings.Join(properties[fixture.ID], ","); got != "Category=Cart" {
+		t.Errorf("properties of CartTests = %s, want its category", got)
+	}
+	if got := strings.Join(properties[store.testCase(t, `Pays("card")`).ID], ","); got != "Category=Payments" {
+		t.Errorf(`properties of Pays("card") = %s, want the category of the parameterized method`, got)
+	}
+
+	tearDown := store.testCase(t, "[OneTimeTearDown]")
+	if failures := store.failuresOf(tearDown.ID); len(failures) != 1 || failures[0].Message != "TearDown : cleanup failed" {
+		t.Errorf("failures of [OneTimeTearDown] = %+v, want the failure of the fixture", failures)
+	}
+	addsItem := store.testCase(t, "AddsItem")
+	if addsItem.Time != 0.12 || addsItem.Assertions != 2 || store.output(t, addsItem.SystemOutID) != "added" {
+		t.Errorf("AddsItem = %+v, want its duration, assertions and output", addsItem)
+	}
+	total := store.testCase(t, "ComputesTotal")
+	if failures := store.failuresOf(total.ID); len(failures) != 1 || failures[0].Message != "Expected 1" || failures[0].Body != "at CartTests.cs:20" {
+		t.Errorf("failures of ComputesTotal = %+v, want the trimmed message and stack trace", failures)
+	}
+	if refunds := store.testCase(t, "Refunds"); refunds.Message == nil || *refunds.Message != "not ready" {
+		t.Errorf("Refunds message = %v, want the reason", refunds.Message)
+	}
+	if later := store.testCase(t, "PaysLater"); later.Message == nil || *later.Message != "Inconclusive" {
+		t.Errorf("PaysLater message = %v, want the result", later.Message)
+	}
+}
+
+func TestAddNUnitRejectsNUnit2(t *testing.T) {
+	_, ingestion := readReport(t, FormatNUnit, "TestResult.xml", `<test-results name="Shop.Tests.dll" total="0"/>`)
+	if len(ingestion.files) != 1 || !strings.Contains(ingestion.files[0].Error, "NUnit 2 reports are not supported") {
+		t.Errorf("files = %+v, want an error for an NUnit 2 report", ingestion.files)
+	}
+}
@@ -0,0 +1,210 @@
+package results
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hypha/api/internal/config"
+	"hypha/api/internal/db/tables"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+// minCompressedOutputSize is the size below which logs are stored uncompressed even when compression
+// is enabled, as gzip cannot make them meaningfully smaller.
+const minCompressedOutputSize = 512
+
+// outputTruncationMarker replaces the middle of logs longer than the maximum output size.
+const outputTruncationMarker = "\n[... %d bytes truncated ...]\n"
+
+// OutputOptions configures how the system-out and system-err logs of an upload are stored.
+type OutputOptions struct {
+	MaxSize  int64 // Maximum size of a stored log in bytes, or 0 for no limit. Longer logs are truncated.
+	Compress bool  // Whether logs are stored gzip compressed
+}
+
+// OutputOptionsFromConfig returns the output options set in the ingestion configuration.
+//
+// Parameters:
+// - cfg: The configuration object containing the ingestion settings.
+//
+// Returns:
+// - OutputOptions: The options for storing logs.
+func OutputOptionsFromConfig(cfg *config.Config) OutputOptions {
+	return OutputOptions{
+		MaxSize:  cfg.Ingestion.Output.MaxSize,
+		Compress: cfg.Ingestion.Output.Compression == tables.OutputGzip,
+	}
+}
+
+// outputSet collects the logs of a batch as Output models, keeping every distinct log once.
+type outputSet struct {
+	options OutputOptions
+	outputs []tables.Output
+	seen    map[string]bool
+}
+
+// newOutputSet creates an empty set storing logs with the given options.
+func newOutputSet(options OutputOptions) *outputSet {
+	return &outputSet{options: options, seen: map[string]bool{}}
+}
+
+// add adds a log to the set and returns the ID of its Output model, or nil for an empty log.
+//
+// Parameters:
+// - content: The log.
+//
+// Returns:
+// - *string: The ID of the Output model holding the log, or nil if the log is empty.
+// - error: An error if the log cannot be compressed.
+func (set *outputSet) add(content string) (*string, error) {
+	if content == "" {
+		return nil, nil
+	}
+	writer := newOutputWriter(set.options)
+	if _, err := io.WriteString(writer, content); err != nil {
+		return nil, err
+	}
+	id := writer.id()
+	if set.seen[id] {
+		return &id, nil
+	}
+	output, err := writer.output()
+	if err != nil {
+		return nil, err
+	}
+	set.addOutput(*output)
+	return &id, nil
+}
+
+// addOutput adds an Output model built beforehand to the set, unless a model for the same log is in it already.
+func (set *outputSet) addOutput(output tables.Output) {
+	if set.seen[output.ID] {
+		return
+	}
+	set.seen[output.ID] = true
+	set.outputs = append(set.outputs, output)
+}
+
+// outputWriter builds the Output model of a log written to it in pieces. The size and SHA-256 of the
+// whole log are computed as it is written, but only what a truncated log keeps is held in memory:
+// the first MaxSize bytes and a window of the last ones. Without a maximum size the log is kept whole.
+type outputWriter struct {
+	options OutputOptions
+	size    int64
+	hash    hash.Hash
+	head    []byte // Beginning of the log
+	tail    []byte // Between MaxSize and 2*MaxSize bytes from the end of the log, once it outgrows MaxSize
+}
+
+// newOutputWriter creates a writer for a log stored with the given options.
+func newOutputWriter(options OutputOptions) *outputWriter {
+	return &outputWriter{options: options, hash: sha256.New()}
+}
+
+// Write appends a piece of the log.
+func (writer *outputWriter) Write(piece []byte) (int, error) {
+	writer.hash.Write(piece)
+	writer.size += int64(len(piece))
+	maxSize := int(writer.options.MaxSize)
+	if maxSize <= 0 {
+		writer.head = append(writer.head, piece...)
+		return len(piece), nil
+	}
+	if room := maxSize - len(writer.head); room > 0 {
+		writer.head = append(writer.head, piece[:min(room, len(piece))]...)
+	}
+	writer.tail = append(writer.tail, piece...)
+	if len(writer.tail) > 2*maxSize {
+		writer.tail = writer.tail[:copy(writer.tail, writer.tail[len(writer.tail)-maxSize:])]
+	}
+	return len(piece), nil
+}
+
+// id returns the ID of the log's Output model, which is the hex encoded SHA-256 of the whole log.
+func (writer *outputWriter) id() string {
+	return hex.EncodeToString(writer.hash.Sum(nil))
+}
+
+// output returns the Output model of the log written so far, or nil if nothing was written.
+// Logs longer than the maximum output size are truncated, and logs are compressed if enabled and
+// gzip makes them smaller.
+//
+// Returns:
+// - *tables.Output: The Output model holding the log, or nil for an empty log.
+// - error: An error if the log cannot be compressed.
+func (writer *outputWriter) output() (*tables.Output, error) {
+	if writer.size == 0 {
+		return nil, nil
+	}
+	stored, truncated := writer.stored()
+	output := &tables.Output{
+		ID:         writer.id(),
+		Size:       writer.size,
+		StoredSize: int64(len(stored)),
+		Truncated:  truncated,
+		Content:    stored,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if writer.options.Compress && len(stored) >= minCompressedOutputSize {
+		compressed, err := gzipOutput(stored)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(stored) {
+			output.Compression = tables.OutputGzip
+			output.Content = compressed
+		}
+	}
+	return output, nil
+}
+
+// stored returns the log as it is stored. A log longer than the maximum output size keeps its
+// beginning and end, with the middle replaced by a marker stating how many bytes were removed.
+// Cuts are moved to rune boundaries so the result stays valid UTF-8.
+//
+// Returns:
+// - []byte: The log, truncated if needed.
+// - bool: Whether the log was truncated.
+func (writer *outputWriter) stored() ([]byte, bool) {
+	if writer.options.MaxSize <= 0 || writer.size <= writer.options.MaxSize {
+		return writer.head, false
+	}
+	size := int(writer.size)
+	// The marker grows with the number of removed bytes, so reserve room for the largest possible count.
+	keep := int(writer.options.MaxSize) - len(fmt.Sprintf(outputTruncationMarker, size))
+	if keep < 0 {
+		keep = 0
+	}
+	head := keep / 2
+	for head > 0 && !utf8.RuneStart(writer.head[head]) {
+		head--
+	}
+	// The tail window holds the last len(writer.tail) bytes of the log, which is at least MaxSize.
+	offset := size - len(writer.tail)
+	tail := size - (keep - head)
+	for tail < size && !utf8.RuneStart(writer.tail[tail-offset]) {
+		tail++
+	}
+	stored := make([]byte, 0, int(writer.options.MaxSize))
+	stored = append(stored, writer.head[:head]...)
+	stored = append(stored, fmt.Sprintf(outputTruncationMarker, tail-head)...)
+	return append(stored, writer.tail[tail-offset:]...), true
+}
+
+// gzipOutput compresses a log with gzip.
+func gzipOutput(content []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(content); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
@@ -1,76 +1,30 @@
 package results
 
 import (
-	"bytes"
+	"fmt"
 	"hypha/api/internal/db"
 	"hypha/api/internal/db/tables"
+	"path"
+	"regexp"
 	"strings"
 	"time"
 )
 
-// ParseJUnitResults parses JUnit test results and stores them in the database.
-//
-// This function iterates over the provided JUnit test suites, creates corresponding
-// result and test suite models, and saves them to the database. It also creates and
-// saves properties and test cases associated with each test suite.
-//
-// Parameters:
-// - testSuites: The JUnitTestSuites containing the test results to be parsed.
-// - dbOps: The DatabaseOperations interface for interacting with the database.
-// - productId: The ID of the product for which the test results are being parsed.
-//
-// Returns:
-// - error: An error if there is any issue during the parsing or saving of the test results.
-func ParseJUnitResults(testSuites JUnitTestSuites, dbOps db.DatabaseOperations, productId string) error {
-	for _, suite := range testSuites.TestSuites {
-		resultModel, err := createResultModel(productId)
-		if err != nil {
-			return err
-		}
-		if err := dbOps.Create(&resultModel); err != nil {
-			return err
-		}
-
-		testSuiteModel, err := createTestSuiteModel(suite, resultModel.ID)
-		if err != nil {
-			return err
-		}
-		if err := dbOps.Create(&testSuiteModel); err != nil {
-			return err
-		}
-
-		if err := createAndSaveProperties(suite.Properties, testSuiteModel.ID, dbOps); err != nil {
-			return err
-		}
-
-		if err := createAndSaveTestCases(suite.TestCases, testSuiteModel.ID, dbOps); err != nil {
-			return err
-		}
-	}
-	return nil
+// timestampLayouts lists the timestamp formats accepted in reports, most specific first.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
 }
 
-// ContainsTestsuitesTag checks if the given XML content contains a <testsuites> tag.
-//
-// Parameters:
-// - xmlContent: The XML content to check as a byte slice.
-//
-// Returns:
-// - bool: A boolean indicating whether the <testsuites> tag is present in the XML content.
-func ContainsTestsuitesTag(xmlContent []byte) bool {
-	return bytes.Contains(xmlContent, []byte("<testsuites"))
-}
+// resolvedTimeZones lists the time zone abbreviations after a timestamp that are resolved, as in
+// "2024-05-01T10:00:00 UTC" written by TestNG. Other abbreviations are ambiguous (IST stands for
+// India, Ireland and Israel), so their wall time is kept as if it were UTC.
+var resolvedTimeZones = map[string]bool{"UTC": true, "GMT": true}
 
-// WrapInTestsuitesTag wraps the given XML content in a <testsuites> tag.
-//
-// Parameters:
-// - xmlContent: The XML content to wrap as a byte slice.
-//
-// Returns:
-// - []byte: A new byte slice with the XML content wrapped in a <testsuites> tag.
-func WrapInTestsuitesTag(xmlContent []byte) []byte {
-	return append([]byte("<testsuites>"), append(xmlContent, []byte("</testsuites>")...)...)
-}
+// timeZoneAbbreviation matches a time zone abbreviation ending a timestamp.
+var timeZoneAbbreviation = regexp.MustCompile(`^(.*\d) ([A-Z]{2,5})$`)
 
 // trimLeadingWhitespace removes the leading whitespace from each line of the input text.
 // It calculates the minimum indentation level across all lines and removes that amount
@@ -124,37 +78,170 @@ func trimLeadingWhitespace(text string) string {
 	return strings.Join(lines, "\n")
 }
 
-// createResultModel creates a new Result model with the given productId.
-// It generates a unique ID and sets the current UTC time as the DateReported.
+// createResultModel creates a new Result model for the given upload.
+// It generates a unique ID, sets the current UTC time as the DateReported and copies the
+// product, run metadata, content hash and idempotency key of the upload. Totals are added as
+// reports are read.
 //
 // Parameters:
-// - productId: The ID of the product for which the result is being created.
+// - upload: The product and run metadata of the upload the result is being created for.
 //
 // Returns:
 // - tables.Result: The created Result model.
 // - error: An error if there is any issue during the creation of the model.
-func createResultModel(productId string) (tables.Result, error) {
+func createResultModel(upload Upload) (tables.Result, error) {
 	return tables.Result{
-		ID:           db.GenerateUniqueID(),
-		ProductID:    productId,
-		DateReported: time.Now().UTC(),
+		ID:             db.GenerateUniqueID(),
+		ProductID:      upload.ProductID,
+		ContentHash:    upload.ContentHash,
+		IdempotencyKey: upload.IdempotencyKey,
+		DateReported:   time.Now().UTC(),
+		RunMetadata:    upload.Metadata,
 	}, nil
 }
 
-// createTestSuiteModel creates a new TestSuite model from the given JUnitTestSuite and resultID.
+// addReportTotals adds the name, totals, time and timestamp of a report's <testsuites> element
+// to the given result. Totals the report does not declare are summed from its top-level suites, and
+// without a report timestamp the earliest timestamp of its top-level suites is used.
+// When a result is made of several reports, the first report name and the earliest timestamp are kept.
+//
+// Parameters:
+// - result: The Result model to update.
+// - testSuites: The JUnitTestSuites of the report. Only attributes of its suites are used.
+func addReportTotals(result *tables.Result, testSuites JUnitTestSuites) {
+	report := tables.Result{
+		Tests:      testSuites.Tests,
+		Failures:   testSuites.Failures,
+		Errors:     testSuites.Errors,
+		Skipped:    testSuites.Skipped,
+		Assertions: testSuites.Assertions,
+		Time:       testSuites.Time,
+	}
+
+	var totals tables.Result
+	for _, suite := range testSuites.TestSuites {
+		totals.Tests += suite.Tests
+		totals.Failures += suite.Failures
+		totals.Errors += suite.Errors
+		totals.Skipped += suite.Skipped
+		totals.Assertions += suite.Assertions
+		totals.Time += suite.Time
+		if executedAt := parseTimestamp(suite.Timestamp); executedAt != nil {
+			if totals.ExecutedAt == nil || executedAt.Before(*totals.ExecutedAt) {
+				totals.ExecutedAt = executedAt
+			}
+		}
+	}
+	if report.Tests == 0 {
+		report.Tests = totals.Tests
+	}
+	if report.Failures == 0 {
+		report.Failures = totals.Failures
+	}
+	if report.Errors == 0 {
+		report.Errors = totals.Errors
+	}
+	if report.Skipped == 0 {
+		report.Skipped = totals.Skipped
+	}
+	if report.Assertions == 0 {
+		report.Assertions = totals.Assertions
+	}
+	if report.Time == 0 {
+		report.Time = totals.Time
+	}
+
+	if result.Name == "" {
+		result.Name = testSuites.Name
+	}
+	result.Tests += report.Tests
+	result.Failures += report.Failures
+	result.Errors += report.Errors
+	result.Skipped += report.Skipped
+	result.Assertions += report.Assertions
+	result.Time += report.Time
+	executedAt := parseTimestamp(testSuites.Timestamp)
+	if executedAt == nil {
+		executedAt = totals.ExecutedAt
+	}
+	if executedAt != nil {
+		if result.ExecutedAt == nil || executedAt.Before(*result.ExecutedAt) {
+			result.ExecutedAt = executedAt
+		}
+	}
+}
+
+// ParseExecutedAt parses the execution time sent with an upload. The same formats as report
+// timestamps are accepted, such as RFC 3339 or ISO 8601 local time, which is taken as UTC.
+//
+// Parameters:
+// - value: The execution time.
+//
+// Returns:
+// - *time.Time: The parsed time in UTC.
+// - error: An error if the value is not a recognized timestamp.
+func ParseExecutedAt(value string) (*time.Time, error) {
+	executedAt := parseTimestamp(value)
+	if executedAt == nil {
+		return nil, fmt.Errorf("unrecognized timestamp %q", value)
+	}
+	return executedAt, nil
+}
+
+// parseTimestamp parses a JUnit timestamp attribute.
+// JUnit reports usually omit the time zone (ISO 8601 local time), in which case UTC is assumed.
+// A trailing time zone abbreviation is only resolved for UTC and GMT; for other zones the wall
+// time is kept as if it were UTC (see unresolvedTimeZone).
+//
+// Parameters:
+// - value: The timestamp attribute value.
+//
+// Returns:
+// - *time.Time: The parsed time in UTC, or nil if the value is empty or not a recognized timestamp.
+func parseTimestamp(value string) *time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if match := timeZoneAbbreviation.FindStringSubmatch(value); match != nil {
+		// The wall time is read in UTC both for UTC and GMT and for zones that are not resolved.
+		value = match[1]
+	}
+	for _, layout := range timestampLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			parsed = parsed.UTC()
+			return &parsed
+		}
+	}
+	return nil
+}
+
+// unresolvedTimeZone returns the time zone abbreviation ending a timestamp if parseTimestamp
+// does not resolve it, such as "CET" for "2024-05-01T10:00:00 CET", or an empty string.
+func unresolvedTimeZone(value string) string {
+	match := timeZoneAbbreviation.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil || resolvedTimeZones[match[2]] {
+		return ""
+	}
+	return match[2]
+}
+
+// createTestSuiteModel creates a new TestSuite model from the given JUnitTestSuite, resultID and parentID.
 // It generates a unique ID for the TestSuite and populates the fields based on the provided suite.
 //
 // Parameters:
 // - suite: The JUnitTestSuite containing the data for the TestSuite model.
 // - resultID: The ID of the associated result.
+// - parentID: The ID of the suite containing this suite, or nil for a top-level suite.
 //
 // Returns:
 // - tables.TestSuite: The created TestSuite model.
 // - error: An error if there is any issue during the creation of the model.
-func createTestSuiteModel(suite JUnitTestSuite, resultID string) (tables.TestSuite, error) {
+func createTestSuiteModel(suite JUnitTestSuite, resultID string, parentID *string) (tables.TestSuite, error) {
 	return tables.TestSuite{
 		ID:         db.GenerateUniqueID(),
 		ResultID:   resultID,
+		ParentID:   parentID,
 		Name:       suite.Name,
 		Tests:      suite.Tests,
 		Failures:   suite.Failures,
@@ -163,68 +250,101 @@ func createTestSuiteModel(suite JUnitTestSuite, resultID string) (tables.TestSui
 		Assertions: suite.Assertions,
 		Time:       suite.Time,
 		File:       suite.File,
+		ExecutedAt: parseTimestamp(suite.Timestamp),
+		Hostname:   strings.TrimSpace(suite.Hostname),
 		SystemOut:  suite.SystemOut,
 		SystemErr:  suite.SystemErr,
 	}, nil
 }
 
-// createAndSaveProperties creates and saves Property models for the given properties and testSuiteID.
-// It iterates over the provided properties, creates a Property model for each, and saves it to the database.
+// createProperties creates Property models for the given properties and testSuiteID.
+// It iterates over the provided properties and creates a Property model for each.
 //
 // Parameters:
 // - properties: A slice of Property structs containing the data for each property.
 // - testSuiteID: The ID of the associated test suite.
-// - dbOps: The DatabaseOperations interface for interacting with the database.
 //
 // Returns:
-// - error: An error if there is any issue during the creation or saving of the properties.
-func createAndSaveProperties(properties []Property, testSuiteID string, dbOps db.DatabaseOperations) error {
+// - []tables.Property: The created Property models.
+func createProperties(properties []Property, testSuiteID string) []tables.Property {
+	propertyModels := make([]tables.Property, 0, len(properties))
 	for _, property := range properties {
 		value := property.Value
 		if value == "" {
 			value = trimLeadingWhitespace(property.Text)
 		}
-		propertyModel := tables.Property{
+		propertyModels = append(propertyModels, tables.Property{
 			ID:          db.GenerateUniqueID(),
 			TestSuiteID: &testSuiteID,
 			Name:        property.Name,
 			Value:       value,
-		}
-		if err := dbOps.Create(&propertyModel); err != nil {
-			return err
-		}
+		})
 	}
-	return nil
+	return propertyModels
 }
 
-// createAndSaveTestCases creates and saves TestCase models for the given test cases and testSuiteID.
-// It iterates over the provided test cases, creates a TestCase model for each, and saves it to the database.
-// Additionally, it creates and saves properties for each test case.
+// addTestCases creates TestCase models for the given test cases and testSuiteID and adds them to the batch.
+// It iterates over the provided test cases, creates a TestCase model for each, and adds the
+// failures, rerun attempts, steps, attachment references and properties of each test case to the batch as well.
 //
 // Parameters:
 // - testCases: A slice of JUnitTestCase structs containing the data for each test case.
 // - testSuiteID: The ID of the associated test suite.
-// - dbOps: The DatabaseOperations interface for interacting with the database.
 //
 // Returns:
-// - error: An error if there is any issue during the creation or saving of the test cases or their properties.
-func createAndSaveTestCases(testCases []JUnitTestCase, testSuiteID string, dbOps db.DatabaseOperations) error {
+// - error: An error if there is any issue during the creation of the test cases.
+func (batch *resultBatch) addTestCases(testCases []JUnitTestCase, testSuiteID string) error {
 	for _, testCase := range testCases {
 		testCaseModel, err := createTestCaseModel(testCase, testSuiteID)
 		if err != nil {
 			return err
 		}
-		if err := dbOps.Create(&testCaseModel); err != nil {
-			return err
-		}
-
-		if err := createAndSaveTestCaseProperties(testCase.Properties, testCaseModel.ID, dbOps); err != nil {
-			return err
-		}
+		batch.testCases = append(batch.testCases, testCaseModel)
+		batch.failures = append(batch.failures, createTestCaseFailures(testCase, testCaseModel.ID)...)
+		batch.reruns = append(batch.reruns, createTestCaseReruns(testCase, testCaseModel.ID)...)
+		batch.steps = append(batch.steps, createTestCaseSteps(testCase.Steps, testCaseModel.ID)...)
+		batch.attachments = append(batch.attachments, createTestCaseAttachments(testCase, testCaseModel.ID)...)
+		batch.properties = append(batch.properties, createTestCaseProperties(testCase.Properties, testCaseModel.ID)...)
 	}
 	return nil
 }
 
+// countTestCase adds a test case to the totals of its suite.
+func countTestCase(suite *JUnitTestSuite, testCase JUnitTestCase) {
+	suite.Tests++
+	suite.Time += testCase.Time
+	suite.Assertions += testCase.Assertions
+	switch {
+	case len(testCase.Failures) > 0:
+		suite.Failures++
+	case len(testCase.Errors) > 0:
+		suite.Errors++
+	case testCase.Skipped != nil:
+		suite.Skipped++
+	}
+}
+
+// addSuiteTotals adds the totals of a nested suite to the suite containing it.
+// It is used by parsers that count their suites from the test cases they read.
+func addSuiteTotals(parent *JUnitTestSuite, nested JUnitTestSuite) {
+	parent.Tests += nested.Tests
+	parent.Failures += nested.Failures
+	parent.Errors += nested.Errors
+	parent.Skipped += nested.Skipped
+	parent.Assertions += nested.Assertions
+	parent.Time += nested.Time
+}
+
+// setSuiteModelTotals copies the counted totals of a suite to its TestSuite model.
+func setSuiteModelTotals(model *tables.TestSuite, suite JUnitTestSuite) {
+	model.Tests = suite.Tests
+	model.Failures = suite.Failures
+	model.Errors = suite.Errors
+	model.Skipped = suite.Skipped
+	model.Assertions = suite.Assertions
+	model.Time = suite.Time
+}
+
 // createTestCaseModel creates a new TestCase model from the given JUnitTestCase and testSuiteID.
 // It determines the status, message, and type of the test case, generates a unique ID, and populates the fields.
 //
@@ -250,19 +370,23 @@ func createTestCaseModel(testCase JUnitTestCase, testSuiteID string) (tables.Tes
 		Assertions:  testCase.Assertions,
 		File:        testCase.File,
 		Line:        testCase.Line,
+		SystemOutID: testCase.systemOutID,
+		SystemErrID: testCase.systemErrID,
 		SystemOut:   testCase.SystemOut,
 		SystemErr:   testCase.SystemErr,
 	}, nil
 }
 
 // determineTestCaseStatus determines the status, message, and type of a given JUnitTestCase.
-// It checks if the test case has a failure, error, or is skipped, and sets the status accordingly.
+// It checks if the test case has a failure, error, is skipped, or only passed after being rerun,
+// and sets the status accordingly. When a test case has several failures or errors, the message
+// and type of the first one are used.
 //
 // Parameters:
 // - testCase: The JUnitTestCase for which the status, message, and type need to be determined.
 //
 // Returns:
-// - string: The status of the test case ("pass", "fail", "error", or "skipped").
+// - string: The status of the test case ("pass", "fail", "error", "skipped", or "flaky").
 // - *string: The message associated with the test case status, if any.
 // - *string: The type of the test case status, if any.
 func determineTestCaseStatus(testCase JUnitTestCase) (string, *string, *string) {
@@ -270,48 +394,228 @@ func determineTestCaseStatus(testCase JUnitTestCase) (string, *string, *string)
 	var message *string
 	var testCaseType *string
 
-	if testCase.Failure != nil {
+	if len(testCase.Failures) > 0 {
 		status = "fail"
-		message = &testCase.Failure.Message
-		testCaseType = &testCase.Failure.Type
-	} else if testCase.Error != nil {
+		message = &testCase.Failures[0].Message
+		testCaseType = &testCase.Failures[0].Type
+	} else if len(testCase.Errors) > 0 {
 		status = "error"
-		message = &testCase.Error.Message
-		testCaseType = &testCase.Error.Type
+		message = &testCase.Errors[0].Message
+		testCaseType = &testCase.Errors[0].Type
 	} else if testCase.Skipped != nil {
 		status = "skipped"
 		message = &testCase.Skipped.Message
 		testCaseType = nil
+	} else if len(testCase.FlakyFailures) > 0 {
+		status = "flaky"
+		message = &testCase.FlakyFailures[0].Message
+		testCaseType = &testCase.FlakyFailures[0].Type
+	} else if len(testCase.FlakyErrors) > 0 {
+		status = "flaky"
+		message = &testCase.FlakyErrors[0].Message
+		testCaseType = &testCase.FlakyErrors[0].Type
 	}
 
 	return status, message, testCaseType
 }
 
-// createAndSaveTestCaseProperties creates and saves Property models for the given properties and testCaseID.
-// It iterates over the provided properties, creates a Property model for each, and saves it to the database.
+// createTestCaseFailures creates TestCaseFailure models for every failure and error of the given test case.
+// All failures are numbered first and the errors after them, each in document order, so a failure
+// following an error in the report still comes first. Both keep the element text as their body.
+//
+// Parameters:
+// - testCase: The JUnitTestCase containing the failures and errors.
+// - testCaseID: The ID of the associated test case.
+//
+// Returns:
+// - []tables.TestCaseFailure: The created TestCaseFailure models.
+func createTestCaseFailures(testCase JUnitTestCase, testCaseID string) []tables.TestCaseFailure {
+	failureModels := make([]tables.TestCaseFailure, 0, len(testCase.Failures)+len(testCase.Errors))
+	for _, failure := range testCase.Failures {
+		failureModels = append(failureModels, tables.TestCaseFailure{
+			ID:         db.GenerateUniqueID(),
+			TestCaseID: testCaseID,
+			Kind:       "failure",
+			Position:   len(failureModels),
+			Message:    failure.Message,
+			Type:       failure.Type,
+			Body:       trimLeadingWhitespace(failure.Text),
+		})
+	}
+	for _, testCaseError := range testCase.Errors {
+		failureModels = append(failureModels, tables.TestCaseFailure{
+			ID:         db.GenerateUniqueID(),
+			TestCaseID: testCaseID,
+			Kind:       "error",
+			Position:   len(failureModels),
+			Message:    testCaseError.Message,
+			Type:       testCaseError.Type,
+			Body:       trimLeadingWhitespace(testCaseError.Text),
+		})
+	}
+	return failureModels
+}
+
+// createTestCaseReruns creates TestCaseRerun models for every flaky and rerun attempt of the given test case.
+// Attempts are numbered from 1 in the order flakyFailure, flakyError, rerunFailure, rerunError.
+//
+// Parameters:
+// - testCase: The JUnitTestCase containing the rerun attempts.
+// - testCaseID: The ID of the associated test case.
+//
+// Returns:
+// - []tables.TestCaseRerun: The created TestCaseRerun models.
+func createTestCaseReruns(testCase JUnitTestCase, testCaseID string) []tables.TestCaseRerun {
+	var rerunModels []tables.TestCaseRerun
+	for _, group := range []struct {
+		kind   string
+		reruns []Rerun
+	}{
+		{"flakyFailure", testCase.FlakyFailures},
+		{"flakyError", testCase.FlakyErrors},
+		{"rerunFailure", testCase.RerunFailures},
+		{"rerunError", testCase.RerunErrors},
+	} {
+		for _, rerun := range group.reruns {
+			stackTrace := rerun.StackTrace
+			if strings.TrimSpace(stackTrace) == "" {
+				stackTrace = rerun.Text
+			}
+			rerunModels = append(rerunModels, tables.TestCaseRerun{
+				ID:          db.GenerateUniqueID(),
+				TestCaseID:  testCaseID,
+				Kind:        group.kind,
+				Attempt:     len(rerunModels) + 1,
+				Message:     rerun.Message,
+				Type:        rerun.Type,
+				StackTrace:  trimLeadingWhitespace(stackTrace),
+				SystemOutID: rerun.systemOutID,
+				SystemErrID: rerun.systemErrID,
+				SystemOut:   rerun.SystemOut,
+				SystemErr:   rerun.SystemErr,
+			})
+		}
+	}
+	return rerunModels
+}
+
+// createTestCaseProperties creates Property models for the given properties and testCaseID.
+// It iterates over the provided properties and creates a Property model for each.
 //
 // Parameters:
 // - properties: A slice of Property structs containing the data for each property.
 // - testCaseID: The ID of the associated test case.
-// - dbOps: The DatabaseOperations interface for interacting with the database.
 //
 // Returns:
-// - error: An error if there is any issue during the creation or saving of the properties.
-func createAndSaveTestCaseProperties(properties []Property, testCaseID string, dbOps db.DatabaseOperations) error {
+// - []tables.Property: The created Property models.
+func createTestCaseProperties(properties []Property, testCaseID string) []tables.Property {
+	propertyModels := make([]tables.Property, 0, len(properties))
 	for _, property := range properties {
 		value := property.Value
 		if value == "" {
 			value = trimLeadingWhitespace(property.Text)
 		}
-		propertyModel := tables.Property{
+		propertyModels = append(propertyModels, tables.Property{
 			ID:         db.GenerateUniqueID(),
 			TestCaseID: &testCaseID,
 			Name:       property.Name,
 			Value:      value,
+		})
+	}
+	return propertyModels
+}
+
+// createTestCaseSteps creates TestCaseStep models for the given steps and testCaseID.
+//
+// Parameters:
+// - steps: The steps of the test case, in execution order.
+// - testCaseID: The ID of the associated test case.
+//
+// Returns:
+// - []tables.TestCaseStep: The created TestCaseStep models.
+func createTestCaseSteps(steps []Step, testCaseID string) []tables.TestCaseStep {
+	stepModels := make([]tables.TestCaseStep, 0, len(steps))
+	for i, step := range steps {
+		stepModels = append(stepModels, tables.TestCaseStep{
+			ID:         db.GenerateUniqueID(),
+			TestCaseID: testCaseID,
+			Position:   i,
+			Keyword:    step.Keyword,
+			Text:       step.Text,
+			Line:       step.Line,
+			Status:     step.Status,
+			Duration:   step.Duration,
+			Error:      step.Error,
+		})
+	}
+	return stepModels
+}
+
+// attachmentReference matches a [[ATTACHMENT|path]] reference, as written to the output of a test
+// case by the JUnit Attachments plugin convention.
+var attachmentReference = regexp.MustCompile(`\[\[ATTACHMENT\|([^\]\r\n]+)\]\]`)
+
+// createTestCaseAttachments creates Attachment models for the files referenced in the output of a
+// test case. The attachments have no content yet; it is uploaded separately for the referenced path.
+//
+// Parameters:
+// - testCase: The JUnitTestCase whose system-out and system-err are searched for references. For logs
+// read in pieces, the references found while reading them are used.
+// - testCaseID: The ID of the associated test case.
+//
+// Returns:
+// - []tables.Attachment: The created Attachment models, one per distinct referenced path.
+func createTestCaseAttachments(testCase JUnitTestCase, testCaseID string) []tables.Attachment {
+	referencedPaths := testCase.attachmentPaths
+	for _, output := range []string{testCase.SystemOut, testCase.SystemErr} {
+		for _, match := range attachmentReference.FindAllStringSubmatch(output, -1) {
+			referencedPaths = append(referencedPaths, match[1])
 		}
-		if err := dbOps.Create(&propertyModel); err != nil {
-			return err
+	}
+
+	var attachmentModels []tables.Attachment
+	seen := map[string]bool{}
+	for _, referencedPath := range referencedPaths {
+		referencedPath = strings.TrimSpace(referencedPath)
+		if referencedPath == "" || seen[referencedPath] {
+			continue
+		}
+		seen[referencedPath] = true
+		attachmentModels = append(attachmentModels, tables.Attachment{
+			ID:         db.GenerateUniqueID(),
+			TestCaseID: testCaseID,
+			Name:       path.Base(strings.ReplaceAll(referencedPath, "\\", "/")),
+			Path:       referencedPath,
+			CreatedAt:  time.Now().UTC(),
+		})
+	}
+	return attachmentModels
+}
+
+// attachmentScanOverlap is how many bytes at the end of a piece of a log are searched again together
+// with the next piece, so attachment references split across pieces are found. Longer references are missed.
+const attachmentScanOverlap = 4096
+
+// attachmentScanner collects the attachment references of a log written to it in pieces.
+type attachmentScanner struct {
+	overlap []byte   // End of the previous piece
+	paths   []string // Referenced paths in the order they were found, possibly repeated
+}
+
+// Write searches a piece of the log for attachment references.
+func (scanner *attachmentScanner) Write(piece []byte) (int, error) {
+	// References starting in the previous piece; those lying in it entirely were found already and
+	// are dropped as duplicates later.
+	joined := append(scanner.overlap, piece[:min(len(piece), attachmentScanOverlap)]...)
+	for _, text := range [][]byte{joined, piece} {
+		for _, match := range attachmentReference.FindAllSubmatch(text, -1) {
+			scanner.paths = append(scanner.paths, string(match[1]))
 		}
 	}
-	return nil
+	if len(piece) >= attachmentScanOverlap {
+		scanner.overlap = append(scanner.overlap[:0], piece[len(piece)-attachmentScanOverlap:]...)
+	} else {
+		scanner.overlap = append(scanner.overlap[:0], joined[max(0, len(joined)-attachmentScanOverlap):]...)
+	}
+	return len(piece), nil
 }
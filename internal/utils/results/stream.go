@@ -0,0 +1,407 @@
+package results
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hypha/api/internal/db/tables"
+	"io"
+	"strings"
+)
+
+// openSuite is a <testsuite> element whose end has not been read yet.
+type openSuite struct {
+	suite    JUnitTestSuite
+	model    tables.TestSuite
+	declared totalAttributes // Totals given as attributes of the element
+}
+
+// AddJUnit decodes a JUnit XML report from reader and adds it to the ingestion.
+//
+// The report is read with a streaming decoder: test cases are handed to the ingestion as soon as
+// they are decoded and only the attributes, properties and output of the suites that are currently
+// open are kept in memory. Both <testsuites> and bare <testsuite> root elements are accepted, and
+// nested suites reference the suite that contains them.
+//
+// Parameters:
+// - reader: The reader providing the JUnit XML report.
+//
+// Returns:
+// - error: A *ParseError if the report is not JUnit XML, or an error if saving the models fails.
+func (ingestion *Ingestion) AddJUnit(reader io.Reader) error {
+	decoder := xml.NewDecoder(reader)
+
+	var root JUnitTestSuites
+	var open []*openSuite
+	sawRoot := false
+	// A total is declared by the report if the root element declares it or all of its suites do
+	var rootDeclared totalAttributes
+	suitesDeclared := allTotals
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &ParseError{Err: err}
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if !sawRoot {
+				sawRoot = true
+				if element.Name.Local == "testsuites" {
+					if err := decodeAttributes(element, &root); err != nil {
+						return &ParseError{Err: err}
+					}
+					rootDeclared = declaredTotals(element)
+					continue
+				}
+				if element.Name.Local == "html" {
+					return &ParseError{Err: errors.New("report is an HTML page, not a test report")}
+				}
+				if element.Name.Local != "testsuite" {
+					return &ParseError{Err: fmt.Errorf("unexpected root element <%s>", element.Name.Local)}
+				}
+			}
+			if element.Name.Local == "testsuite" {
+				suite, err := ingestion.openTestSuite(element, open)
+				if err != nil {
+					return err
+				}
+				open = append(open, suite)
+				continue
+			}
+			if len(open) == 0 {
+				if err := decoder.Skip(); err != nil {
+					return &ParseError{Err: err}
+				}
+				continue
+			}
+			if err := ingestion.decodeSuiteChild(decoder, element, open[len(open)-1]); err != nil {
+				return err
+			}
+
+		case xml.EndElement:
+			if element.Name.Local != "testsuite" || len(open) == 0 {
+				continue
+			}
+			suite := open[len(open)-1]
+			open = open[:len(open)-1]
+			if err := ingestion.closeTestSuite(suite); err != nil {
+				return err
+			}
+			if len(open) == 0 {
+				suitesDeclared &= suite.declared
+				root.TestSuites = append(root.TestSuites, JUnitTestSuite{
+					Tests:      suite.suite.Tests,
+					Failures:   suite.suite.Failures,
+					Errors:     suite.suite.Errors,
+					Skipped:    suite.suite.Skipped,
+					Assertions: suite.suite.Assertions,
+					Time:       suite.suite.Time,
+					Timestamp:  suite.suite.Timestamp,
+				})
+			}
+		}
+	}
+
+	if !sawRoot {
+		return &ParseError{Err: errors.New("report has no root element")}
+	}
+	if len(root.TestSuites) == 0 {
+		suitesDeclared = 0
+	}
+	addReportTotals(&ingestion.result, root)
+	ingestion.report.undeclared = allTotals &^ (rootDeclared | suitesDeclared)
+	return ingestion.flushIfFull()
+}
+
+// declaredTotals returns the totals given as attributes of a <testsuites> or <testsuite> element.
+func declaredTotals(element xml.StartElement) totalAttributes {
+	var declared totalAttributes
+	for _, attribute := range element.Attr {
+		switch attribute.Name.Local {
+		case "tests":
+			declared |= totalTests
+		case "failures":
+			declared |= totalFailures
+		case "errors":
+			declared |= totalErrors
+		case "skipped":
+			declared |= totalSkipped
+		}
+	}
+	return declared
+}
+
+// openTestSuite creates the TestSuite model for a <testsuite> start element from its attributes.
+//
+// Parameters:
+// - element: The <testsuite> start element.
+// - open: The suites that are currently open; the last one becomes the parent of the new suite.
+//
+// Returns:
+// - *openSuite: The opened suite.
+// - error: A *ParseError if the attributes are invalid, or an error if creating the model fails.
+func (ingestion *Ingestion) openTestSuite(element xml.StartElement, open []*openSuite) (*openSuite, error) {
+	var suite JUnitTestSuite
+	if err := decodeAttributes(element, &suite); err != nil {
+		return nil, &ParseError{Err: err}
+	}
+
+	var parentID *string
+	if len(open) > 0 {
+		parentID = &open[len(open)-1].model.ID
+	}
+	model, err := createTestSuiteModel(suite, ingestion.result.ID, parentID)
+	if err != nil {
+		return nil, err
+	}
+	return &openSuite{suite: suite, model: model, declared: declaredTotals(element)}, nil
+}
+
+// decodeSuiteChild decodes a child element of an open suite.
+// Test cases and output are added to the ingestion right away; properties are kept on the suite
+// until it is closed. Unknown elements are skipped.
+//
+// Parameters:
+// - decoder: The decoder positioned right after the child's start element.
+// - element: The child's start element.
+// - suite: The suite containing the child.
+//
+// Returns:
+// - error: A *ParseError if the element cannot be decoded, or an error if saving the models fails.
+func (ingestion *Ingestion) decodeSuiteChild(decoder *xml.Decoder, element xml.StartElement, suite *openSuite) error {
+	switch element.Name.Local {
+	case "testcase":
+		testCase, err := ingestion.decodeTestCase(decoder, element)
+		if err != nil {
+			return &ParseError{Err: err}
+		}
+		if err := ingestion.batch.addTestCases([]JUnitTestCase{testCase}, suite.model.ID); err != nil {
+			return err
+		}
+		return ingestion.flushIfFull()
+	case "properties":
+		var properties struct {
+			Properties []Property `xml:"property"`
+		}
+		if err := decoder.DecodeElement(&properties, &element); err != nil {
+			return &ParseError{Err: err}
+		}
+		suite.suite.Properties = append(suite.suite.Properties, properties.Properties...)
+	case "system-out", "system-err":
+		id, err := ingestion.readOutput(decoder, nil)
+		if err != nil {
+			return &ParseError{Err: err}
+		}
+		if element.Name.Local == "system-out" {
+			suite.model.SystemOutID = id
+		} else {
+			suite.model.SystemErrID = id
+		}
+	default:
+		if err := decoder.Skip(); err != nil {
+			return &ParseError{Err: err}
+		}
+	}
+	return nil
+}
+
+// decodeTestCase decodes a <testcase> element child by child, so its system-out and system-err are
+// read into capped Output models instead of strings. Unknown elements are skipped.
+//
+// Parameters:
+// - decoder: The decoder positioned right after the test case's start element.
+// - element: The test case's start element.
+//
+// Returns:
+// - JUnitTestCase: The decoded test case, referencing its output by ID.
+// - error: An error if the element cannot be decoded.
+func (ingestion *Ingestion) decodeTestCase(decoder *xml.Decoder, element xml.StartElement) (JUnitTestCase, error) {
+	var testCase JUnitTestCase
+	if err := decodeAttributes(element, &testCase); err != nil {
+		return testCase, err
+	}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return testCase, err
+		}
+		child, ok := token.(xml.StartElement)
+		if !ok {
+			if _, ok := token.(xml.EndElement); ok {
+				return testCase, nil
+			}
+			continue
+		}
+
+		switch child.Name.Local {
+		case "failure":
+			var failure Failure
+			err = decoder.DecodeElement(&failure, &child)
+			testCase.Failures = append(testCase.Failures, failure)
+		case "error":
+			var testCaseError Error
+			err = decoder.DecodeElement(&testCaseError, &child)
+			testCase.Errors = append(testCase.Errors, testCaseError)
+		case "skipped":
+			testCase.Skipped = &Skipped{}
+			err = decoder.DecodeElement(testCase.Skipped, &child)
+		case "flakyFailure", "flakyError", "rerunFailure", "rerunError":
+			var rerun Rerun
+			rerun, err = ingestion.decodeRerun(decoder, child)
+			switch child.Name.Local {
+			case "flakyFailure":
+				testCase.FlakyFailures = append(testCase.FlakyFailures, rerun)
+			case "flakyError":
+				testCase.FlakyErrors = append(testCase.FlakyErrors, rerun)
+			case "rerunFailure":
+				testCase.RerunFailures = append(testCase.RerunFailures, rerun)
+			default:
+				testCase.RerunErrors = append(testCase.RerunErrors, rerun)
+			}
+		case "properties":
+			var properties struct {
+				Properties []Property `xml:"property"`
+			}
+			err = decoder.DecodeElement(&properties, &child)
+			testCase.Properties = append(testCase.Properties, properties.Properties...)
+		case "system-out":
+			testCase.systemOutID, err = ingestion.readOutput(decoder, &testCase.attachmentPaths)
+		case "system-err":
+			testCase.systemErrID, err = ingestion.readOutput(decoder, &testCase.attachmentPaths)
+		default:
+			err = decoder.Skip()
+		}
+		if err != nil {
+			return testCase, err
+		}
+	}
+}
+
+// decodeRerun decodes a rerun attempt of a test case child by child, so its system-out and
+// system-err are read into capped Output models instead of strings.
+//
+// Parameters:
+// - decoder: The decoder positioned right after the attempt's start element.
+// - element: The attempt's start element, such as <flakyFailure>.
+//
+// Returns:
+// - Rerun: The decoded attempt, referencing its output by ID.
+// - error: An error if the element cannot be decoded.
+func (ingestion *Ingestion) decodeRerun(decoder *xml.Decoder, element xml.StartElement) (Rerun, error) {
+	var rerun Rerun
+	if err := decodeAttributes(element, &rerun); err != nil {
+		return rerun, err
+	}
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return rerun, err
+		}
+		switch token := token.(type) {
+		case xml.CharData:
+			text.Write(token)
+		case xml.EndElement:
+			rerun.Text = text.String()
+			return rerun, nil
+		case xml.StartElement:
+			switch token.Name.Local {
+			case "stackTrace":
+				err = decoder.DecodeElement(&rerun.StackTrace, &token)
+			case "system-out":
+				rerun.systemOutID, err = ingestion.readOutput(decoder, nil)
+			case "system-err":
+				rerun.systemErrID, err = ingestion.readOutput(decoder, nil)
+			default:
+				err = decoder.Skip()
+			}
+			if err != nil {
+				return rerun, err
+			}
+		}
+	}
+}
+
+// readOutput reads the text of a <system-out> or <system-err> element as the decoder returns it and
+// adds it to the ingestion as an Output model. Only as much of the log as the maximum output size
+// allows is kept in memory. Nested elements are skipped, as when decoding the element into a string.
+//
+// Parameters:
+// - decoder: The decoder positioned right after the element's start element.
+// - attachmentPaths: If not nil, the attachment references found in the log are appended to it.
+//
+// Returns:
+// - *string: The ID of the Output model, or nil if the element is empty.
+// - error: An error if the element cannot be read or the log cannot be compressed.
+func (ingestion *Ingestion) readOutput(decoder *xml.Decoder, attachmentPaths *[]string) (*string, error) {
+	writer := newOutputWriter(ingestion.upload.Output)
+	var scanner attachmentScanner
+	for depth := 0; ; {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch token := token.(type) {
+		case xml.CharData:
+			if depth == 0 {
+				writer.Write(token)
+				if attachmentPaths != nil {
+					scanner.Write(token)
+				}
+			}
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+		if depth < 0 {
+			break
+		}
+	}
+	if attachmentPaths != nil {
+		*attachmentPaths = append(*attachmentPaths, scanner.paths...)
+	}
+	output, err := writer.output()
+	if err != nil {
+		return nil, err
+	}
+	return ingestion.batch.addOutput(output), nil
+}
+
+// closeTestSuite adds a suite whose end element has been read, and its properties, to the ingestion.
+func (ingestion *Ingestion) closeTestSuite(suite *openSuite) error {
+	ingestion.batch.testSuites = append(ingestion.batch.testSuites, suite.model)
+	ingestion.batch.properties = append(ingestion.batch.properties, createProperties(suite.suite.Properties, suite.model.ID)...)
+	return ingestion.flushIfFull()
+}
+
+// tokenList is an xml.TokenReader over a fixed list of tokens.
+type tokenList []xml.Token
+
+// Token returns the next token of the list, or io.EOF once all tokens were returned.
+func (list *tokenList) Token() (xml.Token, error) {
+	if len(*list) == 0 {
+		return nil, io.EOF
+	}
+	token := (*list)[0]
+	*list = (*list)[1:]
+	return token, nil
+}
+
+// decodeAttributes decodes the attributes of a start element into v without reading the element's content.
+//
+// Parameters:
+// - element: The start element whose attributes are decoded.
+// - v: A pointer to the struct receiving the attributes.
+//
+// Returns:
+// - error: An error if an attribute cannot be converted to the type of its field.
+func decodeAttributes(element xml.StartElement, v interface{}) error {
+	tokens := tokenList{element, element.End()}
+	return xml.NewTokenDecoder(&tokens).Decode(v)
+}
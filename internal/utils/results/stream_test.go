@@ -0,0 +1,133 @@
+package results
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// junitNested is a JUnit report with nested suites, suite output and test cases of every kind.
+const junitNested = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="shop">
+  <testsuite name="Shop" timestamp="2024-05-01T10:00:00Z">
+    <properties><property name="browser" value="firefox"/></properties>
+    <testsuite name="Cart" tests="5">
+      <testcase classname="shop.Cart" name="adds an item" time="0.25">
+        <properties><property name="owner" value="team-a"/></properties>
+        <system-out>took a screenshot [[ATTACH<![CDATA[MENT|shots/cart.png]]>]]</system-out>
+      </testcase>
+      <testcase classname="shop.Cart" name="computes the total">
+        <failure message="expected 1" type="AssertionError">at cart.js:20</failure>
+        <error message="cleanup failed" type="IOError"/>
+      </testcase>
+      <testcase classname="shop.Cart" name="charges the card">
+        <flakyFailure message="timed out" type="TimeoutError">
+          <stackTrace>at card.js:10</stackTrace>
+          <system-out>first attempt</system-out>
+        </flakyFailure>
+        <system-out>took a screenshot</system-out>
+      </testcase>
+      <testcase classname="shop.Cart" name="refunds"><skipped message="not ready"/></testcase>
+      <testcase classname="shop.Cart" name="pays later"><system-err>warning: <b>ignored</b>deprecated</system-err></testcase>
+      <system-out>cart suite log</system-out>
+    </testsuite>
+  </testsuite>
+</testsuites>`
+
+func TestAddJUnitNested(t *testing.T) {
+	store, ingestion := readReport(t, "", "junit.xml", junitNested)
+	if report := onlyReport(t, ingestion); report.Format != FormatJUnit {
+		t.Errorf("format = %q, want %q", report.Format, FormatJUnit)
+	}
+
+	if got := strings.Join(store.suiteNames(), ","); got != "Cart,Shop" {
+		t.Errorf("suites = %s, want Cart written before the suite containing it", got)
+	}
+	shop := store.suite(t, "Shop")
+	cart := store.suite(t, "Cart")
+	if cart.ParentID == nil || *cart.ParentID != shop.ID || shop.ParentID != nil {
+		t.Errorf("parents = %v and %v, want Cart nested in Shop", cart.ParentID, shop.ParentID)
+	}
+	if store.output(t, cart.SystemOutID) != "cart suite log" {
+		t.Errorf("Cart system-out = %q, want the suite log", store.output(t, cart.SystemOutID))
+	}
+
+	wantCases := "adds an item=pass,computes the total=fail,charges the card=flaky,refunds=skipped,pays later=pass"
+	if got := strings.Join(store.caseStatuses(), ","); got != wantCases {
+		t.Errorf("test cases = %s, want %s", got, wantCases)
+	}
+
+	add := store.testCase(t, "adds an item")
+	if len(store.attachments) != 1 || store.attachments[0].TestCaseID != add.ID || store.attachments[0].Path != "shots/cart.png" {
+		t.Errorf("attachments = %+v, want the reference split across the text of the output", store.attachments)
+	}
+	var properties []string
+	for _, property := range store.properties {
+		properties = append(properties, property.Name+"="+property.Value)
+	}
+	if got := strings.Join(properties, ","); got != "owner=team-a,browser=firefox" {
+		t.Errorf("properties = %s, want those of the test case and of the Shop suite", got)
+	}
+
+	total := store.testCase(t, "computes the total")
+	var failures []string
+	for _, failure := range store.failuresOf(total.ID) {
+		failures = append(failures, failure.Message+"/"+failure.Type+"/"+failure.Body)
+	}
+	if got := strings.Join(failures, ","); got != "expected 1/AssertionError/at cart.js:20,cleanup failed/IOError/" {
+		t.Errorf("failures of computes the total = %s, want the failure then the error", got)
+	}
+
+	card := store.testCase(t, "charges the card")
+	if len(store.reruns) != 1 {
+		t.Fatalf("reruns = %+v, want one", store.reruns)
+	}
+	rerun := store.reruns[0]
+	if rerun.TestCaseID != card.ID || rerun.Kind != "flakyFailure" || rerun.Attempt != 1 || rerun.Message != "timed out" ||
+		rerun.StackTrace != "at card.js:10" || store.output(t, rerun.SystemOutID) != "first attempt" {
+		t.Errorf("rerun = %+v, want the flaky failure of charges the card with its stack trace and output", rerun)
+	}
+	if card.SystemOutID == nil || add.SystemOutID == nil || *card.SystemOutID == *add.SystemOutID {
+		t.Errorf("system-out IDs = %v and %v, want the different logs stored apart", card.SystemOutID, add.SystemOutID)
+	}
+
+	if refunds := store.testCase(t, "refunds"); refunds.Message == nil || *refunds.Message != "not ready" {
+		t.Errorf("refunds message = %v, want the skip message", refunds.Message)
+	}
+	if later := store.testCase(t, "pays later"); store.output(t, later.SystemErrID) != "warning: deprecated" {
+		t.Errorf("pays later system-err = %q, want the text without nested elements", store.output(t, later.SystemErrID))
+	}
+}
+
+func TestAddJUnitTruncatesLargeOutput(t *testing.T) {
+	log := "BEGIN " + strings.Repeat("x", 5000) + strings.Repeat("y", 5000) + " END"
+	// The CDATA section splits the log into several pieces, as the decoder returns them
+	report := `<testsuite name="Cart"><testcase name="adds an item"><system-out>` + log[:5000] +
+		`<![CDATA[` + log[5000:5010] + `]]>` + log[5010:] + `</system-out></testcase>` +
+		`<testcase name="adds another item"><system-out>` + log + `</system-out></testcase></testsuite>`
+	upload := Upload{ProductID: "product", Output: OutputOptions{MaxSize: 64}}
+	store, _ := readUpload(t, upload, "junit.xml", report)
+
+	if len(store.outputs) != 1 {
+		t.Fatalf("stored %d outputs, want the same log once", len(store.outputs))
+	}
+	output := store.outputs[0]
+	hash := sha256.Sum256([]byte(log))
+	if output.ID != hex.EncodeToString(hash[:]) {
+		t.Errorf("output ID = %s, want the SHA-256 of the whole log", output.ID)
+	}
+	if output.Size != int64(len(log)) || !output.Truncated || output.StoredSize != int64(len(output.Content)) || output.StoredSize > 64 {
+		t.Errorf("output = size %d, stored %d, truncated %t, want size %d truncated to at most 64 bytes",
+			output.Size, output.StoredSize, output.Truncated, len(log))
+	}
+	content := string(output.Content)
+	if !strings.HasPrefix(content, "BEGIN x") || !strings.HasSuffix(content, "y END") || !strings.Contains(content, "bytes truncated") {
+		t.Errorf("content = %q, want the beginning and end of the log around the truncation marker", content)
+	}
+	for _, testCase := range store.cases {
+		if testCase.SystemOutID == nil || *testCase.SystemOutID != output.ID {
+			t.Errorf("%s system-out = %v, want %s", testCase.Name, testCase.SystemOutID, output.ID)
+		}
+	}
+}
@@ -15,49 +15,94 @@ type JUnitTestSuites struct {
 
 // JUnitTestSuite represents a single JUnit test suite.
 type JUnitTestSuite struct {
-	ID         string          `xml:"id,attr"`
-	Name       string          `xml:"name,attr"`
-	Tests      int             `xml:"tests,attr"`
-	Failures   int             `xml:"failures,attr"`
-	Errors     int             `xml:"errors,attr"`
-	Skipped    int             `xml:"skipped,attr"`
-	Assertions int             `xml:"assertions,attr"`
-	Time       float64         `xml:"time,attr"`
-	File       string          `xml:"file,attr"`
-	TestCases  []JUnitTestCase `xml:"testcase"`
-	Properties []Property      `xml:"properties>property"`
-	SystemOut  string          `xml:"system-out,omitempty"`
-	SystemErr  string          `xml:"system-err,omitempty"`
+	ID         string           `xml:"id,attr"`
+	Name       string           `xml:"name,attr"`
+	Tests      int              `xml:"tests,attr"`
+	Failures   int              `xml:"failures,attr"`
+	Errors     int              `xml:"errors,attr"`
+	Skipped    int              `xml:"skipped,attr"`
+	Assertions int              `xml:"assertions,attr"`
+	Time       float64          `xml:"time,attr"`
+	File       string           `xml:"file,attr"`
+	Timestamp  string           `xml:"timestamp,attr"`
+	Hostname   string           `xml:"hostname,attr"`
+	TestCases  []JUnitTestCase  `xml:"testcase"`
+	TestSuites []JUnitTestSuite `xml:"testsuite"` // Nested suites, as written by Ant, Bazel and some pytest plugins
+	Properties []Property       `xml:"properties>property"`
+	SystemOut  string           `xml:"system-out,omitempty"`
+	SystemErr  string           `xml:"system-err,omitempty"`
 }
 
 // JUnitTestCase represents a single JUnit test case.
 type JUnitTestCase struct {
-	ID         string     `xml:"id,attr"`
-	ClassName  string     `xml:"classname,attr"`
-	Name       string     `xml:"name,attr"`
-	Time       float64    `xml:"time,attr"`
-	Assertions int        `xml:"assertions,attr"`
-	File       string     `xml:"file,attr"`
-	Line       int        `xml:"line,attr"`
-	Status     string     `xml:"-"`
-	Failure    *Failure   `xml:"failure,omitempty"`
-	Error      *Error     `xml:"error,omitempty"`
-	Skipped    *Skipped   `xml:"skipped,omitempty"`
-	Properties []Property `xml:"properties>property"`
-	SystemOut  string     `xml:"system-out,omitempty"`
-	SystemErr  string     `xml:"system-err,omitempty"`
+	ID            string     `xml:"id,attr"`
+	ClassName     string     `xml:"classname,attr"`
+	Name          string     `xml:"name,attr"`
+	Time          float64    `xml:"time,attr"`
+	Assertions    int        `xml:"assertions,attr"`
+	File          string     `xml:"file,attr"`
+	Line          int        `xml:"line,attr"`
+	Status        string     `xml:"-"`
+	Failures      []Failure  `xml:"failure,omitempty"`
+	Errors        []Error    `xml:"error,omitempty"`
+	Skipped       *Skipped   `xml:"skipped,omitempty"`
+	FlakyFailures []Rerun    `xml:"flakyFailure,omitempty"`
+	FlakyErrors   []Rerun    `xml:"flakyError,omitempty"`
+	RerunFailures []Rerun    `xml:"rerunFailure,omitempty"`
+	RerunErrors   []Rerun    `xml:"rerunError,omitempty"`
+	Properties    []Property `xml:"properties>property"`
+	SystemOut     string     `xml:"system-out,omitempty"`
+	SystemErr     string     `xml:"system-err,omitempty"`
+	Steps         []Step     `xml:"-"` // Steps of behaviour-driven formats such as Cucumber
+
+	// IDs of the Output models of logs read in pieces by the streaming JUnit parser, which leaves
+	// SystemOut and SystemErr empty, and the attachment references found in those logs
+	systemOutID     *string
+	systemErrID     *string
+	attachmentPaths []string
+}
+
+// Step represents a step of a behaviour-driven test case. JUnit reports have no steps;
+// they are filled in by parsers of formats such as Cucumber JSON.
+type Step struct {
+	Keyword  string
+	Text     string
+	Line     int
+	Status   string
+	Duration float64 // In seconds
+	Error    string
 }
 
 // Failure represents a failure in a JUnit test case.
+// The element text usually holds the stack trace.
 type Failure struct {
 	Message string `xml:"message,attr"`
 	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
 }
 
 // Error represents an error in a JUnit test case.
+// The element text usually holds the stack trace.
 type Error struct {
 	Message string `xml:"message,attr"`
 	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// Rerun represents a failed attempt of a test case that was run again, as reported by Maven Surefire
+// and Gradle in <flakyFailure>, <flakyError>, <rerunFailure> and <rerunError> elements.
+// Surefire writes the stack trace to a <stackTrace> child, other tools use the element text.
+type Rerun struct {
+	Message    string `xml:"message,attr"`
+	Type       string `xml:"type,attr"`
+	StackTrace string `xml:"stackTrace"`
+	Text       string `xml:",chardata"`
+	SystemOut  string `xml:"system-out,omitempty"`
+	SystemErr  string `xml:"system-err,omitempty"`
+
+	// IDs of the Output models of logs read in pieces by the streaming JUnit parser
+	systemOutID *string
+	systemErrID *string
 }
 
 // Skipped represents a skipped JUnit test case.
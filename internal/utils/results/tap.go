@@ -0,0 +1,242 @@
+package results
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tapTestLine matches a TAP test line such as "not ok 2 - description # TODO reason".
+var tapTestLine = regexp.MustCompile(`^(not ok|ok)\b\s*(\d+)?\s*(?:-\s*)?(.*)$`)
+
+// tapPlan matches a TAP plan such as "1..4" or "1..0 # SKIP no tests".
+var tapPlan = regexp.MustCompile(`^1\.\.(\d+)\s*(?:#\s*(.*))?$`)
+
+// tapDirective matches the SKIP or TODO directive at the end of a TAP test line.
+var tapDirective = regexp.MustCompile(`(?i)(?:^|\s)#\s*(skip\S*|todo)\b\s*(.*)$`)
+
+// tapTest is a TAP test line whose YAML diagnostics may not have been read yet.
+type tapTest struct {
+	number      int
+	ok          bool
+	description string
+	directive   string // SKIP or TODO, if any
+	reason      string // Text following the directive
+	diagnostics []string
+}
+
+// TAPDiagnostics represents the fields of a TAP v13 YAML diagnostics block used by the ingestion.
+// Any other fields are kept in the failure body together with the block itself.
+type TAPDiagnostics struct {
+	Message    string  `yaml:"message"`
+	Severity   string  `yaml:"severity"`
+	DurationMS float64 `yaml:"duration_ms"`
+}
+
+// AddTAP decodes a TAP (Test Anything Protocol) v13 report from reader and adds it to the ingestion
+// as a single test suite named after the report.
+//
+// Every "ok" or "not ok" line becomes a test case: "ok" lines pass and "not ok" lines fail. Tests
+// with a SKIP directive are skipped, and failing tests with a TODO directive are recorded as
+// skipped since TAP does not count them as failures. The message of a YAML diagnostics block
+// becomes the failure message, the block itself the failure body, and its duration_ms the test time.
+// A diagnostics block that is not valid YAML is kept as the failure body and reported as a warning.
+// "Bail out!" and tests missing from the plan are recorded as errors; running fewer or more tests
+// than planned is reported as a warning.
+//
+// Parameters:
+// - name: The name of the report, used as the name of the suite.
+// - reader: The reader providing the TAP report.
+//
+// Returns:
+// - error: A *ParseError if the report contains no plan or test lines, or an error if saving the models fails.
+func (ingestion *Ingestion) AddTAP(name string, reader io.Reader) error {
+	suite := JUnitTestSuite{Name: name}
+	model, err := createTestSuiteModel(suite, ingestion.result.ID, nil)
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewReader(reader)
+	var current *tapTest
+	var output strings.Builder
+	inDiagnostics := false
+	planned := -1
+	sawTAP := false
+	count := 0
+
+	addTestCase := func(testCase JUnitTestCase) error {
+		countTestCase(&suite, testCase)
+		if err := ingestion.batch.addTestCases([]JUnitTestCase{testCase}, model.ID); err != nil {
+			return err
+		}
+		return ingestion.flushIfFull()
+	}
+	addTest := func(test *tapTest) error {
+		return addTestCase(ingestion.createTAPTestCase(name, test))
+	}
+
+	for {
+		line, readErr := buffered.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return &ParseError{Err: readErr}
+		}
+		line = strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case inDiagnostics:
+			if trimmed == "..." {
+				inDiagnostics = false
+			} else {
+				current.diagnostics = append(current.diagnostics, line)
+			}
+		case current != nil && trimmed == "---" && line != trimmed:
+			inDiagnostics = true
+		case tapTestLine.MatchString(trimmed) && line == trimmed:
+			if current != nil {
+				if err := addTest(current); err != nil {
+					return err
+				}
+			}
+			sawTAP = true
+			count++
+			current = parseTAPTestLine(trimmed, count)
+		case tapPlan.MatchString(trimmed) && line == trimmed:
+			sawTAP = true
+			planned, _ = strconv.Atoi(tapPlan.FindStringSubmatch(trimmed)[1])
+		case strings.HasPrefix(trimmed, "Bail out!"):
+			sawTAP = true
+			if current != nil {
+				if err := addTest(current); err != nil {
+					return err
+				}
+				current = nil
+			}
+			testCase := JUnitTestCase{
+				ClassName: name,
+				Name:      "Bail out!",
+				Errors:    []Error{{Message: strings.TrimSpace(strings.TrimPrefix(trimmed, "Bail out!"))}},
+			}
+			if err := addTestCase(testCase); err != nil {
+				return err
+			}
+		case strings.HasPrefix(trimmed, "TAP version"):
+			sawTAP = true
+		case trimmed != "":
+			output.WriteString(line)
+			output.WriteByte('\n')
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if !sawTAP {
+		return &ParseError{Err: errors.New("report contains no TAP plan or test lines")}
+	}
+	if current != nil {
+		if err := addTest(current); err != nil {
+			return err
+		}
+	}
+	if planned >= 0 && count != planned {
+		ingestion.warn("planned %d tests but ran %d", planned, count)
+	}
+	if planned >= 0 && count < planned {
+		testCase := JUnitTestCase{
+			ClassName: name,
+			Name:      "[plan]",
+			Errors:    []Error{{Message: fmt.Sprintf("planned %d tests but ran %d", planned, count)}},
+		}
+		if err := addTestCase(testCase); err != nil {
+			return err
+		}
+	}
+
+	model.Tests = suite.Tests
+	model.Failures = suite.Failures
+	model.Errors = suite.Errors
+	model.Skipped = suite.Skipped
+	model.Time = suite.Time
+	model.SystemOut = output.String()
+	ingestion.batch.testSuites = append(ingestion.batch.testSuites, model)
+	addReportTotals(&ingestion.result, JUnitTestSuites{TestSuites: []JUnitTestSuite{suite}})
+	return ingestion.flushIfFull()
+}
+
+// parseTAPTestLine parses an "ok" or "not ok" line.
+//
+// Parameters:
+// - line: The test line, without leading whitespace.
+// - position: The 1-based position of the line, used when the line has no test number.
+//
+// Returns:
+// - *tapTest: The parsed test.
+func parseTAPTestLine(line string, position int) *tapTest {
+	match := tapTestLine.FindStringSubmatch(line)
+	test := &tapTest{number: position, ok: match[1] == "ok", description: match[3]}
+	if match[2] != "" {
+		test.number, _ = strconv.Atoi(match[2])
+	}
+
+	if directive := tapDirective.FindStringSubmatchIndex(test.description); directive != nil {
+		keyword := strings.ToUpper(test.description[directive[2]:directive[3]])
+		if strings.HasPrefix(keyword, "SKIP") {
+			test.directive = "SKIP"
+		} else {
+			test.directive = "TODO"
+		}
+		test.reason = strings.TrimSpace(test.description[directive[4]:directive[5]])
+		test.description = strings.TrimSpace(test.description[:directive[0]])
+	}
+	return test
+}
+
+// createTAPTestCase converts a TAP test into a JUnitTestCase.
+// A YAML diagnostics block that cannot be decoded is reported as a warning and kept as the failure body.
+//
+// Parameters:
+// - className: The class name of the test case, the name of the report.
+// - test: The TAP test.
+//
+// Returns:
+// - JUnitTestCase: The test case.
+func (ingestion *Ingestion) createTAPTestCase(className string, test *tapTest) JUnitTestCase {
+	name := test.description
+	if name == "" {
+		name = fmt.Sprintf("test %d", test.number)
+	}
+	testCase := JUnitTestCase{ClassName: className, Name: name}
+
+	var diagnostics TAPDiagnostics
+	body := trimLeadingWhitespace(strings.Join(test.diagnostics, "\n"))
+	if body != "" {
+		if err := yaml.Unmarshal([]byte(body), &diagnostics); err != nil {
+			ingestion.warn("test %d: invalid YAML diagnostics: %v", test.number, err)
+			diagnostics = TAPDiagnostics{}
+		}
+		testCase.Time = diagnostics.DurationMS / 1000
+	}
+
+	switch {
+	case test.directive == "SKIP":
+		testCase.Skipped = &Skipped{Message: test.reason}
+	case test.directive == "TODO" && !test.ok:
+		testCase.Skipped = &Skipped{Message: "TODO " + test.reason}
+	case !test.ok:
+		message := diagnostics.Message
+		if message == "" {
+			message = test.description
+		}
+		testCase.Failures = []Failure{{Message: message, Type: diagnostics.Severity, Text: body}}
+	}
+	return testCase
+}
@@ -0,0 +1,90 @@
+package results
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddTAPWithUnmetPlan(t *testing.T) {
+	const tap = `TAP version 13
+1..5
+# cart
+ok 1 - adds an item
+not ok 2 - computes the total
+  ---
+  message: total = 1, want 0
+  severity: fail
+  duration_ms: 250
+  ...
+ok 3 - charges the card # SKIP no network
+not ok 4 - rounds prices # TODO fix rounding
+`
+	store, ingestion := readReport(t, "", "cart.tap", tap)
+	report := onlyReport(t, ingestion)
+	if report.Format != FormatTAP {
+		t.Errorf("format = %q, want %q", report.Format, FormatTAP)
+	}
+
+	wantCases := "adds an item=pass,computes the total=fail,charges the card=skipped,rounds prices=skipped,[plan]=error"
+	if got := strings.Join(store.caseStatuses(), ","); got != wantCases {
+		t.Errorf("test cases = %s, want %s", got, wantCases)
+	}
+	if got := strings.Join(report.Warnings, "; "); got != "planned 5 tests but ran 4" {
+		t.Errorf("warnings = %q, want the unmet plan", got)
+	}
+	if plan := store.failuresOf(store.testCase(t, "[plan]").ID); len(plan) != 1 || plan[0].Message != "planned 5 tests but ran 4" {
+		t.Errorf("failures of [plan] = %+v, want the unmet plan", plan)
+	}
+
+	suite := store.suite(t, "cart.tap")
+	if suite.Tests != 5 || suite.Failures != 1 || suite.Errors != 1 || suite.Skipped != 2 {
+		t.Errorf("suite = %+v, want 5 tests, 1 failure, 1 error and 2 skipped", suite)
+	}
+	if got := store.output(t, suite.SystemOutID); got != "# cart\n" {
+		t.Errorf("suite output = %q, want the comment lines", got)
+	}
+
+	total := store.testCase(t, "computes the total")
+	if total.ClassName != "cart.tap" || total.Time != 0.25 || total.Message == nil || *total.Message != "total = 1, want 0" ||
+		total.Type == nil || *total.Type != "fail" {
+		t.Errorf("computes the total = %+v, want class cart.tap, time 0.25 and the diagnostics message and severity", total)
+	}
+	if skipped := store.testCase(t, "charges the card"); skipped.Message == nil || *skipped.Message != "no network" {
+		t.Errorf("charges the card message = %v, want the SKIP reason", skipped.Message)
+	}
+	if todo := store.testCase(t, "rounds prices"); todo.Message == nil || *todo.Message != "TODO fix rounding" {
+		t.Errorf("rounds prices message = %v, want the TODO reason", todo.Message)
+	}
+}
+
+func TestAddTAPKeepsInvalidDiagnostics(t *testing.T) {
+	const tap = "not ok 1\n  ---\n  message: [unclosed\n  ...\n1..1\n"
+	store, ingestion := readReport(t, FormatTAP, "broken.tap", tap)
+	report := onlyReport(t, ingestion)
+	if len(report.Warnings) != 1 || !strings.HasPrefix(report.Warnings[0], "test 1: invalid YAML diagnostics") {
+		t.Errorf("warnings = %q, want one for the invalid diagnostics", report.Warnings)
+	}
+	testCase := store.testCase(t, "test 1")
+	if failures := store.failuresOf(testCase.ID); len(failures) != 1 || failures[0].Body != "message: [unclosed" {
+		t.Errorf("failures of test 1 = %+v, want the raw diagnostics as the body", failures)
+	}
+}
+
+func TestAddTAPBailOut(t *testing.T) {
+	store, ingestion := readReport(t, FormatTAP, "db.tap", "1..3\nok 1 - connects\nBail out! database is down\n")
+	onlyReport(t, ingestion)
+	wantCases := "connects=pass,Bail out!=error,[plan]=error"
+	if got := strings.Join(store.caseStatuses(), ","); got != wantCases {
+		t.Errorf("test cases = %s, want %s", got, wantCases)
+	}
+	if bail := store.testCase(t, "Bail out!"); bail.Message == nil || *bail.Message != "database is down" {
+		t.Errorf("Bail out! message = %v, want the reason", bail.Message)
+	}
+}
+
+func TestAddTAPRejectsOtherText(t *testing.T) {
+	_, ingestion := readReport(t, FormatTAP, "notes.txt", "all good\n")
+	if len(ingestion.files) != 1 || !strings.Contains(ingestion.files[0].Error, "no TAP plan or test lines") {
+		t.Errorf("files = %+v, want an error for text without TAP lines", ingestion.files)
+	}
+}
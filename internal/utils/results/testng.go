@@ -0,0 +1,372 @@
+package results
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TestNGSuite represents the attributes of a <suite> or <test> element of a TestNG report.
+type TestNGSuite struct {
+	Name       string  `xml:"name,attr"`
+	DurationMS float64 `xml:"duration-ms,attr"`
+	StartedAt  string  `xml:"started-at,attr"`
+}
+
+// TestNGGroup represents a <group> of a TestNG suite and the methods that belong to it.
+type TestNGGroup struct {
+	Name    string `xml:"name,attr"`
+	Methods []struct {
+		Signature string `xml:"signature,attr"`
+	} `xml:"method"`
+}
+
+// TestNGClass represents a <class> element of a TestNG report with its test methods.
+type TestNGClass struct {
+	Name    string         `xml:"name,attr"`
+	Methods []TestNGMethod `xml:"test-method"`
+}
+
+// TestNGMethod represents a <test-method> of a TestNG report: a single invocation of a test or
+// configuration method. Data-driven tests have one invocation per set of parameters.
+type TestNGMethod struct {
+	Status         string           `xml:"status,attr"` // PASS, FAIL or SKIP
+	Signature      string           `xml:"signature,attr"`
+	Name           string           `xml:"name,attr"`
+	IsConfig       bool             `xml:"is-config,attr"`
+	DurationMS     float64          `xml:"duration-ms,attr"`
+	Description    string           `xml:"description,attr"`
+	DataProvider   string           `xml:"data-provider,attr"`
+	InstanceName   string           `xml:"test-instance-name,attr"`
+	Retried        bool             `xml:"retried,attr"` // Whether the invocation failed and was run again
+	Params         []TestNGParam    `xml:"params>param"`
+	Exception      *TestNGException `xml:"exception"`
+	ReporterOutput []string         `xml:"reporter-output>line"`
+}
+
+// TestNGParam represents a parameter of a TestNG test method invocation.
+type TestNGParam struct {
+	Index int `xml:"index,attr"`
+	Value struct {
+		IsNull bool   `xml:"is-null,attr"`
+		Text   string `xml:",chardata"`
+	} `xml:"value"`
+}
+
+// TestNGException represents the exception of a failed or skipped TestNG test method.
+type TestNGException struct {
+	Class          string `xml:"class,attr"`
+	Message        string `xml:"message"`
+	FullStacktrace string `xml:"full-stacktrace"`
+}
+
+// testNGReport collects the state of a TestNG report while it is read.
+type testNGReport struct {
+	ingestion *Ingestion
+	groups    map[string][]string // Group names by method signature, for the current <suite>
+	suite     *openSuite
+	test      *openSuite
+	zones     map[string]bool // Unresolved time zones that were warned about
+}
+
+// AddTestNG decodes a TestNG report (testng-results.xml) from reader and adds it to the ingestion.
+//
+// Every <suite> becomes a test suite and every <test> of it a nested suite holding the test
+// methods of its classes. Each invocation of a test method becomes a test case; invocations of
+// data-driven tests are told apart by their parameters. Groups become properties named "group".
+// Invocations that TestNG retried are stored as reruns of the invocation that ran last, and
+// failed configuration methods as test cases with an error. Suites are counted from their test
+// cases since TestNG does not write per-suite totals.
+//
+// Elements that are not understood are skipped and recorded as warnings of the report.
+//
+// Parameters:
+// - reader: The reader providing the TestNG report.
+//
+// Returns:
+// - error: A *ParseError if the report is not a TestNG report, or an error if saving the models fails.
+func (ingestion *Ingestion) AddTestNG(reader io.Reader) error {
+	decoder := xml.NewDecoder(reader)
+	report := &testNGReport{ingestion: ingestion}
+
+	var root JUnitTestSuites
+	sawRoot := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &ParseError{Err: err}
+		}
+
+		switch element := token.(type) {
+		case xml.StartElement:
+			if !sawRoot {
+				sawRoot = true
+				if element.Name.Local != "testng-results" {
+					return &ParseError{Err: fmt.Errorf("unexpected root element <%s>", element.Name.Local)}
+				}
+				continue
+			}
+			if err := report.decodeElement(decoder, element, &root); err != nil {
+				return err
+			}
+
+		case xml.EndElement:
+			switch {
+			case element.Name.Local == "test" && report.test != nil:
+				setSuiteModelTotals(&report.test.model, report.test.suite)
+				if err := ingestion.closeTestSuite(report.test); err != nil {
+					return err
+				}
+				addSuiteTotals(&report.suite.suite, report.test.suite)
+				report.test = nil
+			case element.Name.Local == "suite" && report.suite != nil:
+				setSuiteModelTotals(&report.suite.model, report.suite.suite)
+				if err := ingestion.closeTestSuite(report.suite); err != nil {
+					return err
+				}
+				root.TestSuites = append(root.TestSuites, report.suite.suite)
+				report.suite = nil
+			}
+		}
+	}
+
+	if !sawRoot {
+		return &ParseError{Err: errors.New("report has no root element")}
+	}
+	addReportTotals(&ingestion.result, root)
+	return ingestion.flushIfFull()
+}
+
+// checkStartTime warns about a start time of a <suite> or <test> that is not recognized, or whose
+// time zone is not resolved so its wall time is stored as UTC. Each such zone is warned about once.
+func (report *testNGReport) checkStartTime(attributes TestNGSuite) {
+	if attributes.StartedAt == "" {
+		return
+	}
+	if parseTimestamp(attributes.StartedAt) == nil {
+		report.ingestion.warn("suite %q: unrecognized start time %q", attributes.Name, attributes.StartedAt)
+		return
+	}
+	if zone := unresolvedTimeZone(attributes.StartedAt); zone != "" && !report.zones[zone] {
+		if report.zones == nil {
+			report.zones = map[string]bool{}
+		}
+		report.zones[zone] = true
+		report.ingestion.warn("suite %q: time zone %s of start time %q is not resolved; its wall time is stored as UTC",
+			attributes.Name, zone, attributes.StartedAt)
+	}
+}
+
+// decodeElement decodes an element of a TestNG report below the root element.
+//
+// Parameters:
+// - decoder: The decoder positioned right after the element's start element.
+// - element: The start element.
+// - root: The top-level suites of the report, whose timestamp is taken from the first suite.
+//
+// Returns:
+// - error: A *ParseError if the element cannot be decoded, or an error if saving the models fails.
+func (report *testNGReport) decodeElement(decoder *xml.Decoder, element xml.StartElement, root *JUnitTestSuites) error {
+	ingestion := report.ingestion
+
+	switch {
+	case element.Name.Local == "suite" && report.suite == nil:
+		var attributes TestNGSuite
+		if err := decodeAttributes(element, &attributes); err != nil {
+			return &ParseError{Err: err}
+		}
+		if root.Timestamp == "" {
+			root.Timestamp = attributes.StartedAt
+		}
+		report.checkStartTime(attributes)
+		suite := JUnitTestSuite{Name: attributes.Name, Timestamp: attributes.StartedAt}
+		model, err := createTestSuiteModel(suite, ingestion.result.ID, nil)
+		if err != nil {
+			return err
+		}
+		report.suite = &openSuite{suite: suite, model: model}
+		report.groups = map[string][]string{}
+		return nil
+
+	case element.Name.Local == "groups" && report.suite != nil && report.test == nil:
+		var groups struct {
+			Groups []TestNGGroup `xml:"group"`
+		}
+		if err := decoder.DecodeElement(&groups, &element); err != nil {
+			return &ParseError{Err: err}
+		}
+		for _, group := range groups.Groups {
+			for _, method := range group.Methods {
+				report.groups[method.Signature] = append(report.groups[method.Signature], group.Name)
+			}
+		}
+		return nil
+
+	case element.Name.Local == "test" && report.suite != nil && report.test == nil:
+		var attributes TestNGSuite
+		if err := decodeAttributes(element, &attributes); err != nil {
+			return &ParseError{Err: err}
+		}
+		report.checkStartTime(attributes)
+		test := JUnitTestSuite{Name: attributes.Name, Timestamp: attributes.StartedAt}
+		model, err := createTestSuiteModel(test, ingestion.result.ID, &report.suite.model.ID)
+		if err != nil {
+			return err
+		}
+		report.test = &openSuite{suite: test, model: model}
+		return nil
+
+	case element.Name.Local == "class" && report.test != nil:
+		var class TestNGClass
+		if err := decoder.DecodeElement(&class, &element); err != nil {
+			return &ParseError{Err: err}
+		}
+		return report.addClass(class)
+
+	case element.Name.Local == "reporter-output" && report.suite == nil:
+		// Output of the whole run, which has no suite to be stored on.
+	default:
+		ingestion.warn("ignored <%s> element", element.Name.Local)
+	}
+
+	if err := decoder.Skip(); err != nil {
+		return &ParseError{Err: err}
+	}
+	return nil
+}
+
+// addClass adds the test method invocations of a class to the current <test> suite.
+// Retried invocations are kept until the invocation that ran last, which they are added to as reruns.
+func (report *testNGReport) addClass(class TestNGClass) error {
+	retried := map[string][]TestNGMethod{}
+	var order []string
+
+	for _, method := range class.Methods {
+		if method.IsConfig && method.Status != "FAIL" {
+			continue
+		}
+		key := testNGInvocationName(method)
+		if method.Retried {
+			if _, ok := retried[key]; !ok {
+				order = append(order, key)
+			}
+			retried[key] = append(retried[key], method)
+			continue
+		}
+		if err := report.addMethod(class.Name, method, retried[key]); err != nil {
+			return err
+		}
+		delete(retried, key)
+	}
+
+	// Retried invocations without a final invocation are stored on their own.
+	for _, key := range order {
+		for _, method := range retried[key] {
+			if err := report.addMethod(class.Name, method, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// addMethod adds a test method invocation, and the retried invocations preceding it, as a test case.
+func (report *testNGReport) addMethod(className string, method TestNGMethod, retries []TestNGMethod) error {
+	if method.Status != "PASS" && method.Status != "FAIL" && method.Status != "SKIP" {
+		report.ingestion.warn("%s.%s: unknown status %q", className, method.Name, method.Status)
+	}
+	testCase := createTestNGTestCase(className, method, report.groups[method.Signature])
+	for _, retry := range retries {
+		rerun := Rerun{}
+		if retry.Exception != nil {
+			rerun.Message = strings.TrimSpace(retry.Exception.Message)
+			rerun.Type = retry.Exception.Class
+			rerun.StackTrace = strings.TrimSpace(retry.Exception.FullStacktrace)
+		}
+		if method.Status == "PASS" {
+			testCase.FlakyFailures = append(testCase.FlakyFailures, rerun)
+		} else {
+			testCase.RerunFailures = append(testCase.RerunFailures, rerun)
+		}
+	}
+
+	countTestCase(&report.test.suite, testCase)
+	if err := report.ingestion.batch.addTestCases([]JUnitTestCase{testCase}, report.test.model.ID); err != nil {
+		return err
+	}
+	return report.ingestion.flushIfFull()
+}
+
+// createTestNGTestCase converts a TestNG test method invocation into a JUnitTestCase.
+//
+// Parameters:
+// - className: The name of the class declaring the method.
+// - method: The test method invocation.
+// - groups: The groups the method belongs to.
+//
+// Returns:
+// - JUnitTestCase: The test case, with a failure, error or skipped element depending on the status.
+func createTestNGTestCase(className string, method TestNGMethod, groups []string) JUnitTestCase {
+	testCase := JUnitTestCase{
+		ClassName: className,
+		Name:      testNGInvocationName(method),
+		Time:      method.DurationMS / 1000,
+		SystemOut: strings.Join(method.ReporterOutput, "\n"),
+	}
+	for _, group := range groups {
+		testCase.Properties = append(testCase.Properties, Property{Name: "group", Value: group})
+	}
+	if method.Description != "" {
+		testCase.Properties = append(testCase.Properties, Property{Name: "description", Value: method.Description})
+	}
+	if method.DataProvider != "" {
+		testCase.Properties = append(testCase.Properties, Property{Name: "data-provider", Value: method.DataProvider})
+	}
+
+	var message, exceptionClass, stackTrace string
+	if method.Exception != nil {
+		message = strings.TrimSpace(method.Exception.Message)
+		exceptionClass = method.Exception.Class
+		stackTrace = strings.TrimSpace(method.Exception.FullStacktrace)
+	}
+	switch {
+	case method.IsConfig:
+		testCase.Errors = []Error{{Message: message, Type: exceptionClass, Text: stackTrace}}
+	case method.Status == "PASS":
+	case method.Status == "FAIL":
+		testCase.Failures = []Failure{{Message: message, Type: exceptionClass, Text: stackTrace}}
+	case method.Status == "SKIP":
+		testCase.Skipped = &Skipped{Message: message}
+	default:
+		testCase.Errors = []Error{{Message: fmt.Sprintf("unknown status %q", method.Status), Type: method.Status}}
+	}
+	return testCase
+}
+
+// testNGInvocationName returns the name of a test method invocation. The test instance name and
+// parameters are added to the method name, so the invocations of data-driven tests and of factory
+// created instances get names of their own.
+func testNGInvocationName(method TestNGMethod) string {
+	name := method.Name
+	if method.InstanceName != "" && method.InstanceName != method.Name {
+		name += "[" + method.InstanceName + "]"
+	}
+	if len(method.Params) == 0 {
+		return name
+	}
+
+	values := make([]string, len(method.Params))
+	for i, param := range method.Params {
+		values[i] = strings.TrimSpace(param.Value.Text)
+		if param.Value.IsNull {
+			values[i] = "null"
+		}
+	}
+	return name + "(" + strings.Join(values, ", ") + ")"
+}
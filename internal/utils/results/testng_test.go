@@ -0,0 +1,124 @@
+package results
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testNGResults is a testng-results.xml whose suite starts in a time zone that is not resolved.
+const testNGResults = `<?xml version="1.0" encoding="UTF-8"?>
+<testng-results skipped="1" failed="2" total="5" passed="2">
+  <reporter-output/>
+  <suite name="Checkout" duration-ms="1500" started-at="2024-05-01T10:00:00 CET">
+    <groups>
+      <group name="smoke"><method signature="addsItem()" name="addsItem" class="com.shop.CartTest"/></group>
+      <group name="fast"><method signature="addsItem()" name="addsItem" class="com.shop.CartTest"/></group>
+    </groups>
+    <test name="Cart" duration-ms="1000" started-at="2024-05-01T10:00:00 UTC">
+      <class name="com.shop.CartTest">
+        <test-method status="FAIL" is-config="true" name="setUp" signature="setUp()" duration-ms="1">
+          <exception class="java.lang.IllegalStateException"><message>no database</message></exception>
+        </test-method>
+        <test-method status="PASS" is-config="true" name="tearDown" signature="tearDown()"/>
+        <test-method status="PASS" name="addsItem" signature="addsItem()" duration-ms="120" description="adds one item">
+          <reporter-output><line>added</line><line>1 item</line></reporter-output>
+        </test-method>
+        <test-method status="FAIL" retried="true" name="paysByCard" signature="paysByCard()">
+          <exception class="java.lang.AssertionError"><message>timed out</message><full-stacktrace>at CartTest.java:30</full-stacktrace></exception>
+        </test-method>
+        <test-method status="PASS" name="paysByCard" signature="paysByCard()"/>
+        <test-method status="FAIL" name="computesTotal" signature="computesTotal()" data-provider="totals">
+          <params><param index="0"><value>1</value></param><param index="1"><value is-null="true"/></param></params>
+          <exception class="java.lang.AssertionError"><message> expected 1 </message></exception>
+        </test-method>
+        <test-method status="SKIP" name="refunds" signature="refunds()">
+          <exception class="org.testng.SkipException"><message>not ready</message></exception>
+        </test-method>
+      </class>
+    </test>
+    <test name="Payments" started-at="2024-05-01T10:00:01 CET">
+      <class name="com.shop.PaymentTest">
+        <test-method status="FAIL" retried="true" name="paysLater" signature="paysLater()"/>
+      </class>
+    </test>
+  </suite>
+</testng-results>`
+
+func TestAddTestNG(t *testing.T) {
+	store, ingestion := readReport(t, "", "testng-results.xml", testNGResults)
+	report := onlyReport(t, ingestion)
+	if report.Format != FormatTestNG {
+		t.Errorf("format = %q, want %q", report.Format, FormatTestNG)
+	}
+
+	wantCases := "setUp=error,addsItem=pass,paysByCard=flaky,computesTotal(1, null)=fail,refunds=skipped,paysLater=fail"
+	if got := strings.Join(store.caseStatuses(), ","); got != wantCases {
+		t.Errorf("test cases = %s, want %s", got, wantCases)
+	}
+
+	checkout := store.suite(t, "Checkout")
+	cart := store.suite(t, "Cart")
+	if cart.ParentID == nil || *cart.ParentID != checkout.ID {
+		t.Errorf("Cart parent = %v, want the Checkout suite", cart.ParentID)
+	}
+	if cart.Tests != 5 || cart.Failures != 1 || cart.Errors != 1 || cart.Skipped != 1 {
+		t.Errorf("Cart totals = %d/%d/%d/%d, want 5 tests, 1 failure, 1 error and 1 skipped",
+			cart.Tests, cart.Failures, cart.Errors, cart.Skipped)
+	}
+	if checkout.Tests != 6 || checkout.Failures != 2 {
+		t.Errorf("Checkout totals = %d tests and %d failures, want 6 and 2 summed from its tests", checkout.Tests, checkout.Failures)
+	}
+	wantStart := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if checkout.ExecutedAt == nil || !checkout.ExecutedAt.Equal(wantStart) {
+		t.Errorf("Checkout executed at = %v, want the wall time %v", checkout.ExecutedAt, wantStart)
+	}
+	wantWarning := `suite "Checkout": time zone CET of start time "2024-05-01T10:00:00 CET" is not resolved; its wall time is stored as UTC`
+	if got := strings.Join(report.Warnings, "; "); got != wantWarning {
+		t.Errorf("warnings = %q, want a single warning for the CET start time", report.Warnings)
+	}
+
+	setUp := store.testCase(t, "setUp")
+	if failures := store.failuresOf(setUp.ID); len(failures) != 1 || failures[0].Message != "no database" ||
+		failures[0].Type != "java.lang.IllegalStateException" {
+		t.Errorf("failures of setUp = %+v, want the configuration failure as an error", failures)
+	}
+	addsItem := store.testCase(t, "addsItem")
+	if addsItem.ClassName != "com.shop.CartTest" || addsItem.Time != 0.12 || store.output(t, addsItem.SystemOutID) != "added\n1 item" {
+		t.Errorf("addsItem = %+v, want its class, time in seconds and reporter output", addsItem)
+	}
+	var properties []string
+	for _, property := range store.properties {
+		if property.TestCaseID != nil && *property.TestCaseID == addsItem.ID {
+			properties = append(properties, property.Name+"="+property.Value)
+		}
+	}
+	if got := strings.Join(properties, ","); got != "group=smoke,group=fast,description=adds one item" {
+		t.Errorf("properties of addsItem = %s, want its groups and description", got)
+	}
+
+	paysByCard := store.testCase(t, "paysByCard")
+	var reruns []string
+	for _, rerun := range store.reruns {
+		if rerun.TestCaseID == paysByCard.ID {
+			reruns = append(reruns, rerun.Message)
+		}
+	}
+	if strings.Join(reruns, ",") != "timed out" {
+		t.Errorf("reruns of paysByCard = %q, want the retried invocation", reruns)
+	}
+	computesTotal := store.testCase(t, "computesTotal(1, null)")
+	if failures := store.failuresOf(computesTotal.ID); len(failures) != 1 || failures[0].Message != "expected 1" {
+		t.Errorf("failures of computesTotal = %+v, want the trimmed exception message", failures)
+	}
+	if refunds := store.testCase(t, "refunds"); refunds.Message == nil || *refunds.Message != "not ready" {
+		t.Errorf("refunds message = %v, want the skip reason", refunds.Message)
+	}
+}
+
+func TestAddTestNGRejectsOtherDocuments(t *testing.T) {
+	_, ingestion := readReport(t, FormatTestNG, "results.xml", `<testsuite name="Checkout"/>`)
+	if len(ingestion.files) != 1 || !strings.Contains(ingestion.files[0].Error, "unexpected root element <testsuite>") {
+		t.Errorf("files = %+v, want an error for a JUnit report", ingestion.files)
+	}
+}
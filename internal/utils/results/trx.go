@@ -0,0 +1,245 @@
+package results
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// TRXTestRun represents the attributes of the <TestRun> root element of a TRX report.
+type TRXTestRun struct {
+	ID   string `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+}
+
+// TRXTimes represents the <Times> element of a TRX report.
+type TRXTimes struct {
+	Creation string `xml:"creation,attr"`
+	Start    string `xml:"start,attr"`
+	Finish   string `xml:"finish,attr"`
+}
+
+// TRXUnitTest represents a <UnitTest> test definition of a TRX report.
+type TRXUnitTest struct {
+	ID         string `xml:"id,attr"`
+	Name       string `xml:"name,attr"`
+	Storage    string `xml:"storage,attr"`
+	TestMethod struct {
+		ClassName string `xml:"className,attr"`
+		Name      string `xml:"name,attr"`
+		CodeBase  string `xml:"codeBase,attr"`
+	} `xml:"TestMethod"`
+}
+
+// TRXUnitTestResult represents a <UnitTestResult> of a TRX report.
+// Data-driven tests hold the result of every data row in InnerResults.
+type TRXUnitTestResult struct {
+	ExecutionID  string              `xml:"executionId,attr"`
+	TestID       string              `xml:"testId,attr"`
+	TestName     string              `xml:"testName,attr"`
+	ComputerName string              `xml:"computerName,attr"`
+	Duration     string              `xml:"duration,attr"`
+	StartTime    string              `xml:"startTime,attr"`
+	Outcome      string              `xml:"outcome,attr"`
+	Output       TRXOutput           `xml:"Output"`
+	InnerResults []TRXUnitTestResult `xml:"InnerResults>UnitTestResult"`
+}
+
+// TRXOutput represents the <Output> element of a TRX test result.
+type TRXOutput struct {
+	StdOut    string `xml:"StdOut"`
+	StdErr    string `xml:"StdErr"`
+	ErrorInfo struct {
+		Message    string `xml:"Message"`
+		StackTrace string `xml:"StackTrace"`
+	} `xml:"ErrorInfo"`
+}
+
+// trxReport collects the state of a TRX report while it is read.
+type trxReport struct {
+	ingestion   *Ingestion
+	definitions map[string]TRXUnitTest
+	pending     []TRXUnitTestResult // Results read before their test definitions, held until the end of the report
+	suites      *suiteGroups
+}
+
+// AddTRX decodes a TRX (MSTest/VSTest) report from reader and adds it to the ingestion.
+//
+// Test results are grouped into one test suite per test class, taken from the <TestDefinitions>
+// of the report. Each <UnitTestResult> becomes a test case; data-driven tests are stored as one
+// test case per data row. The <ErrorInfo> message and stack trace become the failure of the test
+// case and <StdOut>/<StdErr> its output.
+//
+// A result needs its test definition to find its class, but VSTest and MSTest write <Results>
+// before <TestDefinitions>, so their reports are held in memory in full, within the maximum
+// report size, and stored once the definitions have been read. Results that follow their
+// definitions, as written by some other tools, are added as soon as they are read.
+//
+// Parameters:
+// - reader: The reader providing the TRX report.
+//
+// Returns:
+// - error: A *ParseError if the report is not a TRX report, or an error if saving the models fails.
+func (ingestion *Ingestion) AddTRX(reader io.Reader) error {
+	decoder := xml.NewDecoder(reader)
+	report := &trxReport{
+		ingestion:   ingestion,
+		definitions: map[string]TRXUnitTest{},
+		suites:      newSuiteGroups(ingestion),
+	}
+
+	var run TRXTestRun
+	var times TRXTimes
+	sawRoot := false
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &ParseError{Err: err}
+		}
+
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if !sawRoot {
+			sawRoot = true
+			if element.Name.Local != "TestRun" {
+				return &ParseError{Err: fmt.Errorf("unexpected root element <%s>", element.Name.Local)}
+			}
+			if err := decodeAttributes(element, &run); err != nil {
+				return &ParseError{Err: err}
+			}
+			continue
+		}
+
+		switch element.Name.Local {
+		case "Times":
+			if err := decoder.DecodeElement(&times, &element); err != nil {
+				return &ParseError{Err: err}
+			}
+		case "UnitTest":
+			var definition TRXUnitTest
+			if err := decoder.DecodeElement(&definition, &element); err != nil {
+				return &ParseError{Err: err}
+			}
+			report.definitions[definition.ID] = definition
+		case "UnitTestResult":
+			var result TRXUnitTestResult
+			if err := decoder.DecodeElement(&result, &element); err != nil {
+				return &ParseError{Err: err}
+			}
+			if _, ok := report.definitions[result.TestID]; !ok {
+				report.pending = append(report.pending, result)
+				continue
+			}
+			if err := report.addResult(result); err != nil {
+				return err
+			}
+		case "ResultSummary", "TestEntries", "TestLists", "TestSettings":
+			if err := decoder.Skip(); err != nil {
+				return &ParseError{Err: err}
+			}
+		}
+	}
+
+	if !sawRoot {
+		return &ParseError{Err: errors.New("report has no root element")}
+	}
+	for _, result := range report.pending {
+		if err := report.addResult(result); err != nil {
+			return err
+		}
+	}
+
+	root := JUnitTestSuites{Name: run.Name, Timestamp: times.Start}
+	report.suites.close(&root)
+	addReportTotals(&ingestion.result, root)
+	return ingestion.flushIfFull()
+}
+
+// addResult adds a test result, or the results of its data rows, as test cases of the suite of its test class.
+func (report *trxReport) addResult(result TRXUnitTestResult) error {
+	if len(result.InnerResults) > 0 {
+		for _, inner := range result.InnerResults {
+			if inner.TestID == "" {
+				inner.TestID = result.TestID
+			}
+			if err := report.addResult(inner); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Tests without a class are grouped by the assembly they are stored in.
+	definition := report.definitions[result.TestID]
+	name := definition.TestMethod.ClassName
+	if name == "" {
+		name = definition.Storage
+	}
+	return report.suites.add(name, definition.Storage, createTRXTestCase(result, definition))
+}
+
+// createTRXTestCase converts a TRX test result into a JUnitTestCase.
+//
+// Parameters:
+// - result: The TRX test result.
+// - definition: The test definition the result belongs to.
+//
+// Returns:
+// - JUnitTestCase: The test case, with a failure, error or skipped element depending on the outcome.
+func createTRXTestCase(result TRXUnitTestResult, definition TRXUnitTest) JUnitTestCase {
+	name := result.TestName
+	if name == "" {
+		name = definition.Name
+	}
+	testCase := JUnitTestCase{
+		ClassName: definition.TestMethod.ClassName,
+		Name:      name,
+		Time:      parseTRXDuration(result.Duration),
+		SystemOut: result.Output.StdOut,
+		SystemErr: result.Output.StdErr,
+	}
+
+	errorInfo := result.Output.ErrorInfo
+	switch result.Outcome {
+	case "Passed", "PassedButRunAborted", "Completed", "Warning":
+	case "Failed":
+		testCase.Failures = []Failure{{Message: errorInfo.Message, Text: errorInfo.StackTrace}}
+	case "NotExecuted", "Inconclusive", "NotRunnable", "Pending":
+		message := errorInfo.Message
+		if message == "" {
+			message = result.Outcome
+		}
+		testCase.Skipped = &Skipped{Message: message}
+	default:
+		// Error, Timeout, Aborted, Disconnected and outcomes of unfinished tests.
+		testCase.Errors = []Error{{Message: errorInfo.Message, Type: result.Outcome, Text: errorInfo.StackTrace}}
+	}
+	return testCase
+}
+
+// parseTRXDuration parses a TRX duration such as 00:00:01.2345678 into seconds.
+// Durations that cannot be parsed are returned as 0.
+func parseTRXDuration(value string) float64 {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return 0
+	}
+	var seconds float64
+	for _, part := range parts {
+		number, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0
+		}
+		seconds = seconds*60 + number
+	}
+	return seconds
+}
@@ -0,0 +1,114 @@
+package results
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// trxResultsFirst is a TRX report with its results before the test definitions, as VSTest and MSTest write them.
+const trxResultsFirst = `<?xml version="1.0" encoding="UTF-8"?>
+<TestRun id="run-1" name="ci@build 2024-05-01" xmlns="http://microsoft.com/schemas/VisualStudio/TeamTest/2010">
+  <Times creation="2024-05-01T10:00:00.0000000+02:00" start="2024-05-01T10:00:01.0000000+02:00" finish="2024-05-01T10:00:09.0000000+02:00" />
+  <Results>
+    <UnitTestResult executionId="e1" testId="t1" testName="AddsItem" computerName="agent-1" duration="00:00:01.5000000" outcome="Passed">
+      <Output><StdOut>added 1 item</StdOut></Output>
+    </UnitTestResult>
+    <UnitTestResult executionId="e2" testId="t2" testName="ComputesTotal" duration="00:00:00.2500000" outcome="Failed">
+      <Output>
+        <ErrorInfo>
+          <Message>Assert.AreEqual failed. Expected:&lt;0&gt;. Actual:&lt;1&gt;.</Message>
+          <StackTrace>   at Shop.Tests.CartTests.ComputesTotal() in CartTests.cs:line 20</StackTrace>
+        </ErrorInfo>
+      </Output>
+    </UnitTestResult>
+    <UnitTestResult executionId="e3" testId="t3" testName="AppliesDiscount" duration="00:00:00" outcome="NotExecuted" />
+    <UnitTestResult executionId="e4" testId="t4" testName="ChargesCard" duration="00:01:00" outcome="Timeout" />
+    <UnitTestResult executionId="e5" testId="t5" testName="RoundsPrice" outcome="Passed">
+      <InnerResults>
+        <UnitTestResult executionId="e6" testName="RoundsPrice (1.005)" duration="00:00:00.1000000" outcome="Passed" />
+        <UnitTestResult executionId="e7" testName="RoundsPrice (2.675)" duration="00:00:00.1000000" outcome="Failed" />
+      </InnerResults>
+    </UnitTestResult>
+  </Results>
+  <TestDefinitions>
+    <UnitTest name="AddsItem" storage="shop.tests.dll" id="t1"><TestMethod className="Shop.Tests.CartTests" name="AddsItem" /></UnitTest>
+    <UnitTest name="ComputesTotal" storage="shop.tests.dll" id="t2"><TestMethod className="Shop.Tests.CartTests" name="ComputesTotal" /></UnitTest>
+    <UnitTest name="AppliesDiscount" storage="shop.tests.dll" id="t3"><TestMethod className="Shop.Tests.CartTests" name="AppliesDiscount" /></UnitTest>
+    <UnitTest name="ChargesCard" storage="shop.tests.dll" id="t4"><TestMethod className="Shop.Tests.PaymentTests" name="ChargesCard" /></UnitTest>
+    <UnitTest name="RoundsPrice" storage="shop.tests.dll" id="t5"><TestMethod className="Shop.Tests.PriceTests" name="RoundsPrice" /></UnitTest>
+  </TestDefinitions>
+  <ResultSummary outcome="Failed"><Counters total="6" passed="2" failed="2" /></ResultSummary>
+</TestRun>`
+
+func TestAddTRXWithResultsBeforeDefinitions(t *testing.T) {
+	store, ingestion := readReport(t, "", "results.trx", trxResultsFirst)
+	report := onlyReport(t, ingestion)
+	if report.Format != FormatTRX {
+		t.Errorf("format = %q, want %q", report.Format, FormatTRX)
+	}
+
+	wantSuites := "Shop.Tests.CartTests,Shop.Tests.PaymentTests,Shop.Tests.PriceTests"
+	if got := strings.Join(store.suiteNames(), ","); got != wantSuites {
+		t.Errorf("suites = %s, want %s", got, wantSuites)
+	}
+	wantCases := "AddsItem=pass,ComputesTotal=fail,AppliesDiscount=skipped,ChargesCard=error,RoundsPrice (1.005)=pass,RoundsPrice (2.675)=fail"
+	if got := strings.Join(store.caseStatuses(), ","); got != wantCases {
+		t.Errorf("test cases = %s, want %s", got, wantCases)
+	}
+	if report.Tests != 6 || report.Failures != 2 || report.Errors != 1 || report.Skipped != 1 {
+		t.Errorf("summary = %+v, want 6 tests, 2 failures, 1 error and 1 skipped", report)
+	}
+
+	cart := store.suite(t, "Shop.Tests.CartTests")
+	if cart.File != "shop.tests.dll" || cart.Tests != 3 || cart.Failures != 1 || cart.Skipped != 1 {
+		t.Errorf("cart suite = %+v, want file shop.tests.dll, 3 tests, 1 failure and 1 skipped", cart)
+	}
+	addsItem := store.testCase(t, "AddsItem")
+	if addsItem.ClassName != "Shop.Tests.CartTests" || addsItem.Time != 1.5 || store.output(t, addsItem.SystemOutID) != "added 1 item" {
+		t.Errorf("AddsItem = %+v, want class Shop.Tests.CartTests, time 1.5 and its output", addsItem)
+	}
+	computesTotal := store.testCase(t, "ComputesTotal")
+	failures := store.failuresOf(computesTotal.ID)
+	if len(failures) != 1 || !strings.HasPrefix(failures[0].Message, "Assert.AreEqual failed") ||
+		!strings.Contains(failures[0].Body, "CartTests.cs:line 20") {
+		t.Errorf("failures of ComputesTotal = %+v, want the error message and stack trace", failures)
+	}
+	if chargesCard := store.testCase(t, "ChargesCard"); chargesCard.Type == nil || *chargesCard.Type != "Timeout" {
+		t.Errorf("ChargesCard type = %v, want the outcome Timeout", chargesCard.Type)
+	}
+
+	if ingestion.result.Name != "ci@build 2024-05-01" {
+		t.Errorf("result name = %q, want the test run name", ingestion.result.Name)
+	}
+	want := time.Date(2024, 5, 1, 8, 0, 1, 0, time.UTC)
+	if executedAt := ingestion.result.ExecutedAt; executedAt == nil || !executedAt.Equal(want) {
+		t.Errorf("executed at = %v, want %v", executedAt, want)
+	}
+}
+
+func TestAddTRXRejectsOtherDocuments(t *testing.T) {
+	_, ingestion := readReport(t, FormatTRX, "results.trx", `<testsuites><testsuite name="a"/></testsuites>`)
+	if len(ingestion.files) != 1 || !strings.Contains(ingestion.files[0].Error, "unexpected root element <testsuites>") {
+		t.Errorf("files = %+v, want an error for the root element", ingestion.files)
+	}
+}
+
+func TestParseTRXDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{"00:00:01.5000000", 1.5},
+		{"00:01:00", 60},
+		{"01:02:03.25", 3723.25},
+		{"", 0},
+		{"1.5", 0},
+		{"00:xx:01", 0},
+	}
+	for _, test := range tests {
+		if got := parseTRXDuration(test.value); got != test.want {
+			t.Errorf("parseTRXDuration(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+// Package rules implements the expression language of results rules.
+//
+// An expression selects test suites or test cases. It is made of predicates combined with
+// "and", "or", "not" (or "!") and parentheses; "and" binds tighter than "or":
+//
+//	suite = "Payments*" and not (status = skipped or duration > 2s)
+//	class ~ "^com\.example\.(api|web)\." and property.browser = chrome
+//
+// A predicate compares a field with a value:
+//
+//   - name: the name of the suite or case the rule selects
+//   - suite, class, case: the suite name, class name and case name
+//   - status: the status of a case: pass, fail, error, skipped or flaky
+//   - file: the file of the suite or case
+//   - duration: the time of the suite or case, as seconds or with a unit (ms, s, m, h)
+//   - property.NAME or property."NAME": the values of a property of the suite or case
+//
+// "=" and "!=" match a glob anchored at both ends, where "*" matches any sequence of characters,
+// "?" a single character and "\" escapes the next character. "~" and "!~" match a regular
+// expression (RE2 syntax, unanchored, with repetition counts up to 255). Durations are also compared with "<", "<=", ">" and ">=".
+// Values are words or quoted strings. In a quoted string, a backslash before the quote stands for the
+// quote and every other backslash is part of the value, so globs and regular expressions are quoted
+// as they are written.
+//
+// A glob on its own is short for "name = glob", so "Payments*" and "!*Slow*" select suites or cases
+// by name. When a suite is selected, predicates on class, case and status hold if they hold for
+// any of its test cases.
+package rules
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Field is a property of a test suite or test case that predicates compare.
+type Field string
+
+const (
+	FieldName     Field = "name"
+	FieldSuite    Field = "suite"
+	FieldClass    Field = "class"
+	FieldCase     Field = "case"
+	FieldStatus   Field = "status"
+	FieldFile     Field = "file"
+	FieldDuration Field = "duration"
+	FieldProperty Field = "property"
+)
+
+// fields maps the names used in expressions to their fields. Properties are named property.NAME.
+var fields = map[string]Field{
+	"name":     FieldName,
+	"suite":    FieldSuite,
+	"class":    FieldClass,
+	"case":     FieldCase,
+	"status":   FieldStatus,
+	"file":     FieldFile,
+	"duration": FieldDuration,
+}
+
+// Statuses lists the statuses of test cases.
+var Statuses = []string{"pass", "fail", "error", "skipped", "flaky"}
+
+// Operator is the comparison of a predicate.
+type Operator string
+
+const (
+	OpEqual        Operator = "="
+	OpNotEqual     Operator = "!="
+	OpMatch        Operator = "~"
+	OpNotMatch     Operator = "!~"
+	OpLess         Operator = "<"
+	OpLessEqual    Operator = "<="
+	OpGreater      Operator = ">"
+	OpGreaterEqual Operator = ">="
+)
+
+// Targets of an expression: the kind of object the rule selects.
+const (
+	TargetSuite = "suite"
+	TargetCase  = "case"
+)
+
+// Node is a node of a compiled expression: *And, *Or, *Not or *Predicate.
+type Node interface {
+	matches(subject *Subject) bool
+}
+
+// And holds if both of its operands hold.
+type And struct {
+	Left, Right Node
+}
+
+// Or holds if any of its operands holds.
+type Or struct {
+	Left, Right Node
+}
+
+// Not holds if its operand does not hold.
+type Not struct {
+	Operand Node
+}
+
+// Predicate compares a field of a suite or case with a value.
+type Predicate struct {
+	Field    Field
+	Property string // Name of the property, for FieldProperty
+	Operator Operator
+	Value    string  // Glob or regular expression, as written
+	Wildcard bool    // Whether Value is a wildcard pattern of a legacy rule rather than a glob
+	Seconds  float64 // Value of a duration predicate, in seconds
+	Offset   int     // Byte offset of the predicate in the expression
+	pattern  *regexp.Regexp
+
+	postgresPattern string // Regular expression of "~" and "!~" in PostgreSQL syntax
+}
+
+// Expression is a compiled rule expression.
+type Expression struct {
+	Source string
+	Root   Node // nil for an empty expression, which selects everything
+}
+
+// Subject is a test suite or test case an expression is evaluated against.
+type Subject struct {
+	Target     string // TargetSuite or TargetCase
+	Suite      string // Name of the suite, or of the suite containing the case
+	Class      string
+	Case       string
+	Status     string
+	File       string
+	Duration   float64             // Time in seconds
+	Properties map[string][]string // Values of the properties of the suite or case, by name
+	Cases      []Subject           // Test cases of a suite, for predicates on case fields
+}
+
+// Matches evaluates the expression against a suite or case.
+//
+// Parameters:
+// - subject: The suite or case.
+//
+// Returns:
+// - bool: Whether the expression selects the subject.
+func (expression *Expression) Matches(subject Subject) bool {
+	if expression.Root == nil {
+		return true
+	}
+	return expression.Root.matches(&subject)
+}
+
+func (node *And) matches(subject *Subject) bool {
+	return node.Left.matches(subject) && node.Right.matches(subject)
+}
+
+func (node *Or) matches(subject *Subject) bool {
+	return node.Left.matches(subject) || node.Right.matches(subject)
+}
+
+func (node *Not) matches(subject *Subject) bool {
+	return !node.Operand.matches(subject)
+}
+
+func (node *Predicate) matches(subject *Subject) bool {
+	if subject.Target == TargetSuite && node.IsCaseField() {
+		for i := range subject.Cases {
+			if node.matches(&subject.Cases[i]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch node.Field {
+	case FieldDuration:
+		return node.compare(subject.Duration)
+	case FieldProperty:
+		// A negated property predicate holds when no value of the property matches.
+		matched := false
+		for _, value := range subject.Properties[node.Property] {
+			if node.pattern.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+		return matched != node.Negated()
+	}
+	return node.pattern.MatchString(node.stringValue(subject)) != node.Negated()
+}
+
+// IsCaseField reports whether the predicate compares a field that only test cases have.
+func (node *Predicate) IsCaseField() bool {
+	return node.Field == FieldClass || node.Field == FieldCase || node.Field == FieldStatus
+}
+
+// PostgresPattern returns the regular expression of a "~" or "!~" predicate translated into the
+// syntax of PostgreSQL, matching exactly the values the predicate's regular expression matches.
+func (node *Predicate) PostgresPattern() string {
+	return node.postgresPattern
+}
+
+// Negated reports whether the predicate holds when its glob or regular expression does not match.
+func (node *Predicate) Negated() bool {
+	return node.Operator == OpNotEqual || node.Operator == OpNotMatch
+}
+
+// stringValue returns the value of a text field of the subject.
+func (node *Predicate) stringValue(subject *Subject) string {
+	switch node.Field {
+	case FieldName:
+		if subject.Target == TargetSuite {
+			return subject.Suite
+		}
+		return subject.Case
+	case FieldSuite:
+		return subject.Suite
+	case FieldClass:
+		return subject.Class
+	case FieldCase:
+		return subject.Case
+	case FieldStatus:
+		return subject.Status
+	case FieldFile:
+		return subject.File
+	}
+	return ""
+}
+
+// compare compares a duration in seconds with the value of the predicate.
+func (node *Predicate) compare(seconds float64) bool {
+	switch node.Operator {
+	case OpEqual:
+		return seconds == node.Seconds
+	case OpNotEqual:
+		return seconds != node.Seconds
+	case OpLess:
+		return seconds < node.Seconds
+	case OpLessEqual:
+		return seconds <= node.Seconds
+	case OpGreater:
+		return seconds > node.Seconds
+	case OpGreaterEqual:
+		return seconds >= node.Seconds
+	}
+	return false
+}
+
+// globRegexp translates an anchored glob into a regular expression.
+// "*" matches any sequence of characters, "?" a single character and "\" escapes the next character.
+func globRegexp(glob string) string {
+	var pattern strings.Builder
+	pattern.WriteString(`(?s)^`)
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			pattern.WriteString(".*")
+		case '?':
+			pattern.WriteString(".")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			} else {
+				pattern.WriteString(`\\`)
+			}
+		default:
+			pattern.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	pattern.WriteString(`$`)
+	return pattern.String()
+}
+
+// wildcardRegexp translates a wildcard pattern of a legacy rule into an unanchored regular
+// expression: the parts separated by "*" must appear in order, anywhere in the value.
+func wildcardRegexp(wildcard string) string {
+	parts := strings.Split(wildcard, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return `(?s)` + strings.Join(parts, ".*")
+}
+
+// MatchGlob reports whether value matches an anchored glob, where "*" matches any sequence of
+// characters, "?" a single character and "\" escapes the next character.
+//
+// Parameters:
+// - value: The value to match.
+// - glob: The glob.
+//
+// Returns:
+// - bool: Whether the whole value matches the glob.
+func MatchGlob(value, glob string) bool {
+	return regexp.MustCompile(globRegexp(glob)).MatchString(value)
+}
@@ -0,0 +1,124 @@
+package rules
+
+import "testing"
+
+// checkoutSuite is a suite with a passing, a failing and a skipped test case.
+var checkoutSuite = Subject{
+	Target:     TargetSuite,
+	Suite:      "Payments and Refunds",
+	File:       "tests/payments_test.py",
+	Duration:   4.5,
+	Properties: map[string][]string{"browser": {"chrome", "firefox"}},
+	Cases: []Subject{
+		{Target: TargetCase, Suite: "Payments and Refunds", Class: "com.example.api.PayTest", Case: "refund_ok",
+			Status: "pass", File: "tests/payments_test.py", Duration: 0.2, Properties: map[string][]string{"tag": {"slow"}}},
+		{Target: TargetCase, Suite: "Payments and Refunds", Class: "com.example.web.PayTest", Case: `refund\timeout`,
+			Status: "fail", Duration: 2.5},
+		{Target: TargetCase, Suite: "Payments and Refunds", Class: "com.other", Case: "line1\nline2", Status: "skipped"},
+	},
+}
+
+func TestMatches(t *testing.T) {
+	refundOK, refundTimeout, multiline := checkoutSuite.Cases[0], checkoutSuite.Cases[1], checkoutSuite.Cases[2]
+	tests := []struct {
+		expression string
+		subject    Subject
+		want       bool
+	}{
+		{``, checkoutSuite, true},
+		{`Payments*`, checkoutSuite, true},
+		{`Payments*`, refundOK, false},
+		{`refund_*`, refundOK, true},
+		{`!refund_*`, refundTimeout, true},
+		{`"Payments and Refunds"`, checkoutSuite, true},
+		{`Payments and Refunds`, checkoutSuite, false},
+		{`name = "Payments?and?Refunds"`, checkoutSuite, true},
+		{`name = "payments*"`, checkoutSuite, false},
+		{`name = "refund\\*"`, refundTimeout, true},
+		{`name = "refund\\*"`, refundOK, false},
+		{`suite = "Pay*"`, refundOK, true},
+		{`file = ""`, refundTimeout, true},
+		{`file != "*"`, refundTimeout, false},
+
+		// Suites hold predicates on case fields if any of their cases does
+		{`status = fail`, checkoutSuite, true},
+		{`status = error`, checkoutSuite, false},
+		{`status != pass`, checkoutSuite, true},
+		{`class = "com.example.*" and status = skipped`, checkoutSuite, true},
+		{`status = fail`, refundOK, false},
+		{`status = "*"`, refundOK, true},
+
+		{`class ~ "^com\.example\.(api|web)\."`, refundOK, true},
+		{`class ~ "^com\.example\.(api|web)\."`, multiline, false},
+		{`class !~ "example"`, multiline, true},
+		{`case ~ "\btimeout"`, refundTimeout, true},
+		{`case ~ "\bok"`, refundOK, false},
+		{`case ~ "\\timeout"`, refundTimeout, true},
+		{`case ~ "(?i)^REFUND"`, refundOK, true},
+		{`case ~ "^line2$"`, multiline, false},
+		{`case ~ "(?m)^line2$"`, multiline, true},
+		{`case ~ "line1.line2"`, multiline, false},
+		{`case ~ "(?s)line1.line2"`, multiline, true},
+
+		{`duration > 2s`, refundTimeout, true},
+		{`duration > 2500ms`, refundTimeout, false},
+		{`duration >= 2500ms`, refundTimeout, true},
+		{`duration < 1`, multiline, true},
+		{`duration = 0`, multiline, true},
+		{`duration != 4.5`, checkoutSuite, false},
+
+		{`property.browser = chrome`, checkoutSuite, true},
+		{`property.browser = safari`, checkoutSuite, false},
+		{`property.browser != chrome`, checkoutSuite, false},
+		{`property.browser != safari`, checkoutSuite, true},
+		{`property.browser ~ "fox$"`, checkoutSuite, true},
+		{`property.tag = slow`, refundOK, true},
+		{`property.tag = slow`, checkoutSuite, false},
+		{`property.missing != "*"`, checkoutSuite, true},
+		{`property.missing = "*"`, checkoutSuite, false},
+
+		{`suite = "Pay*" and not (status = skipped or duration > 2s)`, refundOK, true},
+		{`suite = "Pay*" and not (status = skipped or duration > 2s)`, refundTimeout, false},
+		{`status = skipped or property.tag = slow`, refundOK, true},
+		{`!(status = pass)`, refundOK, false},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			expression, err := Compile(test.expression)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", test.expression, err)
+			}
+			if got := expression.Matches(test.subject); got != test.want {
+				t.Errorf("%q matches %s %q = %v, want %v", test.expression, test.subject.Target, test.subject.Suite+"/"+test.subject.Case, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		value string
+		glob  string
+		want  bool
+	}{
+		{"main", "main", true},
+		{"main", "ma*", true},
+		{"main", "m?in", true},
+		{"main", "m?n", false},
+		{"", "*", true},
+		{"", "?*", false},
+		{"release/1.0", "release/*", true},
+		{"a.b", "a?b", true},
+		{"axb", "a.b", false},
+		{"a*b", `a\*b`, true},
+		{"axb", `a\*b`, false},
+		{`a\b`, `a\\b`, true},
+		{`C:\`, `C:\`, true},
+		{"line1\nline2", "line1*", true},
+	}
+	for _, test := range tests {
+		if got := MatchGlob(test.value, test.glob); got != test.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", test.value, test.glob, got, test.want)
+		}
+	}
+}
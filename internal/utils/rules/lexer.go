@@ -0,0 +1,166 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenKind identifies the kind of a lexical token of an expression.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLeftParen
+	tokenRightParen
+)
+
+// token is a lexical token of an expression.
+type token struct {
+	kind   tokenKind
+	text   string // Word, unquoted string or operator
+	offset int    // Byte offset of the token in the expression
+}
+
+// describe returns a description of the token for syntax errors.
+func (t token) describe() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// SyntaxError reports an invalid rule expression.
+type SyntaxError struct {
+	Offset  int    // Byte offset in the expression where the error was found
+	Message string // Description of the error
+}
+
+// Error returns the message of the error together with its offset.
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at offset %d: %s", err.Offset, err.Message)
+}
+
+// operators lists the comparison operators, longest first so "!=" is not read as "!".
+var operators = []string{"!=", "!~", "<=", ">=", "=", "~", "<", ">"}
+
+// isWordByte reports whether c can be part of a word. Words hold field names, globs and numbers.
+func isWordByte(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '(', ')', '"', '\'', '=', '!', '~', '<', '>':
+		return false
+	}
+	return true
+}
+
+// tokenize splits an expression into tokens.
+//
+// Parameters:
+// - expression: The rule expression.
+//
+// Returns:
+// - []token: The tokens of the expression, ending with a tokenEOF.
+// - error: A *SyntaxError if a string is not terminated or a character is not expected.
+func tokenize(expression string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expression); {
+		c := expression[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLeftParen, text: "(", offset: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRightParen, text: ")", offset: i})
+			i++
+		case c == '"' || c == '\'':
+			text, end, err := readString(expression, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, offset: i})
+			i = end
+		case isWordByte(c):
+			start := i
+			for i < len(expression) && isWordByte(expression[i]) {
+				i++
+			}
+			word := expression[start:i]
+			kind := tokenWord
+			switch strings.ToLower(word) {
+			case "and":
+				kind = tokenAnd
+			case "or":
+				kind = tokenOr
+			case "not":
+				kind = tokenNot
+			}
+			tokens = append(tokens, token{kind: kind, text: word, offset: start})
+		default:
+			operator := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(expression[i:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				// Only a lone "!" is left, which negates like "not".
+				tokens = append(tokens, token{kind: tokenNot, text: "!", offset: i})
+				i++
+				continue
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: operator, offset: i})
+			i += len(operator)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, offset: len(expression)}), nil
+}
+
+// readString reads a quoted string starting at the opening quote. A backslash followed by the
+// quote stands for the quote; any other backslash is kept, together with the character it
+// escapes, so globs and regular expressions are written as they are, e.g. "^com\.example\." or
+// "*\\*" for names containing a backslash.
+//
+// Parameters:
+// - expression: The rule expression.
+// - start: The offset of the opening quote.
+//
+// Returns:
+// - string: The content of the string, with escaped quotes unescaped.
+// - int: The offset right after the closing quote.
+// - error: A *SyntaxError if the string is not terminated.
+func readString(expression string, start int) (string, int, error) {
+	quote := expression[start]
+	var text strings.Builder
+	for i := start + 1; i < len(expression); i++ {
+		switch expression[i] {
+		case '\\':
+			if i+1 < len(expression) && expression[i+1] == quote {
+				i++
+				text.WriteByte(quote)
+				continue
+			}
+			text.WriteByte('\\')
+			if i+1 < len(expression) {
+				i++
+				text.WriteByte(expression[i])
+			}
+		case quote:
+			return text.String(), i + 1, nil
+		default:
+			text.WriteByte(expression[i])
+		}
+	}
+	return "", 0, &SyntaxError{Offset: start, Message: "unterminated string"}
+}
@@ -0,0 +1,282 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// durationUnits maps the units accepted in duration values to their length in seconds.
+var durationUnits = map[string]float64{
+	"ms": 0.001,
+	"s":  1,
+	"m":  60,
+	"h":  3600,
+}
+
+// parser is a recursive descent parser over the tokens of an expression.
+type parser struct {
+	tokens   []token
+	position int
+}
+
+// Compile parses and checks a rule expression. Empty expressions select everything.
+//
+// Parameters:
+// - expression: The rule expression.
+//
+// Returns:
+// - *Expression: The compiled expression.
+// - error: A *SyntaxError pointing at the offset of the first error in the expression.
+func Compile(expression string) (*Expression, error) {
+	tokens, err := tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	compiled := &Expression{Source: expression}
+	if len(tokens) == 1 {
+		return compiled, nil
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if next := p.peek(); next.kind != tokenEOF {
+		return nil, p.unexpected(next, `"and", "or" or end of expression`)
+	}
+	compiled.Root = root
+	return compiled, nil
+}
+
+// peek returns the next token without consuming it.
+func (p *parser) peek() token {
+	return p.tokens[p.position]
+}
+
+// next consumes and returns the next token.
+func (p *parser) next() token {
+	t := p.tokens[p.position]
+	if t.kind != tokenEOF {
+		p.position++
+	}
+	return t
+}
+
+// unexpected returns a syntax error for an unexpected token.
+func (p *parser) unexpected(t token, expected string) error {
+	return &SyntaxError{Offset: t.offset, Message: fmt.Sprintf("expected %s, found %s", expected, t.describe())}
+}
+
+// parseOr parses operands separated by "or".
+func (p *parser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseAnd parses operands separated by "and".
+func (p *parser) parseAnd() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenAnd {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+// parseUnary parses an operand, optionally negated with "not" or "!".
+func (p *parser) parseUnary() (Node, error) {
+	if p.peek().kind == tokenNot {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+// parsePrimary parses a parenthesized expression, a predicate or a glob on its own.
+func (p *parser) parsePrimary() (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLeftParen:
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRightParen {
+			return nil, p.unexpected(closing, `")"`)
+		}
+		return node, nil
+	case tokenWord:
+		if p.peek().kind == tokenOperator || strings.EqualFold(t.text, "property.") {
+			return p.parsePredicate(t)
+		}
+		return newPredicate(FieldName, "", OpEqual, t)
+	case tokenString:
+		return newPredicate(FieldName, "", OpEqual, t)
+	}
+	return nil, p.unexpected(t, "a predicate, a glob or \"(\"")
+}
+
+// parsePredicate parses a predicate starting with the given field token.
+func (p *parser) parsePredicate(fieldToken token) (Node, error) {
+	name := strings.ToLower(fieldToken.text)
+	field, known := fields[name]
+	property := ""
+	if strings.HasPrefix(name, "property.") {
+		field = FieldProperty
+		property = fieldToken.text[len("property."):]
+		if property == "" {
+			// The property name is quoted: property."name"
+			nameToken := p.next()
+			if nameToken.kind != tokenString {
+				return nil, p.unexpected(nameToken, "a property name")
+			}
+			property = nameToken.text
+		}
+		known = true
+	}
+	if !known {
+		return nil, &SyntaxError{Offset: fieldToken.offset, Message: fmt.Sprintf("unknown field %q", fieldToken.text)}
+	}
+
+	operatorToken := p.next()
+	if operatorToken.kind != tokenOperator {
+		return nil, p.unexpected(operatorToken, "a comparison operator")
+	}
+	operator := Operator(operatorToken.text)
+	switch operator {
+	case OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+		if field != FieldDuration {
+			return nil, &SyntaxError{Offset: operatorToken.offset, Message: fmt.Sprintf("operator %s only applies to duration", operator)}
+		}
+	case OpMatch, OpNotMatch:
+		if field == FieldDuration {
+			return nil, &SyntaxError{Offset: operatorToken.offset, Message: fmt.Sprintf("operator %s does not apply to duration", operator)}
+		}
+	}
+
+	valueToken := p.next()
+	if valueToken.kind != tokenWord && valueToken.kind != tokenString {
+		return nil, p.unexpected(valueToken, "a value")
+	}
+	predicate, err := newPredicate(field, property, operator, valueToken)
+	if err != nil {
+		return nil, err
+	}
+	predicate.Offset = fieldToken.offset
+	return predicate, nil
+}
+
+// newPredicate creates a predicate and compiles its value.
+//
+// Parameters:
+// - field: The compared field.
+// - property: The name of the property, for FieldProperty.
+// - operator: The comparison.
+// - value: The token holding the value.
+//
+// Returns:
+// - *Predicate: The predicate.
+// - error: A *SyntaxError if the value is not valid for the field and operator.
+func newPredicate(field Field, property string, operator Operator, value token) (*Predicate, error) {
+	predicate := &Predicate{Field: field, Property: property, Operator: operator, Value: value.text, Offset: value.offset}
+
+	switch {
+	case field == FieldDuration:
+		seconds, err := parseDuration(value.text)
+		if err != nil {
+			return nil, &SyntaxError{Offset: value.offset, Message: err.Error()}
+		}
+		predicate.Seconds = seconds
+		return predicate, nil
+	case operator == OpMatch || operator == OpNotMatch:
+		pattern, err := regexp.Compile(value.text)
+		if err != nil {
+			return nil, &SyntaxError{Offset: value.offset, Message: "invalid regular expression: " + err.Error()}
+		}
+		postgres, err := postgresPattern(value.text)
+		if err != nil {
+			return nil, &SyntaxError{Offset: value.offset, Message: "unsupported regular expression: " + err.Error()}
+		}
+		predicate.pattern = pattern
+		predicate.postgresPattern = postgres
+		return predicate, nil
+	}
+
+	if field == FieldStatus && !strings.ContainsAny(value.text, `*?\`) && !isStatus(value.text) {
+		return nil, &SyntaxError{Offset: value.offset, Message: fmt.Sprintf("unknown status %q, expected one of %s", value.text, strings.Join(Statuses, ", "))}
+	}
+	predicate.pattern = regexp.MustCompile(globRegexp(value.text))
+	return predicate, nil
+}
+
+// parseDuration parses a duration value: a number of seconds, optionally followed by a unit.
+func parseDuration(value string) (float64, error) {
+	number, unit := value, "s"
+	for _, suffix := range []string{"ms", "s", "m", "h"} {
+		if strings.HasSuffix(value, suffix) {
+			number, unit = strings.TrimSuffix(value, suffix), suffix
+			break
+		}
+	}
+	seconds, err := strconv.ParseFloat(number, 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid duration %q, expected a number of seconds or a number with a unit (ms, s, m, h)", value)
+	}
+	return seconds * durationUnits[unit], nil
+}
+
+// isStatus reports whether value is a status of test cases.
+func isStatus(value string) bool {
+	for _, status := range Statuses {
+		if value == status {
+			return true
+		}
+	}
+	return false
+}
+
+// CompileWildcard compiles an expression made of a single wildcard pattern matched against the name
+// of the suite or case, negated by a leading "!". This was the whole syntax of rule expressions
+// before the expression language, and rules stored back then are flagged as legacy globs and read
+// this way, with the meaning they had: the parts of the pattern separated by "*" are found anywhere
+// in the name, in order, and every other character, "?" and "\" included, stands for itself.
+//
+// Parameters:
+// - pattern: The wildcard pattern, optionally prefixed with "!".
+//
+// Returns:
+// - *Expression: The compiled expression.
+func CompileWildcard(pattern string) *Expression {
+	negated := strings.HasPrefix(pattern, "!")
+	predicate := &Predicate{Field: FieldName, Operator: OpEqual, Value: strings.TrimPrefix(pattern, "!"), Wildcard: true}
+	if negated {
+		predicate.Operator = OpNotEqual
+	}
+	predicate.pattern = regexp.MustCompile(wildcardRegexp(predicate.Value))
+	return &Expression{Source: pattern, Root: predicate}
+}
@@ -0,0 +1,188 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// render writes a compiled expression in prefix notation, with property names and the seconds of
+// duration predicates, so tests can compare the parsed tree.
+func render(node Node) string {
+	switch node := node.(type) {
+	case nil:
+		return "all"
+	case *And:
+		return "(and " + render(node.Left) + " " + render(node.Right) + ")"
+	case *Or:
+		return "(or " + render(node.Left) + " " + render(node.Right) + ")"
+	case *Not:
+		return "(not " + render(node.Operand) + ")"
+	case *Predicate:
+		field := string(node.Field)
+		if node.Field == FieldProperty {
+			field += "." + node.Property
+		}
+		if node.Field == FieldDuration {
+			return fmt.Sprintf("(%s %s %g)", node.Operator, field, node.Seconds)
+		}
+		return fmt.Sprintf("(%s %s %q)", node.Operator, field, node.Value)
+	}
+	return fmt.Sprintf("%T", node)
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{``, `all`},
+		{`   `, `all`},
+		{`Payments*`, `(= name "Payments*")`},
+		{`"Payments and Refunds"`, `(= name "Payments and Refunds")`},
+		{`!*Slow*`, `(not (= name "*Slow*"))`},
+		{`Payments and Refunds`, `(and (= name "Payments") (= name "Refunds"))`},
+		{`a or b and c`, `(or (= name "a") (and (= name "b") (= name "c")))`},
+		{`(a or b) and c`, `(and (or (= name "a") (= name "b")) (= name "c"))`},
+		{`not not a`, `(not (not (= name "a")))`},
+		{`NOT a AND b OR c`, `(or (and (not (= name "a")) (= name "b")) (= name "c"))`},
+		{`suite = "Pay*" and not (status = skipped or duration > 2s)`,
+			`(and (= suite "Pay*") (not (or (= status "skipped") (> duration 2))))`},
+		{`Suite != Pay*`, `(!= suite "Pay*")`},
+		{`class ~ "^com\.example\."`, `(~ class "^com\\.example\\.")`},
+		{`case !~ '(?i)timeout'`, `(!~ case "(?i)timeout")`},
+		{`status = f*`, `(= status "f*")`},
+		{`file = 'a"b'`, `(= file "a\"b")`},
+		{`duration <= 250ms`, `(<= duration 0.25)`},
+		{`duration >= 1.5m`, `(>= duration 90)`},
+		{`duration < 2h`, `(< duration 7200)`},
+		{`duration = 0`, `(= duration 0)`},
+		{`property.browser = chrome`, `(= property.browser "chrome")`},
+		{`property."build tag" != "nightly*"`, `(!= property.build tag "nightly*")`},
+		{`property.os ~ "^linux"`, `(~ property.os "^linux")`},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			expression, err := Compile(test.expression)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", test.expression, err)
+			}
+			if got := render(expression.Root); got != test.want {
+				t.Errorf("Compile(%q) = %s, want %s", test.expression, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCompileSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		offset     int
+		message    string
+	}{
+		{`name = "Pay`, 7, "unterminated string"},
+		{`name = 'Pay\'`, 7, "unterminated string"},
+		{`(a or b`, 7, `expected ")"`},
+		{`a b`, 2, `expected "and", "or" or end of expression`},
+		{`a and`, 5, "expected a predicate"},
+		{`)`, 0, "expected a predicate"},
+		{`size > 1`, 0, `unknown field "size"`},
+		{`name > 1`, 5, "only applies to duration"},
+		{`duration ~ "1"`, 9, "does not apply to duration"},
+		{`duration > fast`, 11, "invalid duration"},
+		{`duration > -1`, 11, "invalid duration"},
+		{`status = passed`, 9, `unknown status "passed"`},
+		{`class ~ "("`, 8, "invalid regular expression"},
+		{`class ~ "a{1001}"`, 8, "invalid regular expression"},
+		{`class ~ "x{1,256}"`, 8, "unsupported regular expression"},
+		{`property. = x`, 10, "a property name"},
+		{`name =`, 6, "expected a value"},
+		{`name chrome`, 5, `expected "and", "or" or end of expression`},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			_, err := Compile(test.expression)
+			var syntaxError *SyntaxError
+			if !errors.As(err, &syntaxError) {
+				t.Fatalf("Compile(%q) = %v, want a *SyntaxError", test.expression, err)
+			}
+			if syntaxError.Offset != test.offset || !strings.Contains(syntaxError.Message, test.message) {
+				t.Errorf("Compile(%q) = %v, want offset %d and %q", test.expression, err, test.offset, test.message)
+			}
+		})
+	}
+}
+
+func TestReadString(t *testing.T) {
+	tests := []struct {
+		quoted string
+		want   string
+	}{
+		{`"plain"`, `plain`},
+		{`""`, ``},
+		{`"say \"hi\""`, `say "hi"`},
+		{`'it\'s'`, `it's`},
+		{`'say "hi"'`, `say "hi"`},
+		{`"it\'s"`, `it\'s`},
+		{`"^com\.example\."`, `^com\.example\.`},
+		{`"\d+\s\b"`, `\d+\s\b`},
+		{`"100\%"`, `100\%`},
+		{`"a\\b"`, `a\\b`},
+		{`"ends with \\"`, `ends with \\`},
+		{`"\\\""`, `\\"`},
+	}
+	for _, test := range tests {
+		t.Run(test.quoted, func(t *testing.T) {
+			got, end, err := readString(test.quoted, 0)
+			if err != nil {
+				t.Fatalf("readString(%q): %v", test.quoted, err)
+			}
+			if got != test.want || end != len(test.quoted) {
+				t.Errorf("readString(%q) = %q, %d, want %q, %d", test.quoted, got, end, test.want, len(test.quoted))
+			}
+		})
+	}
+}
+
+func TestCompileWildcard(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		// Legacy patterns that are also valid expressions keep their meaning as patterns
+		{"Payments and Refunds", "Payments and Refunds", true},
+		{"Payments and Refunds", "Payments", false},
+		{"Smoke or Regression", "Smoke or Regression", true},
+		{"not flaky", "not flaky", true},
+		{"not flaky", "stable", false},
+		{"name = x", "name = x", true},
+
+		// Parts are found anywhere in the name, in order
+		{"Pay", "Payments", true},
+		{"Pay", "xPayx", true},
+		{"Pay*Refund", "Payments and Refunds", true},
+		{"Refund*Pay", "Payments and Refunds", false},
+		{"*", "", true},
+		{"", "anything", true},
+		{"!*Slow*", "Checkout Slow path", false},
+		{"!*Slow*", "Checkout", true},
+		{"!Slow", "Checkout Slow path", false},
+
+		// "?" and "\" are plain characters
+		{"Refunds?v2", "RefundsXv2", false},
+		{"Refunds?v2", "Refunds?v2", true},
+		{`Pay\*`, "Pay*", false},
+		{`Pay\*`, `Pay\ments`, true},
+		{"100%", "100% done", true},
+		{"a_b", "axb", false},
+		{"line1*line2", "line1\nline2", true},
+	}
+	for _, test := range tests {
+		got := CompileWildcard(test.pattern).Matches(Subject{Target: TargetCase, Case: test.name})
+		if got != test.match {
+			t.Errorf("CompileWildcard(%q) matches %q = %v, want %v", test.pattern, test.name, got, test.match)
+		}
+	}
+}
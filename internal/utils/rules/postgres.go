@@ -0,0 +1,231 @@
+package rules
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// maxPostgresRepeat is the largest repetition count accepted by regular expressions of PostgreSQL.
+const maxPostgresRepeat = 255
+
+// postgresWordClass is the class of word characters of RE2, used for word boundaries.
+const postgresWordClass = `[0-9A-Za-z_]`
+
+// postgresPattern translates an RE2 regular expression into an advanced regular expression of
+// PostgreSQL that matches exactly the same strings.
+//
+// The pattern is rebuilt from the parsed expression rather than passed on as written, since the
+// two syntaxes give different meanings to the same text: "\b" is a word boundary in RE2 and a
+// backspace in PostgreSQL, "." matches newlines in PostgreSQL only, case folding and character
+// classes follow different tables. Literals and classes are written as explicit code points,
+// flags are expanded and word boundaries become lookaround constraints.
+//
+// Parameters:
+// - expression: The regular expression, in RE2 syntax.
+//
+// Returns:
+// - string: The regular expression, in PostgreSQL syntax.
+// - error: An error if the expression is invalid or uses a repetition count PostgreSQL does not accept.
+func postgresPattern(expression string) (string, error) {
+	re, err := syntax.Parse(expression, syntax.Perl)
+	if err != nil {
+		return "", err
+	}
+	var pattern strings.Builder
+	if err := writePostgres(&pattern, re); err != nil {
+		return "", err
+	}
+	return pattern.String(), nil
+}
+
+// writePostgres writes a node of a parsed regular expression in PostgreSQL syntax.
+func writePostgres(pattern *strings.Builder, re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		// PostgreSQL text never contains NUL, so this class matches nothing
+		pattern.WriteString(`[^\u0001-\U0010FFFF]`)
+	case syntax.OpEmptyMatch:
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			writePostgresRune(pattern, r, re.Flags&syntax.FoldCase != 0)
+		}
+	case syntax.OpCharClass:
+		writePostgresClass(pattern, re.Rune)
+	case syntax.OpAnyCharNotNL:
+		pattern.WriteString(`[^\u000A]`)
+	case syntax.OpAnyChar:
+		// "." matches newlines too, as PostgreSQL is not newline-sensitive by default
+		pattern.WriteString(".")
+	case syntax.OpBeginLine:
+		pattern.WriteString(`(?:^|(?<=\u000A))`)
+	case syntax.OpEndLine:
+		pattern.WriteString(`(?:$|(?=\u000A))`)
+	case syntax.OpBeginText:
+		pattern.WriteString("^")
+	case syntax.OpEndText:
+		pattern.WriteString("$")
+	case syntax.OpWordBoundary:
+		pattern.WriteString("(?:(?<=" + postgresWordClass + ")(?!" + postgresWordClass + ")|(?<!" + postgresWordClass + ")(?=" + postgresWordClass + "))")
+	case syntax.OpNoWordBoundary:
+		pattern.WriteString("(?:(?<=" + postgresWordClass + ")(?=" + postgresWordClass + ")|(?<!" + postgresWordClass + ")(?!" + postgresWordClass + "))")
+	case syntax.OpCapture:
+		pattern.WriteString("(?:")
+		if err := writePostgres(pattern, re.Sub[0]); err != nil {
+			return err
+		}
+		pattern.WriteString(")")
+	case syntax.OpStar:
+		return writePostgresRepeat(pattern, re.Sub[0], 0, -1)
+	case syntax.OpPlus:
+		return writePostgresRepeat(pattern, re.Sub[0], 1, -1)
+	case syntax.OpQuest:
+		return writePostgresRepeat(pattern, re.Sub[0], 0, 1)
+	case syntax.OpRepeat:
+		return writePostgresRepeat(pattern, re.Sub[0], re.Min, re.Max)
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if err := writePostgres(pattern, sub); err != nil {
+				return err
+			}
+		}
+	case syntax.OpAlternate:
+		pattern.WriteString("(?:")
+		for i, sub := range re.Sub {
+			if i > 0 {
+				pattern.WriteString("|")
+			}
+			if err := writePostgres(pattern, sub); err != nil {
+				return err
+			}
+		}
+		pattern.WriteString(")")
+	default:
+		return fmt.Errorf("unsupported construct in %s", re)
+	}
+	return nil
+}
+
+// writePostgresRepeat writes a repetition of a node, with max -1 for no upper bound.
+// Whether a string matches does not depend on greediness, so every repetition is written greedy.
+func writePostgresRepeat(pattern *strings.Builder, sub *syntax.Regexp, min, max int) error {
+	if min > maxPostgresRepeat || max > maxPostgresRepeat {
+		return fmt.Errorf("repetition count above %d", maxPostgresRepeat)
+	}
+	if isEmptyWidth(sub) {
+		// PostgreSQL does not repeat constraints; repeating one is the constraint or nothing
+		if min == 0 {
+			return nil
+		}
+		return writePostgres(pattern, sub)
+	}
+
+	pattern.WriteString("(?:")
+	if err := writePostgres(pattern, sub); err != nil {
+		return err
+	}
+	pattern.WriteString(")")
+	switch {
+	case min == 0 && max == -1:
+		pattern.WriteString("*")
+	case min == 1 && max == -1:
+		pattern.WriteString("+")
+	case min == 0 && max == 1:
+		pattern.WriteString("?")
+	case max == -1:
+		fmt.Fprintf(pattern, "{%d,}", min)
+	case min == max:
+		fmt.Fprintf(pattern, "{%d}", min)
+	default:
+		fmt.Fprintf(pattern, "{%d,%d}", min, max)
+	}
+	return nil
+}
+
+// isEmptyWidth reports whether a node only matches the empty string at some positions.
+func isEmptyWidth(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText,
+		syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return true
+	}
+	return false
+}
+
+// writePostgresRune writes a literal character, or the class of its case variants when folding case.
+func writePostgresRune(pattern *strings.Builder, r rune, foldCase bool) {
+	if foldCase {
+		variants := []rune{r}
+		for folded := unicode.SimpleFold(r); folded != r; folded = unicode.SimpleFold(folded) {
+			variants = append(variants, folded)
+		}
+		if len(variants) > 1 {
+			pattern.WriteString("[")
+			for _, variant := range variants {
+				writePostgresCodePoint(pattern, variant)
+			}
+			pattern.WriteString("]")
+			return
+		}
+	}
+	writePostgresCodePoint(pattern, r)
+}
+
+// writePostgresClass writes a character class given as sorted pairs of inclusive bounds.
+// Classes that include NUL, which PostgreSQL text never contains, are written as the complement.
+func writePostgresClass(pattern *strings.Builder, ranges []rune) {
+	if len(ranges) == 0 {
+		pattern.WriteString(`[^\u0001-\U0010FFFF]`)
+		return
+	}
+	if ranges[0] != 0 {
+		pattern.WriteString("[")
+		writePostgresRanges(pattern, ranges)
+		pattern.WriteString("]")
+		return
+	}
+
+	var complement []rune
+	next := rune(0)
+	for i := 0; i < len(ranges); i += 2 {
+		if ranges[i] > next {
+			complement = append(complement, next, ranges[i]-1)
+		}
+		next = ranges[i+1] + 1
+	}
+	if next <= unicode.MaxRune {
+		complement = append(complement, next, unicode.MaxRune)
+	}
+	if len(complement) == 0 {
+		pattern.WriteString(".")
+		return
+	}
+	pattern.WriteString("[^")
+	writePostgresRanges(pattern, complement)
+	pattern.WriteString("]")
+}
+
+// writePostgresRanges writes the ranges of a bracket expression.
+func writePostgresRanges(pattern *strings.Builder, ranges []rune) {
+	for i := 0; i < len(ranges); i += 2 {
+		writePostgresCodePoint(pattern, ranges[i])
+		if ranges[i+1] != ranges[i] {
+			pattern.WriteString("-")
+			writePostgresCodePoint(pattern, ranges[i+1])
+		}
+	}
+}
+
+// writePostgresCodePoint writes a character, as itself if it is an ASCII letter or digit and as an
+// escape otherwise, so that no character is taken for an operator in or outside of brackets.
+func writePostgresCodePoint(pattern *strings.Builder, r rune) {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		pattern.WriteRune(r)
+	case r <= 0xFFFF:
+		fmt.Fprintf(pattern, `\u%04X`, r)
+	default:
+		fmt.Fprintf(pattern, `\U%08X`, r)
+	}
+}
@@ -0,0 +1,61 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostgresPattern(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{`abc`, `abc`},
+		{`a.b`, `a[^\u000A]b`},
+		{`(?s)a.b`, `a.b`},
+		{`^com\.example\.`, `^com\u002Eexample\u002E`},
+		{`Slow$`, `Slow$`},
+		{`(?m)^ok$`, `(?:^|(?<=\u000A))ok(?:$|(?=\u000A))`},
+		{`\btimeout\b`, `(?:(?<=[0-9A-Za-z_])(?![0-9A-Za-z_])|(?<![0-9A-Za-z_])(?=[0-9A-Za-z_]))timeout(?:(?<=[0-9A-Za-z_])(?![0-9A-Za-z_])|(?<![0-9A-Za-z_])(?=[0-9A-Za-z_]))`},
+		{`\Bx`, `(?:(?<=[0-9A-Za-z_])(?=[0-9A-Za-z_])|(?<![0-9A-Za-z_])(?![0-9A-Za-z_]))x`},
+		{`\Aa\z`, `^a$`},
+		{`(api|web)\d+`, `(?:(?:api|web))(?:[0-9])+`},
+		{`(?i)ok`, `[Oo][Kk\u212A]`},
+		{`[^a]`, `[^a]`},
+		{`[\s\S]`, `.`},
+		{`x{2}y{1,3}z{4,}`, `(?:x){2}(?:y){1,3}(?:z){4,}`},
+		{`a*?b+?`, `(?:a)*(?:b)+`},
+		{`(?:^)*a`, `a`},
+		{`(?:$)+`, `$`},
+		{`é\x{1F600}`, `\u00E9\U0001F600`},
+		{`a|`, `(?:a|)`},
+		{`[%_\\]`, `[\u0025\u005C\u005F]`},
+	}
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			got, err := postgresPattern(test.expression)
+			if err != nil {
+				t.Fatalf("postgresPattern(%q): %v", test.expression, err)
+			}
+			if got != test.want {
+				t.Errorf("postgresPattern(%q) = %q, want %q", test.expression, got, test.want)
+			}
+		})
+	}
+}
+
+func TestPostgresPatternRejectsLargeRepetitions(t *testing.T) {
+	if _, err := postgresPattern(`a{256}`); err == nil || !strings.Contains(err.Error(), "repetition count") {
+		t.Errorf("err = %v, want a repetition count error", err)
+	}
+	if _, err := Compile(`name ~ "a{1,1000}"`); err == nil {
+		t.Errorf("Compile accepted a repetition count PostgreSQL rejects")
+	}
+	expression, err := Compile(`name ~ "a{255}"`)
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if got := expression.Root.(*Predicate).PostgresPattern(); got != `(?:a){255}` {
+		t.Errorf("PostgresPattern = %q", got)
+	}
+}